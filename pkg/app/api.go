@@ -0,0 +1,266 @@
+package app
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gitar/pkg/client"
+	"gitar/pkg/data"
+	"gitar/pkg/search"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	maxApiRequestSize = 1 << 20
+)
+
+type jobRequest struct {
+	Url string `json:"url"`
+	jobOptions
+}
+
+// syncFailure 是 /api/sync 中没有提交成功的仓库
+type syncFailure struct {
+	Url   string `json:"url"`
+	Error string `json:"error"`
+}
+
+// apiRoutes 注册 /api/ 下的 JSON 接口, 所有请求都需要 Authorization: Bearer <token>
+func (me *archiveServer) apiRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/api/", me.requireToken(func(w http.ResponseWriter, r *http.Request) {
+		writeApiError(w, http.StatusNotFound, "not found")
+	}))
+	mux.HandleFunc("/api/jobs", me.requireToken(me.handleJobs))
+	mux.HandleFunc("/api/jobs/", me.requireToken(me.handleJob))
+	mux.HandleFunc("/api/snapshots", me.requireToken(me.handleApiSnapshots))
+	mux.HandleFunc("/api/sync", me.requireToken(me.handleSync))
+	mux.HandleFunc("/api/search", me.requireToken(me.handleApiSearch))
+}
+
+// requireToken 校验 serve.token 或 serve.tokens 中的 Token, 没有配置 Token 时接口不可用
+func (me *archiveServer) requireToken(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokens := me.cfg.Serve.AllTokens()
+		if len(tokens) == 0 {
+			writeApiError(w, http.StatusForbidden, "api is disabled, set serve.token in config")
+			return
+		}
+		scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if strings.EqualFold(scheme, "Bearer") && token != "" {
+			for _, item := range tokens {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(item)) == 1 {
+					handler(w, r)
+					return
+				}
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gitar"`)
+		writeApiError(w, http.StatusUnauthorized, "invalid token")
+	}
+}
+
+// handleJobs GET 列出任务, POST 提交下载任务
+func (me *archiveServer) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet, http.MethodHead, http.MethodPost) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeApiJson(w, http.StatusOK, map[string]any{"jobs": me.jobs.List()})
+		return
+	}
+
+	request := new(jobRequest)
+	err := readApiRequest(w, r, request)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	request.Url = strings.TrimSpace(request.Url)
+	err = me.checkJobRequest(request.Url, request.jobOptions)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	job, created, err := me.jobs.Submit(request.Url, request.jobOptions)
+	if err != nil {
+		writeApiError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusAccepted
+	}
+	writeApiJson(w, status, job)
+}
+
+// handleJob 查询 /api/jobs/{id}
+func (me *archiveServer) handleJob(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/jobs/"), 10, 64)
+	if err != nil {
+		writeApiError(w, http.StatusNotFound, "not found")
+		return
+	}
+	job := me.jobs.Get(id)
+	if job == nil {
+		writeApiError(w, http.StatusNotFound, fmt.Sprintf("job %d not found", id))
+		return
+	}
+	writeApiJson(w, http.StatusOK, job)
+}
+
+// handleApiSnapshots 没有 repo 参数时列出仓库, 否则列出 owner/repo 的快照, 可以用 platform 过滤
+func (me *archiveServer) handleApiSnapshots(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+	query := r.URL.Query()
+	name := strings.Trim(query.Get("repo"), "/")
+	if name == "" {
+		repos, err := me.store.ListSnapshotRepos()
+		if err != nil {
+			apiServerError(w, err)
+			return
+		}
+		if repos == nil {
+			repos = []data.SnapshotRepo{}
+		}
+		writeApiJson(w, http.StatusOK, map[string]any{"repos": repos})
+		return
+	}
+
+	owner, repo, found := strings.Cut(name, "/")
+	if !found || owner == "" || repo == "" || strings.Contains(repo, "/") {
+		writeApiError(w, http.StatusBadRequest, "repo should be owner/repo")
+		return
+	}
+	snapshots, err := me.store.ListSnapshots(owner, repo)
+	if err != nil {
+		apiServerError(w, err)
+		return
+	}
+	platform := query.Get("platform")
+	matched := []data.Snapshot{}
+	for _, snapshot := range snapshots {
+		if platform == "" || snapshot.Platform == platform {
+			matched = append(matched, snapshot)
+		}
+	}
+	writeApiJson(w, http.StatusOK, map[string]any{"snapshots": matched})
+}
+
+// handleSync 为配置文件 repos 中的每个仓库提交一个下载任务, 请求体可以指定下载选项,
+// 无法解析或提交的仓库在 failed 中逐个返回, 不影响其它仓库
+func (me *archiveServer) handleSync(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	opts := jobOptions{}
+	err := readApiRequest(w, r, &opts)
+	if err != nil && !errors.Is(err, io.EOF) {
+		writeApiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(me.cfg.Repos) == 0 {
+		writeApiError(w, http.StatusBadRequest, "no repos in config")
+		return
+	}
+	_, err = parseAtDate(opts.At)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	jobs := []downloadJob{}
+	failed := []syncFailure{}
+	for _, repo := range me.cfg.Repos {
+		err = me.checkJobRequest(repo.Name, opts)
+		if err != nil {
+			failed = append(failed, syncFailure{Url: repo.Name, Error: err.Error()})
+			continue
+		}
+		job, _, err := me.jobs.Submit(repo.Name, opts)
+		if err != nil {
+			failed = append(failed, syncFailure{Url: repo.Name, Error: err.Error()})
+			continue
+		}
+		jobs = append(jobs, *job)
+	}
+	status := http.StatusAccepted
+	if len(jobs) == 0 {
+		status = http.StatusBadRequest
+	}
+	writeApiJson(w, status, map[string]any{"jobs": jobs, "failed": failed})
+}
+
+// handleApiSearch 参数与搜索页面相同, 返回匹配的行
+func (me *archiveServer) handleApiSearch(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+	form := parseSearchForm(r)
+	if form.Pattern == "" {
+		writeApiError(w, http.StatusBadRequest, "q is empty")
+		return
+	}
+	matches, truncated, status, err := me.search(r.Context(), form)
+	if status == http.StatusInternalServerError {
+		apiServerError(w, err)
+		return
+	}
+	if err != nil {
+		writeApiError(w, status, err.Error())
+		return
+	}
+	if matches == nil {
+		matches = []search.Match{}
+	}
+	writeApiJson(w, http.StatusOK, map[string]any{"matches": matches, "truncated": truncated})
+}
+
+// checkJobRequest 提交前检查 url 和日期, 以便直接返回 400 而不是等到任务失败
+func (me *archiveServer) checkJobRequest(url string, opts jobOptions) error {
+	if url == "" {
+		return errors.New("url is empty")
+	}
+	_, err := client.ParseRepoUrl(url, me.cfg)
+	if err != nil {
+		return err
+	}
+	_, err = parseAtDate(opts.At)
+	return err
+}
+
+func readApiRequest(w http.ResponseWriter, r *http.Request, v any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxApiRequestSize))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+func writeApiJson(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(v)
+	if err != nil {
+		logrus.Error(err)
+	}
+}
+
+func writeApiError(w http.ResponseWriter, status int, message string) {
+	writeApiJson(w, status, map[string]string{"error": message})
+}
+
+func apiServerError(w http.ResponseWriter, err error) {
+	logrus.Error(err)
+	writeApiError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+}
@@ -0,0 +1,264 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gitar/pkg/config"
+)
+
+const testApiToken = "secret"
+
+func newTestApiServer(t *testing.T, cfg *config.ConfigProperties) (*archiveServer, *httptest.Server) {
+	server := &archiveServer{cfg: cfg, jobs: newJobQueue()}
+	ts := httptest.NewServer(server.routes())
+	t.Cleanup(ts.Close)
+	return server, ts
+}
+
+// apiRequest 发送请求并解析 JSON 响应, token 为空时不带 Authorization
+func apiRequest(t *testing.T, ts *httptest.Server, method, path, token, body string, v any) *http.Response {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, ts.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(resp.Body)
+	if v != nil {
+		err = json.NewDecoder(resp.Body).Decode(v)
+		if err != nil {
+			t.Fatalf("%s %s: %s", method, path, err)
+		}
+	}
+	return resp
+}
+
+func TestApiRequiresToken(t *testing.T) {
+	_, disabled := newTestApiServer(t, &config.ConfigProperties{})
+	resp := apiRequest(t, disabled, http.MethodGet, "/api/jobs", testApiToken, "", nil)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("without serve.token: status = %d, want 403", resp.StatusCode)
+	}
+
+	cfg := &config.ConfigProperties{Serve: config.ServeProperties{Token: "other", Tokens: []string{testApiToken}}}
+	_, ts := newTestApiServer(t, cfg)
+	tests := []struct {
+		name   string
+		header string
+		status int
+	}{
+		{"missing", "", http.StatusUnauthorized},
+		{"wrong", "Bearer wrong", http.StatusUnauthorized},
+		{"wrong scheme", "Basic " + testApiToken, http.StatusUnauthorized},
+		{"empty bearer", "Bearer ", http.StatusUnauthorized},
+		{"token", "Bearer other", http.StatusOK},
+		{"tokens", "bearer " + testApiToken, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/jobs", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			resp, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.status == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") == "" {
+				t.Error("missing WWW-Authenticate")
+			}
+		})
+	}
+}
+
+func TestApiBadRequests(t *testing.T) {
+	cfg := &config.ConfigProperties{Serve: config.ServeProperties{Token: testApiToken}}
+	server, ts := newTestApiServer(t, cfg)
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"invalid json", http.MethodPost, "/api/jobs", "{", http.StatusBadRequest},
+		{"unknown field", http.MethodPost, "/api/jobs", `{"url": "golang/go", "foo": 1}`, http.StatusBadRequest},
+		{"empty url", http.MethodPost, "/api/jobs", `{"url": " "}`, http.StatusBadRequest},
+		{"unsupported url", http.MethodPost, "/api/jobs", `{"url": "https://example.com/a/b"}`, http.StatusBadRequest},
+		{"invalid date", http.MethodPost, "/api/jobs", `{"url": "golang/go", "at": "yesterday"}`, http.StatusBadRequest},
+		{"invalid repo", http.MethodGet, "/api/snapshots?repo=golang", "", http.StatusBadRequest},
+		{"sync without repos", http.MethodPost, "/api/sync", "", http.StatusBadRequest},
+		{"sync invalid json", http.MethodPost, "/api/sync", "{", http.StatusBadRequest},
+		{"unknown job", http.MethodGet, "/api/jobs/42", "", http.StatusNotFound},
+		{"invalid job id", http.MethodGet, "/api/jobs/abc", "", http.StatusNotFound},
+		{"unknown api", http.MethodGet, "/api/unknown", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := map[string]any{}
+			resp := apiRequest(t, ts, tt.method, tt.path, testApiToken, tt.body, &result)
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d: %v", resp.StatusCode, tt.status, result)
+			}
+			if result["error"] == nil {
+				t.Errorf("missing error in %v", result)
+			}
+		})
+	}
+	if jobs := server.jobs.List(); len(jobs) != 0 {
+		t.Errorf("rejected requests queued %d jobs", len(jobs))
+	}
+}
+
+func TestApiSyncQueuesValidRepos(t *testing.T) {
+	cfg := &config.ConfigProperties{
+		Serve: config.ServeProperties{Token: testApiToken},
+		Repos: []config.RepoProperties{
+			{Name: "kubernetes/kubernetes"},
+			{Name: "https://example.com/a/b"},
+			{Name: "golang/go"},
+		},
+	}
+	server, ts := newTestApiServer(t, cfg)
+
+	var result struct {
+		Jobs   []downloadJob `json:"jobs"`
+		Failed []syncFailure `json:"failed"`
+	}
+	// 没有请求体时使用默认选项
+	resp := apiRequest(t, ts, http.MethodPost, "/api/sync", testApiToken, "", &result)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", resp.StatusCode)
+	}
+	if len(result.Jobs) != 2 || result.Jobs[0].Url != "kubernetes/kubernetes" || result.Jobs[1].Url != "golang/go" {
+		t.Errorf("jobs = %+v", result.Jobs)
+	}
+	if len(result.Failed) != 1 || result.Failed[0].Url != "https://example.com/a/b" || result.Failed[0].Error == "" {
+		t.Errorf("failed = %+v", result.Failed)
+	}
+	if jobs := server.jobs.List(); len(jobs) != 2 {
+		t.Errorf("queued %d jobs, want 2", len(jobs))
+	}
+
+	// 再次同步时返回排队中的任务, 不重复提交
+	resp = apiRequest(t, ts, http.MethodPost, "/api/sync", testApiToken, `{"patch": false}`, &result)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", resp.StatusCode)
+	}
+	if jobs := server.jobs.List(); len(jobs) != 2 {
+		t.Errorf("queued %d jobs after second sync, want 2", len(jobs))
+	}
+
+	result.Jobs, result.Failed = nil, nil
+	resp = apiRequest(t, ts, http.MethodPost, "/api/sync", testApiToken, `{"at": "yesterday"}`, &result)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid date: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestApiJobStatus(t *testing.T) {
+	cfg := &config.ConfigProperties{Serve: config.ServeProperties{Token: testApiToken}}
+	server, ts := newTestApiServer(t, cfg)
+	release := make(chan struct{})
+	server.jobs.download = func(ctx context.Context, url string, opts DownloadOptions) error {
+		<-release
+		if url == "golang/go" {
+			return errors.New("download failed")
+		}
+		if opts.At.IsZero() {
+			return errors.New("missing --at")
+		}
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.jobs.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	submit := func(body string, status int) downloadJob {
+		var job downloadJob
+		resp := apiRequest(t, ts, http.MethodPost, "/api/jobs", testApiToken, body, &job)
+		if resp.StatusCode != status {
+			t.Fatalf("submit %s: status = %d, want %d", body, resp.StatusCode, status)
+		}
+		return job
+	}
+	first := submit(`{"url": "kubernetes/kubernetes", "at": "2023-06-01"}`, http.StatusAccepted)
+	second := submit(`{"url": "golang/go"}`, http.StatusAccepted)
+	// 排队中的相同任务直接返回
+	if again := submit(`{"url": "golang/go"}`, http.StatusOK); again.Id != second.Id {
+		t.Errorf("resubmitted job id = %d, want %d", again.Id, second.Id)
+	}
+
+	poll := func(id int64, state string) downloadJob {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			var job downloadJob
+			resp := apiRequest(t, ts, http.MethodGet, "/api/jobs/"+strconv.FormatInt(id, 10), testApiToken, "", &job)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("job %d: status = %d", id, resp.StatusCode)
+			}
+			if job.State == state {
+				return job
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("job %d: state = %s, want %s", id, job.State, state)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if job := poll(first.Id, JobRunning); job.Started == nil || job.Finished != nil {
+		t.Errorf("running job = %+v", job)
+	}
+	if job := poll(second.Id, JobQueued); job.Started != nil {
+		t.Errorf("queued job = %+v", job)
+	}
+	close(release)
+	if job := poll(first.Id, JobDone); job.Finished == nil || job.Error != "" {
+		t.Errorf("done job = %+v", job)
+	}
+	if job := poll(second.Id, JobFailed); job.Error != "download failed" {
+		t.Errorf("failed job = %+v", job)
+	}
+
+	var list struct {
+		Jobs []downloadJob `json:"jobs"`
+	}
+	apiRequest(t, ts, http.MethodGet, "/api/jobs", testApiToken, "", &list)
+	if len(list.Jobs) != 2 || list.Jobs[0].Id != second.Id || list.Jobs[1].Id != first.Id {
+		t.Errorf("jobs = %+v", list.Jobs)
+	}
+}
@@ -0,0 +1,124 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"gitar/pkg/client/common"
+	"gitar/pkg/config"
+	"gitar/pkg/data"
+	"gitar/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// downloadReleaseAssets 下载 Release 附件, 保存在归档文件旁边以归档名命名的目录中
+func downloadReleaseAssets(ctx context.Context, cfg *config.ConfigProperties, store data.DataStore,
+	arc *common.ArchiveInfo, repoCfg config.RepoProperties, destDir string) error {
+	if !repoCfg.Assets.Enabled || len(arc.Assets) <= 0 {
+		return nil
+	}
+
+	assetDir := filepath.Join(destDir, arc.Name)
+	for _, asset := range arc.Assets {
+		matched, err := matchAsset(asset.Name, repoCfg.Assets)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			logrus.Debugf("Asset skipped: %s", asset.Name)
+			continue
+		}
+
+		assetPath := filepath.Join(assetDir, asset.Name)
+		downloaded, err := store.IsAssetDownloaded(arc.Commit, asset.Name)
+		if err != nil {
+			return err
+		}
+		exists, err := utils.FileExists(assetPath)
+		if err != nil {
+			return err
+		}
+		if downloaded && exists {
+			logrus.Infof("Asset already downloaded: %s", asset.Name)
+			continue
+		}
+
+		err = downloadReleaseAsset(ctx, cfg, store, arc, asset, assetDir)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func downloadReleaseAsset(ctx context.Context, cfg *config.ConfigProperties, store data.DataStore,
+	arc *common.ArchiveInfo, asset common.AssetInfo, assetDir string) error {
+	logrus.Infof("Downloading asset: %s (%s)", asset.Name, utils.HumanReadableSize(asset.Size))
+
+	tempFile := fmt.Sprintf("%s-%s-%s", arc.Name, shortCommit(arc.Commit), asset.Name)
+	tempPath := filepath.Join(cfg.Paths.Temp, tempFile)
+	err := os.RemoveAll(tempPath)
+	if err != nil {
+		return err
+	}
+
+	err = utils.CurlDownload(ctx, asset.Url, cfg.Paths.Temp, tempFile, -1)
+	if err != nil {
+		removeTempFiles(tempPath)
+		return err
+	}
+
+	size, err := utils.GetFileSize(tempPath)
+	if err != nil {
+		return err
+	}
+	if asset.Size > 0 && size != asset.Size {
+		return fmt.Errorf("asset size mismatch: %s (expected %d, got %d)", asset.Name, asset.Size, size)
+	}
+
+	digest, err := utils.FileSha256(tempPath)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(assetDir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	assetPath := filepath.Join(assetDir, asset.Name)
+	err = utils.MoveFile(tempPath, assetPath)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Saved: %s (sha256:%s)", assetPath, digest)
+
+	return store.SaveAsset(arc.Commit, asset.Name, size, digest)
+}
+
+func matchAsset(name string, props config.AssetsProperties) (bool, error) {
+	for _, pattern := range props.Exclude {
+		matched, err := path.Match(pattern, name)
+		if err != nil {
+			return false, err
+		}
+		if matched {
+			return false, nil
+		}
+	}
+	if len(props.Include) <= 0 {
+		return true, nil
+	}
+	for _, pattern := range props.Include {
+		matched, err := path.Match(pattern, name)
+		if err != nil {
+			return false, err
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -1,8 +1,14 @@
 package app
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"gitar/pkg/utils"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 )
@@ -12,8 +18,11 @@ const (
 )
 
 func RunCliApp() error {
+	// 收到中断信号时取消 ctx, 由各环节终止子进程并清理临时文件
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	app := NewCliApp()
-	return app.Run(os.Args)
+	return app.RunContext(ctx, os.Args)
 }
 
 func NewCliApp() *cli.App {
@@ -23,6 +32,13 @@ func NewCliApp() *cli.App {
 		Description: "Git Archive & Repo Tool",
 		Commands: []*cli.Command{
 			NewDownloadCommand(),
+			NewExportCommand(),
+			NewExtractCommand(),
+			NewDiffCommand(),
+			NewIndexCommand(),
+			NewGrepCommand(),
+			NewServeCommand(),
+			NewDoctorCommand(),
 		},
 	}
 	return app
@@ -36,14 +52,244 @@ func NewDownloadCommand() *cli.Command {
 		Flags: []cli.Flag{
 			&cli.BoolFlag{Name: "debug", Required: false, Value: false},
 			&cli.BoolFlag{Name: "mail", Aliases: []string{"m"}, Required: false, Value: false},
+			&cli.BoolFlag{Name: "submodules", Required: false, Value: false},
+			&cli.BoolFlag{Name: "no-cache", Required: false, Value: false},
+			&cli.BoolFlag{Name: "patch", Required: false, Value: false, Usage: "also save the pull request patch"},
+			&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Required: false, Usage: "read urls from file"},
+			&cli.StringFlag{Name: "at", Required: false, Usage: "archive the branch as of the end of `YYYY-MM-DD`"},
 		},
 		Action: func(ctx *cli.Context) error {
 			debug := ctx.Bool("debug")
 			if debug {
 				logrus.SetLevel(logrus.DebugLevel)
 			}
-			url := ctx.Args().First()
-			return DownloadArchive(url, ctx.Bool("mail"))
+			urls := ctx.Args().Slice()
+			if file := ctx.String("file"); file != "" {
+				lines, err := utils.ReadLines(file)
+				if err != nil {
+					return err
+				}
+				urls = append(urls, lines...)
+			}
+			at, err := parseAtDate(ctx.String("at"))
+			if err != nil {
+				return err
+			}
+			opts := DownloadOptions{
+				Mail:       ctx.Bool("mail"),
+				Submodules: ctx.Bool("submodules"),
+				NoCache:    ctx.Bool("no-cache"),
+				Patch:      ctx.Bool("patch"),
+				At:         at,
+			}
+			return DownloadArchive(ctx.Context, urls, opts)
+		},
+	}
+}
+
+func NewExportCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "export",
+		Usage:     "Rebuild the tarball of a stored snapshot",
+		ArgsUsage: "<name|commit>",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "debug", Required: false, Value: false},
+			&cli.StringFlag{Name: "output", Aliases: []string{"o"}, Required: false, Usage: "output file, compressed if ends with .xz"},
+		},
+		Action: func(ctx *cli.Context) error {
+			debug := ctx.Bool("debug")
+			if debug {
+				logrus.SetLevel(logrus.DebugLevel)
+			}
+			if ctx.NArg() != 1 {
+				return fmt.Errorf("expect one snapshot name or commit")
+			}
+			return RunExport(ctx.Context, ctx.Args().First(), ctx.String("output"))
+		},
+	}
+}
+
+func NewExtractCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "extract",
+		Aliases:   []string{"checkout"},
+		Usage:     "Extract files from a stored snapshot",
+		ArgsUsage: "<owner/repo[@ref|sha]> [dest|-]",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "debug", Required: false, Value: false},
+			&cli.BoolFlag{Name: "strip", Required: false, Value: false, Usage: "strip the top-level directory"},
+			&cli.StringSliceFlag{Name: "include", Aliases: []string{"i"}, Required: false,
+				Usage: "only extract paths matching `GLOB`"},
+		},
+		Action: func(ctx *cli.Context) error {
+			debug := ctx.Bool("debug")
+			if debug {
+				logrus.SetLevel(logrus.DebugLevel)
+			}
+			if ctx.NArg() < 1 || ctx.NArg() > 2 {
+				return fmt.Errorf("expect a repo and an optional destination")
+			}
+			opts := ExtractOptions{
+				Strip:   ctx.Bool("strip"),
+				Include: ctx.StringSlice("include"),
+			}
+			return RunExtract(ctx.Context, ctx.Args().Get(0), ctx.Args().Get(1), opts)
+		},
+	}
+}
+
+func NewDiffCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "diff",
+		Usage:     "Compare two stored snapshots",
+		ArgsUsage: "<owner/repo@ref> <owner/repo@ref|@ref>",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "debug", Required: false, Value: false},
+			&cli.BoolFlag{Name: "unified", Aliases: []string{"u"}, Required: false, Value: false,
+				Usage: "print unified diff of text files"},
+			&cli.IntFlag{Name: "context", Aliases: []string{"U"}, Required: false, Value: 3,
+				Usage: "lines of context in unified diff"},
+			&cli.StringSliceFlag{Name: "include", Aliases: []string{"i"}, Required: false,
+				Usage: "only compare paths matching `GLOB`"},
+		},
+		Action: func(ctx *cli.Context) error {
+			debug := ctx.Bool("debug")
+			if debug {
+				logrus.SetLevel(logrus.DebugLevel)
+			}
+			if ctx.NArg() != 2 {
+				return fmt.Errorf("expect two snapshots")
+			}
+			opts := DiffOptions{
+				Unified: ctx.Bool("unified"),
+				Context: ctx.Int("context"),
+				Include: ctx.StringSlice("include"),
+			}
+			return RunDiff(ctx.Context, ctx.Args().Get(0), ctx.Args().Get(1), opts)
+		},
+	}
+}
+
+func NewIndexCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "index",
+		Usage: "Build the full-text search index of stored snapshots",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "debug", Required: false, Value: false},
+			&cli.StringSliceFlag{Name: "repo", Aliases: []string{"r"}, Required: false,
+				Usage: "only index `OWNER/REPO`"},
 		},
+		Action: func(ctx *cli.Context) error {
+			debug := ctx.Bool("debug")
+			if debug {
+				logrus.SetLevel(logrus.DebugLevel)
+			}
+			opts := IndexOptions{
+				Repos: ctx.StringSlice("repo"),
+			}
+			return RunIndex(ctx.Context, opts)
+		},
+	}
+}
+
+func NewGrepCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "grep",
+		Usage:     "Search stored snapshots with the full-text index",
+		ArgsUsage: "<pattern>",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "debug", Required: false, Value: false},
+			&cli.StringSliceFlag{Name: "repo", Aliases: []string{"r"}, Required: false,
+				Usage: "only search `OWNER/REPO`"},
+			&cli.StringSliceFlag{Name: "version", Required: false,
+				Usage: "only search snapshots matching `REF`, a tag, branch, commit or glob"},
+			&cli.StringSliceFlag{Name: "include", Required: false, Usage: "only search paths matching `GLOB`"},
+			&cli.BoolFlag{Name: "ignore-case", Aliases: []string{"i"}, Required: false, Value: false},
+			&cli.BoolFlag{Name: "fixed-strings", Aliases: []string{"F"}, Required: false, Value: false,
+				Usage: "treat pattern as a literal string"},
+			&cli.BoolFlag{Name: "files-with-matches", Aliases: []string{"l"}, Required: false, Value: false,
+				Usage: "only print matching files"},
+			&cli.BoolFlag{Name: "versions", Required: false, Value: false,
+				Usage: "only print matching snapshots, oldest first, with the number of matching lines"},
+			&cli.IntFlag{Name: "max-count", Aliases: []string{"m"}, Required: false, Value: 0,
+				Usage: "stop after `NUM` matching lines"},
+		},
+		Action: func(ctx *cli.Context) error {
+			debug := ctx.Bool("debug")
+			if debug {
+				logrus.SetLevel(logrus.DebugLevel)
+			}
+			if ctx.NArg() != 1 {
+				return fmt.Errorf("expect one pattern")
+			}
+			opts := GrepOptions{
+				Repos:        ctx.StringSlice("repo"),
+				Versions:     ctx.StringSlice("version"),
+				Include:      ctx.StringSlice("include"),
+				IgnoreCase:   ctx.Bool("ignore-case"),
+				Fixed:        ctx.Bool("fixed-strings"),
+				FilesOnly:    ctx.Bool("files-with-matches"),
+				VersionsOnly: ctx.Bool("versions"),
+				MaxCount:     ctx.Int("max-count"),
+			}
+			return RunGrep(ctx.Context, ctx.Args().Get(0), opts)
+		},
+	}
+}
+
+func NewServeCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "serve",
+		Usage: "Browse stored snapshots over HTTP",
+		Description: "Each snapshot is decompressed into paths.data/cache/archive on first access, so the cache " +
+			"takes up to the full uncompressed size of every opened snapshot. Least recently used archives are " +
+			"removed when the cache exceeds serve.cache-size (10 GiB by default).",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "debug", Required: false, Value: false},
+			&cli.StringFlag{Name: "listen", Aliases: []string{"l"}, Required: false,
+				Usage: "listen `ADDRESS`, defaults to serve.listen in config or " + DefaultServeListen},
+		},
+		Action: func(ctx *cli.Context) error {
+			debug := ctx.Bool("debug")
+			if debug {
+				logrus.SetLevel(logrus.DebugLevel)
+			}
+			opts := ServeOptions{
+				Listen: ctx.String("listen"),
+			}
+			return RunServe(ctx.Context, opts)
+		},
+	}
+}
+
+func NewDoctorCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "doctor",
+		Usage: "Check config, tools and GitHub API quota",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "debug", Required: false, Value: false},
+		},
+		Action: func(ctx *cli.Context) error {
+			debug := ctx.Bool("debug")
+			if debug {
+				logrus.SetLevel(logrus.DebugLevel)
+			}
+			return RunDoctor(ctx.Context)
+		},
+	}
+}
+
+// parseAtDate 解析 --at 参数, 只有日期时取当天结束的时刻
+func parseAtDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, expect YYYY-MM-DD", value)
 	}
+	return t.Add(24*time.Hour - time.Second), nil
 }
@@ -0,0 +1,362 @@
+package app
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gitar/pkg/config"
+	"gitar/pkg/data"
+	"gitar/pkg/diff"
+	"gitar/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// 超过该大小的文件不生成 unified diff
+	maxDiffFileSize = 1 << 20
+)
+
+type DiffOptions struct {
+	Unified bool
+	Context int
+	Include []string
+}
+
+// archiveEntry 是归档中一个文件的摘要, 路径去掉了顶层目录
+type archiveEntry struct {
+	Type   byte
+	Mode   int64
+	Size   int64
+	Sha256 string
+	Link   string
+}
+
+// diffSpool 在第一遍扫描时保存旧快照中较小文件的内容, 按 SHA-256 去重,
+// 输出 unified diff 时不需要再解压一遍快照
+type diffSpool struct {
+	file    *os.File
+	size    int64
+	offsets map[string]int64
+}
+
+// keepContentFunc 接收扫描时读到的较小的普通文件内容
+type keepContentFunc func(name string, entry *archiveEntry, content []byte) error
+
+type fileChange struct {
+	Kind byte
+	Path string
+	Old  *archiveEntry
+	New  *archiveEntry
+}
+
+// RunDiff 对比两个快照, 列出新增, 删除和修改的文件, 可选输出文本文件的 unified diff.
+// specB 可以只写 @ref, 表示与 specA 相同的仓库
+func RunDiff(ctx context.Context, specA, specB string, opts DiffOptions) error {
+	// 标准输出用于差异, 日志改为输出到标准错误
+	logrus.SetOutput(os.Stderr)
+	err := checkIncludePatterns(opts.Include)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(specB, "@") {
+		repo, _, _ := strings.Cut(specA, "@")
+		specB = repo + specB
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return err
+	}
+	store, err := openDataStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer func(store data.DataStore) {
+		_ = store.Close()
+	}(store)
+
+	oldSnapshot, err := resolveSnapshot(cfg, store, specA)
+	if err != nil {
+		return err
+	}
+	newSnapshot, err := resolveSnapshot(cfg, store, specB)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Diff: %s => %s", oldSnapshot.Name, newSnapshot.Name)
+
+	var spool *diffSpool
+	var keepOld, keepNew keepContentFunc
+	newContents := make(map[string][]byte)
+	if opts.Unified {
+		spool, err = newDiffSpool(cfg.Paths.Temp)
+		if err != nil {
+			return err
+		}
+		defer spool.Close()
+		keepOld = func(name string, entry *archiveEntry, content []byte) error {
+			return spool.Add(entry.Sha256, content)
+		}
+	}
+	oldEntries, err := scanArchiveEntries(ctx, cfg, oldSnapshot, opts.Include, keepOld)
+	if err != nil {
+		return err
+	}
+	if opts.Unified {
+		// 旧快照已经读完, 新快照只需要保留变化的文件
+		keepNew = func(name string, entry *archiveEntry, content []byte) error {
+			if oldEntry := oldEntries[name]; oldEntry == nil || *oldEntry != *entry {
+				newContents[name] = content
+			}
+			return nil
+		}
+	}
+	newEntries, err := scanArchiveEntries(ctx, cfg, newSnapshot, opts.Include, keepNew)
+	if err != nil {
+		return err
+	}
+
+	changes := compareEntries(oldEntries, newEntries)
+	counts := make(map[byte]int)
+	for _, change := range changes {
+		counts[change.Kind]++
+		if !opts.Unified {
+			fmt.Println(formatChange(change))
+		}
+	}
+	if opts.Unified && len(changes) > 0 {
+		err = printUnifiedDiff(oldSnapshot, newSnapshot, changes, spool, newContents, opts.Context)
+		if err != nil {
+			return err
+		}
+	}
+	logrus.Infof("Changes: %d added, %d removed, %d modified", counts['A'], counts['D'], counts['M'])
+	return nil
+}
+
+// scanArchiveEntries 读取快照中所有文件的摘要, 目录不参与对比.
+// keep 不为空时, 不超过 maxDiffFileSize 的普通文件内容会交给 keep
+func scanArchiveEntries(ctx context.Context, cfg *config.ConfigProperties, snapshot *data.Snapshot,
+	include []string, keep keepContentFunc) (map[string]*archiveEntry, error) {
+	opts := ExtractOptions{Strip: true, Include: include}
+	entries := make(map[string]*archiveEntry)
+	err := walkSnapshot(ctx, cfg, snapshot, func(header *tar.Header, r io.Reader) error {
+		if header.Typeflag == tar.TypeDir || header.Typeflag == tar.TypeXGlobalHeader {
+			return nil
+		}
+		name, ok := selectEntry(header.Name, opts)
+		if !ok {
+			return nil
+		}
+		entry := &archiveEntry{
+			Type: header.Typeflag,
+			Mode: header.Mode & 0777,
+			Size: header.Size,
+			Link: header.Linkname,
+		}
+		entries[name] = entry
+		if header.Typeflag != tar.TypeReg {
+			return nil
+		}
+
+		if keep == nil || header.Size > maxDiffFileSize {
+			hash := sha256.New()
+			_, err := io.Copy(hash, r)
+			if err != nil {
+				return err
+			}
+			entry.Sha256 = hex.EncodeToString(hash.Sum(nil))
+			return nil
+		}
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(content)
+		entry.Sha256 = hex.EncodeToString(hash[:])
+		return keep(name, entry, content)
+	})
+	return entries, err
+}
+
+// compareEntries 按路径排序返回两个快照之间的变化
+func compareEntries(oldEntries, newEntries map[string]*archiveEntry) []fileChange {
+	var changes []fileChange
+	for name, oldEntry := range oldEntries {
+		newEntry, found := newEntries[name]
+		if !found {
+			changes = append(changes, fileChange{Kind: 'D', Path: name, Old: oldEntry})
+			continue
+		}
+		if *oldEntry != *newEntry {
+			changes = append(changes, fileChange{Kind: 'M', Path: name, Old: oldEntry, New: newEntry})
+		}
+	}
+	for name, newEntry := range newEntries {
+		if _, found := oldEntries[name]; !found {
+			changes = append(changes, fileChange{Kind: 'A', Path: name, New: newEntry})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+func formatChange(change fileChange) string {
+	oldSize, newSize := "-", "-"
+	if change.Old != nil {
+		oldSize = utils.HumanReadableSize(int(change.Old.Size))
+	}
+	if change.New != nil {
+		newSize = utils.HumanReadableSize(int(change.New.Size))
+	}
+	line := fmt.Sprintf("%c %11s %11s  %s", change.Kind, oldSize, newSize, change.Path)
+	if change.New != nil && (change.New.Type == tar.TypeSymlink || change.New.Type == tar.TypeLink) {
+		line += " -> " + change.New.Link
+	}
+	if change.Old != nil && change.New != nil && change.Old.Mode != change.New.Mode {
+		line += fmt.Sprintf(" (mode %o => %o)", change.Old.Mode, change.New.Mode)
+	}
+	return line
+}
+
+// printUnifiedDiff 输出变化的文件的 unified diff, 旧内容从 spool 中读取, 新内容在 newContents 中
+func printUnifiedDiff(oldSnapshot, newSnapshot *data.Snapshot, changes []fileChange, spool *diffSpool,
+	newContents map[string][]byte, contextLines int) error {
+	for _, change := range changes {
+		oldName, newName := "/dev/null", "/dev/null"
+		if change.Old != nil {
+			oldName = oldSnapshot.Name + "/" + change.Path
+		}
+		if change.New != nil {
+			newName = newSnapshot.Name + "/" + change.Path
+		}
+		if !isDiffable(change.Old) || !isDiffable(change.New) {
+			switch change.Kind {
+			case 'A':
+				fmt.Printf("Added %s\n", describeEntry(newName, change.New))
+			case 'D':
+				fmt.Printf("Removed %s\n", describeEntry(oldName, change.Old))
+			default:
+				fmt.Printf("Changed %s to %s\n", describeEntry(oldName, change.Old), describeEntry(newName, change.New))
+			}
+			continue
+		}
+
+		oldContent, oldFound, err := spool.Content(change.Old)
+		if err != nil {
+			return err
+		}
+		newContent, newFound := []byte(nil), change.New == nil
+		if change.New != nil && change.New.Type == tar.TypeSymlink {
+			newContent, newFound = []byte(change.New.Link), true
+		} else if change.New != nil {
+			newContent, newFound = newContents[change.Path]
+		}
+		if !oldFound || !newFound {
+			fmt.Printf("Files %s and %s differ (too large)\n", oldName, newName)
+			continue
+		}
+		if !diff.IsText(oldContent) || !diff.IsText(newContent) {
+			fmt.Printf("Binary files %s and %s differ\n", oldName, newName)
+			continue
+		}
+		unified := diff.Unified(oldName, newName, oldContent, newContent, contextLines)
+		if unified != "" {
+			fmt.Print(unified)
+			continue
+		}
+		switch change.Kind {
+		case 'A':
+			fmt.Printf("Empty file %s added\n", newName)
+		case 'D':
+			fmt.Printf("Empty file %s removed\n", oldName)
+		default:
+			fmt.Printf("Mode of %s changed: %o => %o\n", change.Path, change.Old.Mode, change.New.Mode)
+		}
+	}
+	return nil
+}
+
+// isDiffable 判断条目能否输出 unified diff, 只有普通文件和符号链接可以, 不存在的一侧视为空文件
+func isDiffable(entry *archiveEntry) bool {
+	return entry == nil || entry.Type == tar.TypeReg || entry.Type == tar.TypeSymlink
+}
+
+// describeEntry 按类型描述不能输出 unified diff 的条目
+func describeEntry(name string, entry *archiveEntry) string {
+	switch entry.Type {
+	case tar.TypeReg:
+		return "file " + name
+	case tar.TypeSymlink:
+		return fmt.Sprintf("symbolic link %s -> %s", name, entry.Link)
+	case tar.TypeLink:
+		return fmt.Sprintf("hard link %s -> %s", name, entry.Link)
+	case tar.TypeChar:
+		return "character device " + name
+	case tar.TypeBlock:
+		return "block device " + name
+	case tar.TypeFifo:
+		return "fifo " + name
+	}
+	return fmt.Sprintf("entry %s (type %q)", name, entry.Type)
+}
+
+func newDiffSpool(dir string) (*diffSpool, error) {
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.CreateTemp(dir, "diff-*")
+	if err != nil {
+		return nil, err
+	}
+	return &diffSpool{file: file, offsets: make(map[string]int64)}, nil
+}
+
+// Add 保存内容, 相同的内容只保存一次
+func (me *diffSpool) Add(digest string, content []byte) error {
+	if _, found := me.offsets[digest]; found {
+		return nil
+	}
+	_, err := me.file.Write(content)
+	if err != nil {
+		return err
+	}
+	me.offsets[digest] = me.size
+	me.size += int64(len(content))
+	return nil
+}
+
+// Content 返回旧快照中条目的内容, 符号链接取链接目标, 没有保存内容时第二个返回值为 false
+func (me *diffSpool) Content(entry *archiveEntry) ([]byte, bool, error) {
+	if entry == nil {
+		return nil, true, nil
+	}
+	if entry.Type == tar.TypeSymlink {
+		return []byte(entry.Link), true, nil
+	}
+	offset, found := me.offsets[entry.Sha256]
+	if !found {
+		return nil, false, nil
+	}
+	content := make([]byte, entry.Size)
+	_, err := me.file.ReadAt(content, offset)
+	if err != nil {
+		return nil, false, err
+	}
+	return content, true, nil
+}
+
+func (me *diffSpool) Close() {
+	_ = me.file.Close()
+	removeTempFiles(me.file.Name())
+}
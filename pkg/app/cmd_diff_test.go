@@ -0,0 +1,148 @@
+package app
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gitar/pkg/config"
+	"gitar/pkg/data"
+	"gitar/pkg/utils"
+)
+
+type testTarEntry struct {
+	header  tar.Header
+	content string
+}
+
+// writeTestTarXz 生成 tar.xz 快照, 条目名会加上以快照名命名的顶层目录
+func writeTestTarXz(t *testing.T, dir, name string, entries []testTarEntry) *data.Snapshot {
+	buffer := new(bytes.Buffer)
+	tarWriter := tar.NewWriter(buffer)
+	for _, entry := range entries {
+		header := entry.header
+		header.Name = name + "/" + header.Name
+		header.Size = int64(len(entry.content))
+		if header.Mode == 0 {
+			header.Mode = 0644
+		}
+		err := tarWriter.WriteHeader(&header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = tarWriter.Write([]byte(entry.content))
+	}
+	_ = tarWriter.Close()
+
+	digest := sha256.Sum256(buffer.Bytes())
+	path := filepath.Join(dir, name+".tar.xz")
+	err := utils.CompressXz(context.Background(), buffer, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &data.Snapshot{Name: name, Path: path, Sha256: hex.EncodeToString(digest[:])}
+}
+
+// captureStdout 返回 fn 执行期间写入标准输出的内容
+func captureStdout(t *testing.T, fn func() error) string {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	output := make(chan string)
+	go func() {
+		content, _ := io.ReadAll(reader)
+		output <- string(content)
+	}()
+	err = fn()
+	os.Stdout = stdout
+	_ = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return <-output
+}
+
+func TestUnifiedDiffFromSingleScan(t *testing.T) {
+	if _, err := exec.LookPath("xz"); err != nil {
+		t.Skip("xz not found")
+	}
+	dir := t.TempDir()
+	cfg := &config.ConfigProperties{Paths: config.PathsProperties{Temp: filepath.Join(dir, "temp")}}
+	large := strings.Repeat("x", maxDiffFileSize+1)
+	oldSnapshot := writeTestTarXz(t, dir, "repo-v1", []testTarEntry{
+		{tar.Header{Name: "README.md", Typeflag: tar.TypeReg}, "hello\nworld\n"},
+		{tar.Header{Name: "same.txt", Typeflag: tar.TypeReg}, "unchanged\n"},
+		{tar.Header{Name: "removed.txt", Typeflag: tar.TypeReg}, "bye\n"},
+		{tar.Header{Name: "large.bin", Typeflag: tar.TypeReg}, large},
+		{tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "README.md"}, ""},
+		{tar.Header{Name: "hard", Typeflag: tar.TypeLink, Linkname: "repo-v1/same.txt"}, ""},
+	})
+	newSnapshot := writeTestTarXz(t, dir, "repo-v2", []testTarEntry{
+		{tar.Header{Name: "README.md", Typeflag: tar.TypeReg}, "hello\ngitar\n"},
+		{tar.Header{Name: "same.txt", Typeflag: tar.TypeReg}, "unchanged\n"},
+		{tar.Header{Name: "added.txt", Typeflag: tar.TypeReg}, "new\n"},
+		{tar.Header{Name: "large.bin", Typeflag: tar.TypeReg}, large + "y"},
+		{tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "same.txt"}, ""},
+		{tar.Header{Name: "hard", Typeflag: tar.TypeLink, Linkname: "repo-v2/README.md"}, ""},
+		{tar.Header{Name: "fifo", Typeflag: tar.TypeFifo}, ""},
+	})
+
+	spool, err := newDiffSpool(cfg.Paths.Temp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+	oldEntries, err := scanArchiveEntries(context.Background(), cfg, oldSnapshot, nil,
+		func(name string, entry *archiveEntry, content []byte) error {
+			return spool.Add(entry.Sha256, content)
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newContents := make(map[string][]byte)
+	newEntries, err := scanArchiveEntries(context.Background(), cfg, newSnapshot, nil,
+		func(name string, entry *archiveEntry, content []byte) error {
+			if oldEntry := oldEntries[name]; oldEntry == nil || *oldEntry != *entry {
+				newContents[name] = content
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := newContents["same.txt"]; found {
+		t.Error("unchanged files should not be kept")
+	}
+
+	changes := compareEntries(oldEntries, newEntries)
+	output := captureStdout(t, func() error {
+		return printUnifiedDiff(oldSnapshot, newSnapshot, changes, spool, newContents, 3)
+	})
+	for _, expected := range []string{
+		"+++ repo-v2/added.txt\n@@ -0,0 +1 @@\n+new\n",
+		"Added fifo repo-v2/fifo\n",
+		"Changed hard link repo-v1/hard -> repo-v1/same.txt to hard link repo-v2/hard -> repo-v2/README.md\n",
+		"Files repo-v1/large.bin and repo-v2/large.bin differ (too large)\n",
+		"-README.md\n\\ No newline at end of file\n+same.txt\n",
+		"-world\n+gitar\n",
+		"--- repo-v1/removed.txt\n+++ /dev/null\n@@ -1 +0,0 @@\n-bye\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("output should contain %q, got:\n%s", expected, output)
+		}
+	}
+	if strings.Contains(output, "same.txt\n---") || strings.Contains(output, "hard link repo-v1/hard differ") {
+		t.Errorf("unexpected output:\n%s", output)
+	}
+}
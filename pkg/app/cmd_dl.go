@@ -1,14 +1,17 @@
 package app
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"gitar/pkg/client"
+	"gitar/pkg/client/common"
 	"gitar/pkg/client/github"
 	"gitar/pkg/config"
 	"gitar/pkg/data"
@@ -17,15 +20,35 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func DownloadArchive(url string, shouldSendMail bool) error {
-	err := DoDownloadArchive(url, shouldSendMail)
+const (
+	// 等待其它进程下载同一 Commit 的最长时间
+	lockTimeout = 30 * time.Minute
+)
+
+type DownloadOptions struct {
+	Mail       bool
+	Submodules bool
+	NoCache    bool
+	Patch      bool
+	At         time.Time
+}
+
+func DownloadArchive(ctx context.Context, urls []string, opts DownloadOptions) error {
+	err := DoDownloadArchives(ctx, urls, opts)
 	if err == nil {
 		logrus.Infof("All done")
 	}
 	return err
 }
 
-func DoDownloadArchive(url string, shouldSendMail bool) error {
+func DoDownloadArchive(ctx context.Context, url string, opts DownloadOptions) error {
+	return DoDownloadArchives(ctx, []string{url}, opts)
+}
+
+func DoDownloadArchives(ctx context.Context, urls []string, opts DownloadOptions) error {
+	if len(urls) <= 0 {
+		return errors.New("url is empty")
+	}
 	logrus.Infof("Downloading archive")
 
 	cfg, err := config.LoadConfig()
@@ -33,26 +56,20 @@ func DoDownloadArchive(url string, shouldSendMail bool) error {
 		return err
 	}
 	logrus.Infof("Paths: %+v", cfg.Paths)
-
-	logrus.Infof("URL: %s", url)
-	repoUrl, err := client.ParseRepoUrl(url)
-	if err != nil {
-		return err
+	if opts.NoCache {
+		cfg.Cache.Disabled = true
 	}
-	logrus.Infof("Platform: %s", repoUrl.Platform)
-	logrus.Infof("Repository: %s/%s", repoUrl.Owner, repoUrl.Repo)
-	logrus.Infof("Parsed-Tag: %s", repoUrl.Tag)
-	logrus.Infof("Parsed-Branch: %s", repoUrl.Branch)
-	logrus.Infof("Parsed-Commit: %s", repoUrl.Commit)
 
-	arc, err := client.ResolveArchive(*repoUrl, cfg)
-	if err != nil {
-		return err
+	repoUrls := []common.RepoUrl{}
+	for _, url := range urls {
+		logrus.Infof("URL: %s", url)
+		repoUrl, err := client.ParseRepoUrl(url, cfg)
+		if err != nil {
+			return err
+		}
+		repoUrl.Until = opts.At
+		repoUrls = append(repoUrls, *repoUrl)
 	}
-	logrus.Infof("Archive-Name: %s", arc.Name)
-	logrus.Infof("Archive-Commit: %s", arc.Commit)
-	logrus.Infof("Archive-Tar: %s", arc.TarUrl)
-	logrus.Infof("Archive-Zip: %s", arc.ZipUrl)
 
 	if err = os.MkdirAll(cfg.Paths.Temp, os.ModePerm); err != nil {
 		return err
@@ -63,154 +80,416 @@ func DoDownloadArchive(url string, shouldSendMail bool) error {
 	if err = os.MkdirAll(cfg.Paths.Repo, os.ModePerm); err != nil {
 		return err
 	}
+	if err = recoverTempDir(cfg.Paths.Temp); err != nil {
+		return err
+	}
+	if err = recoverPartialFiles(cfg.Paths.Repo, cfg.Paths.Data, cfg.ObjectsDir()); err != nil {
+		return err
+	}
 
-	store := data.NewSqlite3DataStore(filepath.Join(cfg.Paths.Data, "gitar.sqlite"))
-	err = store.Open()
+	store, err := openDataStore(cfg)
 	if err != nil {
 		return err
 	}
 
-	if repoUrl.Platform == github.Platform {
+	archives := client.ResolveArchives(ctx, repoUrls, cfg)
+	visited := utils.NewStringSet(nil)
+	failed := 0
+	for i := range repoUrls {
+		_, err = downloadRepoArchive(ctx, cfg, store, &repoUrls[i], archives[i], opts, visited)
+		if err == nil {
+			continue
+		}
+		if len(repoUrls) == 1 || ctx.Err() != nil {
+			return err
+		}
+		logrus.Errorf("Failed: %s: %s", urls[i], err.Error())
+		failed++
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d downloads failed", failed, len(repoUrls))
+	}
+	return nil
+}
+
+func downloadRepoArchive(ctx context.Context, cfg *config.ConfigProperties, store data.DataStore,
+	repoUrl *common.RepoUrl,
+	arc *common.ArchiveInfo, opts DownloadOptions, visited *utils.StringSet) (*common.ArchiveInfo, error) {
+	logrus.Infof("Platform: %s", repoUrl.Platform)
+	logrus.Infof("Repository: %s/%s", repoUrl.Owner, repoUrl.Repo)
+	logrus.Infof("Parsed-Tag: %s", repoUrl.Tag)
+	logrus.Infof("Parsed-Branch: %s", repoUrl.Branch)
+	logrus.Infof("Parsed-Commit: %s", repoUrl.Commit)
+	if repoUrl.PullRequest > 0 {
+		logrus.Infof("Parsed-Pull-Request: %d", repoUrl.PullRequest)
+	}
+	if !repoUrl.Until.IsZero() {
+		logrus.Infof("Parsed-Until: %s", repoUrl.Until.Format(time.RFC3339))
+	}
+
+	var err error
+	if arc == nil {
+		arc, err = client.ResolveArchive(ctx, *repoUrl, cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	logrus.Infof("Archive-Name: %s", arc.Name)
+	logrus.Infof("Archive-Commit: %s", arc.Commit)
+	logrus.Infof("Archive-Tar: %s", arc.TarUrl)
+	logrus.Infof("Archive-Zip: %s", arc.ZipUrl)
+	logrus.Infof("Archive-Assets: %d", len(arc.Assets))
+
+	if visited.Contains(arc.Commit) {
+		logrus.Warnf("Commit already visited: %s", arc.Commit)
+		return arc, nil
+	}
+	visited.Add(arc.Commit)
+
+	if repoUrl.Platform == github.Platform && repoUrl.Host != github.Host {
+		err = store.SaveRepo(fmt.Sprintf("%s/%s/%s", repoUrl.Host, repoUrl.Owner, repoUrl.Repo))
+		if err != nil {
+			return nil, err
+		}
+	} else if repoUrl.Platform == github.Platform {
 		err = store.SaveGithubRepo(repoUrl.Owner, repoUrl.Repo)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		if arc.Meta != nil {
+			err = store.SaveGithubRepoMeta(repoUrl.Owner, repoUrl.Repo, data.RepoMeta{
+				Description:   arc.Meta.Description,
+				Topics:        strings.Join(arc.Meta.Topics, ","),
+				License:       arc.Meta.License,
+				Archived:      arc.Meta.Archived,
+				DefaultBranch: arc.Meta.DefaultBranch,
+			})
+			if err != nil {
+				return nil, err
+			}
 		}
 	} else {
-		return fmt.Errorf("unsupported platform: %s", repoUrl.Platform)
+		return nil, fmt.Errorf("unsupported platform: %s", repoUrl.Platform)
 	}
 
+	if arc.PullRequest != nil {
+		logrus.Infof("Pull-Request: #%d %s (%s by %s, from %s)", arc.PullRequest.Number,
+			arc.PullRequest.Title, arc.PullRequest.State, arc.PullRequest.Author, arc.PullRequest.SourceRepo)
+		err = store.SavePullRequest(data.PullRequest{
+			Platform:   repoUrl.Platform,
+			Owner:      repoUrl.Owner,
+			Repo:       repoUrl.Repo,
+			Number:     arc.PullRequest.Number,
+			Commit:     arc.Commit,
+			Title:      arc.PullRequest.Title,
+			Author:     arc.PullRequest.Author,
+			State:      arc.PullRequest.State,
+			SourceRepo: arc.PullRequest.SourceRepo,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	repoCfg := cfg.FindRepo(repoUrl.Host, repoUrl.Owner, repoUrl.Repo)
+
 	markDownloaded, err := store.IsCommitDownloaded(arc.Commit)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	arcFile := fmt.Sprintf("%s.tar.xz", arc.Name)
-	destDir := filepath.Join(cfg.Paths.Repo, repoUrl.Platform, repoUrl.Owner, repoUrl.Repo)
-	destPath := filepath.Join(destDir, arcFile)
+	destDir := repoDir(cfg, repoUrl)
+	destPath, destExists, err := findArchivePath(cfg, destDir, arc.Name)
+	if err != nil {
+		return nil, err
+	}
 
-	if markDownloaded && !shouldSendMail {
+	if markDownloaded && !opts.Mail {
 		logrus.Warnf("Commit already downloaded: %s", arc.Commit)
 		arcSize, err := utils.GetFileSize(destPath)
 		if err == nil {
 			logrus.Infof("Archive: %s (%s)", destPath, utils.HumanReadableSize(arcSize))
 		}
-		return nil
-	}
-
-	tempFile := fmt.Sprintf("%s-%s.tar.gz", arc.Name, arc.Commit)
-	tempPath := filepath.Join(cfg.Paths.Temp, tempFile)
-	tempXzFile := fmt.Sprintf("%s-%s.tar.xz", arc.Name, arc.Commit)
-	tempXzPath := filepath.Join(cfg.Paths.Temp, tempXzFile)
-	logrus.Infof("Temp file: %s", tempPath)
-
-	destExists, err := utils.FileExists(destPath)
-	if err != nil {
-		return err
+		return arc, postDownload(ctx, cfg, store, repoUrl, arc, repoCfg, destDir, destPath, opts, visited)
 	}
 
 	if destExists {
 		logrus.Warnf("Already downloaded: %s", destPath)
 	} else {
-		lockFile := filepath.Join(cfg.Paths.Temp, arc.Commit+".lock")
-		lock := fslock.New(lockFile)
-		err := lock.TryLock()
+		destPath, err = saveArchive(ctx, cfg, store, repoUrl, arc, repoCfg, destDir, destPath)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		defer func(lock fslock.Lock) {
-			err := lock.Unlock()
-			if err != nil {
-				logrus.Error(err)
-			}
-		}(lock)
+	}
 
-		err = os.RemoveAll(tempPath)
+	if !markDownloaded {
+		err = store.SetCommitDownloaded(arc.Commit)
 		if err != nil {
-			return err
+			return nil, err
 		}
+	}
 
-		err = utils.CurlDownload(arc.TarUrl, cfg.Paths.Temp, tempFile, -1)
-		if err != nil {
-			return err
-		}
+	if opts.Mail {
 
-		gzipSize, err := utils.GetFileSize(tempPath)
+		mailed, err := store.IsCommitMailed(arc.Commit)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		logrus.Infof("Downloaded: %s (%s)", tempFile, utils.HumanReadableSize(gzipSize))
-		logrus.Infof("Converting gzip archive to xz")
-		err = utils.Gzip2Xz(tempPath, tempXzPath)
-		if err != nil {
-			return err
-		}
+		if mailed {
+			logrus.Warnf("Commit already mailed: %s", arc.Commit)
+		} else {
+			mailPath, subject, cleanup, err := mailArchivePath(ctx, cfg, store, repoUrl, arc, destPath)
+			if err != nil {
+				return nil, err
+			}
+			err = sendMailWithRetry(ctx, mailPath, subject, 999)
+			cleanup()
+			if err != nil {
+				return nil, err
+			}
 
-		xzSize, err := utils.GetFileSize(tempXzPath)
-		if err != nil {
-			return err
+			err = store.SetCommitMailed(arc.Commit)
+			if err != nil {
+				return nil, err
+			}
 		}
-		logrus.Infof("Converted: gzip (%s) => xz (%s)",
-			utils.HumanReadableSize(gzipSize),
-			utils.HumanReadableSize(xzSize))
+	}
 
-		err = os.RemoveAll(tempPath)
+	return arc, postDownload(ctx, cfg, store, repoUrl, arc, repoCfg, destDir, destPath, opts, visited)
+}
+
+// saveArchive 在 Commit 的锁内下载归档, 转码校验后保存到 destPath, 返回实际保存的路径
+func saveArchive(ctx context.Context, cfg *config.ConfigProperties, store data.DataStore, repoUrl *common.RepoUrl,
+	arc *common.ArchiveInfo, repoCfg config.RepoProperties, destDir, destPath string) (string, error) {
+	tempFile := fmt.Sprintf("%s-%s.tar.gz", arc.Name, arc.Commit)
+	tempPath := filepath.Join(cfg.Paths.Temp, tempFile)
+	tempXzFile := fmt.Sprintf("%s-%s.tar.xz", arc.Name, arc.Commit)
+	tempXzPath := filepath.Join(cfg.Paths.Temp, tempXzFile)
+	logrus.Infof("Temp file: %s", tempPath)
+
+	lockFile := filepath.Join(cfg.Paths.Temp, arc.Commit+".lock")
+	lock := fslock.New(lockFile)
+	lockCtx, cancel := context.WithTimeout(ctx, lockTimeout)
+	defer cancel()
+	err := lock.LockContext(lockCtx, fslock.Exclusive)
+	if err != nil {
+		return "", err
+	}
+	defer func(lock fslock.Lock) {
+		err := lock.Unlock()
 		if err != nil {
-			return err
+			logrus.Error(err)
 		}
+	}(lock)
 
-		err = os.MkdirAll(destDir, os.ModePerm)
-		if err != nil {
-			return err
+	// 等待锁期间其它进程可能已经完成了下载
+	savedPath, destExists, err := findArchivePath(cfg, destDir, arc.Name)
+	if err != nil {
+		return "", err
+	}
+	if destExists {
+		logrus.Warnf("Downloaded by another process: %s", savedPath)
+		return savedPath, nil
+	}
+
+	// 中断时删除转码了一半的文件, tar.gz 保留用于下次续传
+	defer func() {
+		if ctx.Err() != nil {
+			removeTempFiles(tempXzPath)
 		}
+	}()
+
+	resumed, err := downloadTarball(ctx, cfg, arc, tempFile)
+	if err != nil {
+		return "", err
+	}
 
-		err = utils.MoveFile(tempXzPath, destPath)
+	gzipSize, err := convertTarball(ctx, cfg, repoUrl, arc, repoCfg, tempPath, tempXzPath)
+	if err != nil && resumed && ctx.Err() == nil {
+		// 续传得到的文件可能来自不同的来源, 校验失败时重新完整下载一次
+		logrus.Warnf("Resumed download rejected, downloading again: %s", err.Error())
+		removeTempFiles(tempPath, tempXzPath)
+		_, err = downloadTarball(ctx, cfg, arc, tempFile)
 		if err != nil {
-			return err
+			return "", err
 		}
-		logrus.Infof("Saved: %s", destPath)
+		gzipSize, err = convertTarball(ctx, cfg, repoUrl, arc, repoCfg, tempPath, tempXzPath)
+	}
+	if err != nil && ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if err != nil {
+		return "", rejectArchive(store, arc, err, tempPath, tempXzPath)
 	}
 
-	if !markDownloaded {
-		err = store.SetCommitDownloaded(arc.Commit)
+	xzSize, err := utils.GetFileSize(tempXzPath)
+	if err != nil {
+		return "", err
 	}
+	logrus.Infof("Converted: gzip (%s) => xz (%s)",
+		utils.HumanReadableSize(gzipSize),
+		utils.HumanReadableSize(xzSize))
 
-	if shouldSendMail {
+	err = os.RemoveAll(tempPath)
+	if err != nil {
+		return "", err
+	}
 
-		mailed, err := store.IsCommitMailed(arc.Commit)
+	err = os.MkdirAll(destDir, os.ModePerm)
+	if err != nil {
+		return "", err
+	}
+
+	savedPath, err = storeArchive(ctx, cfg, store, repoUrl, arc, tempXzPath, destPath)
+	if err != nil {
+		return "", err
+	}
+	logrus.Infof("Saved: %s", savedPath)
+	return savedPath, nil
+}
+
+// convertTarball 将下载的 tar.gz 转为 tar.xz 并校验内容, 返回 tar.gz 的大小
+func convertTarball(ctx context.Context, cfg *config.ConfigProperties, repoUrl *common.RepoUrl,
+	arc *common.ArchiveInfo, repoCfg config.RepoProperties, tempPath, tempXzPath string) (int, error) {
+	gzipSize, err := utils.GetFileSize(tempPath)
+	if err != nil {
+		return 0, err
+	}
+
+	logrus.Infof("Downloaded: %s (%s)", filepath.Base(tempPath), utils.HumanReadableSize(gzipSize))
+	logrus.Infof("Converting gzip archive to xz")
+	expect := utils.ArchiveExpectation{
+		Commit:    arc.Commit,
+		DirPrefix: repoUrl.Repo + "-",
+	}
+	if repoCfg.Lfs.Enabled {
+		err = utils.Gzip2XzRewrite(ctx, tempPath, tempXzPath, expect, newLfsRewriter(ctx, cfg, repoUrl, repoCfg.Lfs))
+	} else {
+		err = utils.Gzip2Xz(ctx, tempPath, tempXzPath, expect)
+	}
+	return gzipSize, err
+}
+
+// downloadPatch 保存 Pull Request 的 patch 文件, 与归档同名
+func downloadPatch(ctx context.Context, cfg *config.ConfigProperties, repoUrl *common.RepoUrl,
+	arc *common.ArchiveInfo, destDir string) error {
+	patchFile := fmt.Sprintf("%s.patch", arc.Name)
+	patchPath := filepath.Join(destDir, patchFile)
+	exists, err := utils.FileExists(patchPath)
+	if err != nil {
+		return err
+	}
+	if exists {
+		logrus.Warnf("Patch already downloaded: %s", patchPath)
+		return nil
+	}
+
+	tempPath := filepath.Join(cfg.Paths.Temp, patchFile)
+	file, err := os.Create(tempPath)
+	if err != nil {
+		return err
+	}
+	err = client.DownloadPatch(ctx, *repoUrl, cfg, file)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		removeTempFiles(tempPath)
+		return err
+	}
+	err = os.MkdirAll(destDir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	err = utils.MoveFile(tempPath, patchPath)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Saved: %s", patchPath)
+	return nil
+}
+
+// rejectArchive 记录校验失败的原因并清理临时文件, 该 Commit 不会被标记为已下载
+func rejectArchive(store data.DataStore, arc *common.ArchiveInfo, cause error, tempPaths ...string) error {
+	logrus.Errorf("Archive rejected: %s", cause.Error())
+	err := store.SetCommitError(arc.Commit, cause.Error())
+	if err != nil {
+		logrus.Error(err)
+	}
+	removeTempFiles(tempPaths...)
+	return cause
+}
+
+func removeTempFiles(paths ...string) {
+	for _, path := range paths {
+		err := os.RemoveAll(path)
 		if err != nil {
-			return err
+			logrus.Error(err)
 		}
+	}
+}
 
-		if mailed {
-			logrus.Warnf("Commit already mailed: %s", arc.Commit)
-		} else {
-			subject := fmt.Sprintf("%s:%s/%s.tar.xz", repoUrl.Platform, repoUrl.Owner, arc.Name)
+func shortCommit(commit string) string {
+	if len(commit) < 7 {
+		return commit
+	}
+	return commit[:7]
+}
 
-			err := sendMailWithRetry(destPath, subject, 999)
-			if err != nil {
-				return err
-			}
+func openDataStore(cfg *config.ConfigProperties) (data.DataStore, error) {
+	store := data.NewSqlite3DataStore(filepath.Join(cfg.Paths.Data, "gitar.sqlite"))
+	err := store.Open()
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
 
-			err = store.SetCommitMailed(arc.Commit)
-			if err != nil {
-				return err
-			}
-		}
+// repoDir 返回仓库的归档目录, GitHub Enterprise Server 的仓库按主机名存放
+func repoDir(cfg *config.ConfigProperties, repoUrl *common.RepoUrl) string {
+	return filepath.Join(cfg.Paths.Repo, repoPlatform(repoUrl), repoUrl.Owner, repoUrl.Repo)
+}
+
+func repoPlatform(repoUrl *common.RepoUrl) string {
+	if repoUrl.Platform == github.Platform && repoUrl.Host != "" && repoUrl.Host != github.Host {
+		return repoUrl.Host
 	}
+	return repoUrl.Platform
+}
 
-	return err
+func postDownload(ctx context.Context, cfg *config.ConfigProperties, store data.DataStore, repoUrl *common.RepoUrl,
+	arc *common.ArchiveInfo, repoCfg config.RepoProperties, destDir, destPath string,
+	opts DownloadOptions, visited *utils.StringSet) error {
+	err := downloadReleaseAssets(ctx, cfg, store, arc, repoCfg, destDir)
+	if err != nil {
+		return err
+	}
+	if opts.Patch && arc.PullRequest != nil {
+		err = downloadPatch(ctx, cfg, repoUrl, arc, destDir)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.Submodules || repoCfg.Submodules {
+		return downloadSubmodules(ctx, cfg, store, repoUrl, arc, destPath, opts, visited)
+	}
+	return nil
 }
 
-func sendMailWithRetry(file, subject string, maxAttempts int) error {
+func sendMailWithRetry(ctx context.Context, file, subject string, maxAttempts int) error {
 	logrus.Infof("Sending email")
 	for i := 0; i < maxAttempts; i++ {
-		err := sendMail(file, subject)
+		err := sendMail(ctx, file, subject)
 		if err != nil {
 			logrus.Error(err)
 			delay := calcMailRetryDelay(i + 1)
 			logrus.Infof("Retry after %s", delay)
-			time.Sleep(delay)
+			err = common.Sleep(ctx, delay)
+			if err != nil {
+				return err
+			}
 			continue
 		}
 		return nil
@@ -218,8 +497,8 @@ func sendMailWithRetry(file, subject string, maxAttempts int) error {
 	return errors.New("send mail failed")
 }
 
-func sendMail(file string, subject string) error {
-	cmd := exec.Command("filemailer", "send", "--profile=gitar", "--subject", subject, file)
+func sendMail(ctx context.Context, file string, subject string) error {
+	cmd := exec.CommandContext(ctx, "filemailer", "send", "--profile=gitar", "--subject", subject, file)
 	cmd.Stdout = os.Stdout
 	err := cmd.Start()
 	if err != nil {
@@ -0,0 +1,57 @@
+package app
+
+import (
+	"context"
+	"os/exec"
+	"time"
+
+	"gitar/pkg/client"
+	"gitar/pkg/client/github"
+	"gitar/pkg/config"
+	"github.com/sirupsen/logrus"
+)
+
+func RunDoctor(ctx context.Context) error {
+	file, err := config.LookupConfigFile(AppName)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Config: %s", file)
+
+	cfg, err := config.ReadConfig(file)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Paths: %+v", cfg.Paths)
+
+	for _, tool := range []string{"curl", "xz", "filemailer"} {
+		path, err := exec.LookPath(tool)
+		if err != nil {
+			logrus.Warnf("Tool: %s not found", tool)
+			continue
+		}
+		logrus.Infof("Tool: %s => %s", tool, path)
+	}
+
+	for _, host := range cfg.GitHub.HostNames() {
+		hostCfg := cfg.GitHub.FindHost(host)
+		pool := client.GitHubTokenPool(cfg, host)
+		tokens := hostCfg.AllTokens()
+		if len(tokens) <= 0 {
+			tokens = append(tokens, "")
+		}
+		for _, token := range tokens {
+			quotas, err := pool.QueryRateQuotas(ctx, token, hostCfg.Api)
+			if err != nil {
+				logrus.Errorf("GitHub %s token %s: %s", host, github.MaskToken(token), err.Error())
+				continue
+			}
+			for _, quota := range quotas {
+				logrus.Infof("GitHub %s token %s: %s %d/%d, reset in %s",
+					host, github.MaskToken(token), quota.Resource, quota.Remaining, quota.Limit,
+					time.Until(quota.Reset).Round(time.Second))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gitar/pkg/config"
+	"gitar/pkg/data"
+	"gitar/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// RunExport 将快照还原为 tar, 并校验与保存时记录的 SHA-256 一致
+func RunExport(ctx context.Context, key, output string) error {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return err
+	}
+	store, err := openDataStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer func(store data.DataStore) {
+		_ = store.Close()
+	}(store)
+
+	snapshot, err := store.FindSnapshot(key)
+	if err != nil {
+		return err
+	}
+	if snapshot == nil {
+		return fmt.Errorf("snapshot not found: %s", key)
+	}
+	logrus.Infof("Snapshot: %s (%s, %s)", snapshot.Name, snapshot.Format, snapshot.Commit)
+
+	if output == "" {
+		output = snapshot.Name + ".tar"
+	}
+	// 临时文件保留扩展名, exportArchive 据此决定是否压缩
+	tempPath := filepath.Join(filepath.Dir(output), ".partial-"+filepath.Base(output))
+	err = exportArchive(ctx, cfg, snapshot.Path, tempPath)
+	if err != nil {
+		removeTempFiles(tempPath)
+		return err
+	}
+
+	digest, size, err := exportedDigest(tempPath)
+	if err != nil {
+		removeTempFiles(tempPath)
+		return err
+	}
+	if snapshot.Sha256 != "" && digest != snapshot.Sha256 {
+		removeTempFiles(tempPath)
+		return fmt.Errorf("snapshot %s checksum mismatch: expected %s, got %s", snapshot.Name, snapshot.Sha256, digest)
+	}
+
+	err = utils.MoveFile(tempPath, output)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Exported: %s (%s, sha256:%s)", output, utils.HumanReadableSize(int(size)), digest)
+	return nil
+}
+
+// exportedDigest 计算导出文件中 tar 的 SHA-256, 压缩输出时校验解压后的内容
+func exportedDigest(path string) (string, int64, error) {
+	if strings.HasSuffix(path, ".xz") {
+		return tarXzDigest(path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+	return utils.ReaderSha256(file)
+}
@@ -0,0 +1,449 @@
+package app
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gitar/pkg/client/github"
+	"gitar/pkg/config"
+	"gitar/pkg/data"
+	"github.com/sirupsen/logrus"
+)
+
+type ExtractOptions struct {
+	// Strip 去掉归档的顶层目录
+	Strip bool
+	// Include 只解出匹配的路径, 不含 / 的模式也匹配文件名, 匹配目录时包含其下所有文件
+	Include []string
+}
+
+// RunExtract 找到 owner/repo[@ref|sha] 对应的快照并解出到 dest, dest 为 - 时将 tar 写到标准输出
+func RunExtract(ctx context.Context, spec, dest string, opts ExtractOptions) error {
+	if dest == "-" {
+		// 标准输出用于 tar, 日志改为输出到标准错误
+		logrus.SetOutput(os.Stderr)
+	}
+	err := checkIncludePatterns(opts.Include)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return err
+	}
+	store, err := openDataStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer func(store data.DataStore) {
+		_ = store.Close()
+	}(store)
+
+	snapshot, err := resolveSnapshot(cfg, store, spec)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Snapshot: %s (%s, %s)", snapshot.Name, snapshot.Format, snapshot.Path)
+
+	if dest == "" {
+		dest = snapshot.Name
+	}
+	reader, err := openArchive(cfg, snapshot.Path)
+	if err != nil {
+		return err
+	}
+	defer func(reader io.ReadCloser) {
+		_ = reader.Close()
+	}(reader)
+
+	hash := sha256.New()
+	tarReader := tar.NewReader(io.TeeReader(reader, hash))
+	var count int
+	if dest == "-" {
+		count, err = filterTar(ctx, tarReader, os.Stdout, opts)
+	} else {
+		count, err = extractTar(ctx, tarReader, dest, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	// 读完 tar 结尾的填充后校验整个归档
+	_, err = io.Copy(hash, reader)
+	if err != nil {
+		return err
+	}
+	digest := hex.EncodeToString(hash.Sum(nil))
+	if snapshot.Sha256 != "" && digest != snapshot.Sha256 {
+		return fmt.Errorf("snapshot %s checksum mismatch: expected %s, got %s", snapshot.Name, snapshot.Sha256, digest)
+	}
+	logrus.Infof("Extracted: %d files to %s", count, dest)
+	return nil
+}
+
+// resolveSnapshot 查找 owner/repo[@ref|sha] 对应的快照
+func resolveSnapshot(cfg *config.ConfigProperties, store data.DataStore, spec string) (*data.Snapshot, error) {
+	owner, repo, ref, err := parseSnapshotSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := findRepoSnapshot(cfg, store, owner, repo, ref)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil {
+		return nil, fmt.Errorf("snapshot not found: %s", spec)
+	}
+	return snapshot, nil
+}
+
+// parseSnapshotSpec 解析 owner/repo[@ref|sha]
+func parseSnapshotSpec(spec string) (string, string, string, error) {
+	name, ref, _ := strings.Cut(spec, "@")
+	owner, repo, found := strings.Cut(strings.Trim(name, "/"), "/")
+	repo = strings.TrimSuffix(repo, ".git")
+	if !found || owner == "" || repo == "" || strings.Contains(repo, "/") {
+		return "", "", "", fmt.Errorf("invalid repo %q, expect owner/repo[@ref|sha]", spec)
+	}
+	return owner, repo, ref, nil
+}
+
+// findRepoSnapshot 在数据库中查找快照, 找不到时扫描仓库目录, 以兼容没有记录快照时下载的归档
+func findRepoSnapshot(cfg *config.ConfigProperties, store data.DataStore,
+	owner, repo, ref string) (*data.Snapshot, error) {
+	snapshots, err := store.ListSnapshots(owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := matchSnapshot(snapshots, repo, ref)
+	if snapshot != nil {
+		return snapshot, nil
+	}
+
+	snapshots, err = scanRepoSnapshots(cfg, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	return matchSnapshot(snapshots, repo, ref), nil
+}
+
+// matchSnapshot 从新到旧的快照中选出 ref 对应的一个, ref 为空时取最新的.
+// ref 可以是归档名, Tag, Branch 或 Commit 前缀
+func matchSnapshot(snapshots []data.Snapshot, repo, ref string) *data.Snapshot {
+	if ref == "" {
+		if len(snapshots) == 0 {
+			return nil
+		}
+		return &snapshots[0]
+	}
+
+	refName := repo + "-" + strings.ReplaceAll(ref, "/", "-")
+	for i := range snapshots {
+		name := snapshots[i].Name
+		if strings.EqualFold(name, ref) || strings.EqualFold(name, refName) {
+			return &snapshots[i]
+		}
+	}
+	// Branch 的归档名以 Commit 的缩写结尾
+	for i := range snapshots {
+		name := snapshots[i].Name
+		if len(name) > len(refName)+1 && strings.EqualFold(name[:len(refName)+1], refName+"-") &&
+			github.IsShortCommit(name[len(refName)+1:]) {
+			return &snapshots[i]
+		}
+	}
+	if github.IsShortCommit(ref) {
+		commit := strings.ToLower(ref)
+		for i := range snapshots {
+			if snapshots[i].Commit == "" && strings.HasSuffix(snapshots[i].Name, "-"+commit[:7]) {
+				return &snapshots[i]
+			}
+			if strings.HasPrefix(snapshots[i].Commit, commit) {
+				return &snapshots[i]
+			}
+		}
+	}
+	return nil
+}
+
+// scanRepoSnapshots 扫描各平台下 owner/repo 目录中的归档, 按修改时间从新到旧排列
+func scanRepoSnapshots(cfg *config.ConfigProperties, owner, repo string) ([]data.Snapshot, error) {
+	paths, err := filepath.Glob(filepath.Join(cfg.Paths.Repo, "*", owner, repo, "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []data.Snapshot
+	for _, item := range paths {
+		format, err := archiveFormat(item)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(item)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		name := filepath.Base(item)
+		for _, suffix := range archiveSuffixes {
+			name = strings.TrimSuffix(name, suffix)
+		}
+		snapshots = append(snapshots, data.Snapshot{
+			Platform: filepath.Base(filepath.Dir(filepath.Dir(filepath.Dir(item)))),
+			Owner:    owner,
+			Repo:     repo,
+			Name:     name,
+			Format:   format,
+			Path:     item,
+			Created:  info.ModTime(),
+		})
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Created.After(snapshots[j].Created)
+	})
+	return snapshots, nil
+}
+
+// selectEntry 返回条目解出时的路径, 不需要解出时返回 false
+func selectEntry(name string, opts ExtractOptions) (string, bool) {
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "./"), "/")
+	top, rel, _ := strings.Cut(name, "/")
+	if rel == "" {
+		// 顶层目录本身
+		return top, !opts.Strip && len(opts.Include) == 0
+	}
+	if !matchInclude(opts.Include, rel) {
+		return "", false
+	}
+	if opts.Strip {
+		return rel, true
+	}
+	return name, true
+}
+
+func checkIncludePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return fmt.Errorf("invalid include pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func matchInclude(patterns []string, rel string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		pattern = strings.Trim(pattern, "/")
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+				return true
+			}
+		}
+		for candidate := rel; candidate != "."; candidate = path.Dir(candidate) {
+			if ok, _ := path.Match(pattern, candidate); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// extractTar 将选中的条目解出到 dest, 返回解出的文件数
+func extractTar(ctx context.Context, tarReader *tar.Reader, dest string, opts ExtractOptions) (int, error) {
+	err := os.MkdirAll(dest, os.ModePerm)
+	if err != nil {
+		return 0, err
+	}
+	root, err := filepath.Abs(dest)
+	if err != nil {
+		return 0, err
+	}
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for {
+		if ctx.Err() != nil {
+			return count, ctx.Err()
+		}
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		name, ok := selectEntry(header.Name, opts)
+		if !ok {
+			continue
+		}
+		target, err := entryTarget(root, name)
+		if err != nil {
+			return count, err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, header.FileInfo().Mode().Perm()|0700)
+			if err == nil {
+				err = ensureInside(root, target)
+			}
+
+		case tar.TypeReg:
+			err = extractFile(tarReader, header, root, target)
+			count++
+
+		case tar.TypeSymlink:
+			err = prepareEntryDir(root, target)
+			if err == nil {
+				err = os.Symlink(header.Linkname, target)
+			}
+
+		case tar.TypeLink:
+			linkName, ok := selectEntry(header.Linkname, opts)
+			if !ok {
+				logrus.Warnf("Skip hard link to unselected file: %s", header.Name)
+				continue
+			}
+			var linkTarget string
+			linkTarget, err = entryTarget(root, linkName)
+			if err == nil {
+				err = prepareEntryDir(root, target)
+			}
+			if err == nil {
+				err = os.Link(linkTarget, target)
+			}
+
+		default:
+			logrus.Debugf("Skip entry type %c: %s", header.Typeflag, header.Name)
+		}
+		if err != nil {
+			return count, err
+		}
+	}
+}
+
+func extractFile(tarReader *tar.Reader, header *tar.Header, root, target string) error {
+	err := prepareEntryDir(root, target)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, header.FileInfo().Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, tarReader)
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return os.Chtimes(target, header.ModTime, header.ModTime)
+}
+
+// entryTarget 返回条目在 root 下的路径, 拒绝跳出 root 的路径
+func entryTarget(root, name string) (string, error) {
+	target := filepath.Join(root, filepath.FromSlash(name))
+	if target != root && !strings.HasPrefix(target, root+string(os.PathSeparator)) {
+		return "", fmt.Errorf("unsafe path in archive: %s", name)
+	}
+	return target, nil
+}
+
+// prepareEntryDir 创建条目的上级目录并删除已存在的条目
+func prepareEntryDir(root, target string) error {
+	dir := filepath.Dir(target)
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	err = ensureInside(root, dir)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(target)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ensureInside 检查目录经过符号链接后仍在 root 中, 防止归档中的链接把文件写到 root 之外
+func ensureInside(root, dir string) error {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if realDir != root && !strings.HasPrefix(realDir, root+string(os.PathSeparator)) {
+		return fmt.Errorf("unsafe path in archive: %s", dir)
+	}
+	return nil
+}
+
+// filterTar 将选中的条目写成新的 tar, 返回写入的文件数
+func filterTar(ctx context.Context, tarReader *tar.Reader, w io.Writer, opts ExtractOptions) (int, error) {
+	tarWriter := tar.NewWriter(w)
+	count := 0
+	for {
+		if ctx.Err() != nil {
+			return count, ctx.Err()
+		}
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		name, ok := selectEntry(header.Name, opts)
+		if !ok {
+			continue
+		}
+		header.Name = name
+		if header.Typeflag == tar.TypeDir {
+			header.Name += "/"
+		}
+		if header.Typeflag == tar.TypeLink {
+			header.Linkname, ok = selectEntry(header.Linkname, opts)
+			if !ok {
+				logrus.Warnf("Skip hard link to unselected file: %s", name)
+				continue
+			}
+		}
+
+		err = tarWriter.WriteHeader(header)
+		if err != nil {
+			return count, err
+		}
+		if header.Typeflag == tar.TypeReg {
+			_, err = io.Copy(tarWriter, tarReader)
+			if err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	return count, tarWriter.Close()
+}
@@ -0,0 +1,191 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gitar/pkg/config"
+	"gitar/pkg/data"
+	"gitar/pkg/search"
+	"github.com/sirupsen/logrus"
+)
+
+type GrepOptions struct {
+	Repos      []string
+	Versions   []string
+	Include    []string
+	IgnoreCase bool
+	Fixed      bool
+	// FilesOnly 只输出匹配的文件, VersionsOnly 只输出匹配的快照和行数
+	FilesOnly    bool
+	VersionsOnly bool
+	MaxCount     int
+}
+
+// RunGrep 在 gitar index 建立的索引中搜索, 按快照从旧到新输出 快照:路径:行号:内容
+func RunGrep(ctx context.Context, pattern string, opts GrepOptions) error {
+	// 标准输出用于搜索结果, 日志改为输出到标准错误
+	logrus.SetOutput(os.Stderr)
+	err := checkRepoFilters(opts.Repos)
+	if err != nil {
+		return err
+	}
+	err = checkIncludePatterns(opts.Include)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return err
+	}
+	index, err := openExistingSearchIndex(cfg)
+	if err != nil {
+		return err
+	}
+	defer func(index *search.Index) {
+		_ = index.Close()
+	}(index)
+
+	snapshots, err := index.Snapshots()
+	if err != nil {
+		return err
+	}
+	ids, err := selectIndexedSnapshots(snapshots, opts.Repos, opts.Versions)
+	if err != nil {
+		return err
+	}
+	matches, truncated, err := index.Search(ctx, search.Options{
+		Pattern:    pattern,
+		IgnoreCase: opts.IgnoreCase,
+		Fixed:      opts.Fixed,
+		Snapshots:  ids,
+		Filter:     includeFilter(opts.Include),
+		Limit:      opts.MaxCount,
+	})
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case opts.VersionsOnly:
+		counts := make(map[*search.Snapshot]int)
+		for _, match := range matches {
+			counts[match.Snapshot]++
+		}
+		for i, match := range matches {
+			if i == 0 || match.Snapshot != matches[i-1].Snapshot {
+				fmt.Printf("%s:%d\n", match.Snapshot.Name, counts[match.Snapshot])
+			}
+		}
+	case opts.FilesOnly:
+		for i, match := range matches {
+			if i == 0 || match.Snapshot != matches[i-1].Snapshot || match.Path != matches[i-1].Path {
+				fmt.Printf("%s:%s\n", match.Snapshot.Name, match.Path)
+			}
+		}
+	default:
+		for _, match := range matches {
+			fmt.Printf("%s:%s:%d:%s\n", match.Snapshot.Name, match.Path, match.Line, match.Text)
+		}
+	}
+	if truncated {
+		logrus.Warnf("Stopped after %d matches", opts.MaxCount)
+	}
+	if len(matches) == 0 {
+		logrus.Infof("No match in %d snapshots", len(ids))
+	}
+	return nil
+}
+
+// openExistingSearchIndex 打开已经建立的索引, 不存在时提示先运行 gitar index
+func openExistingSearchIndex(cfg *config.ConfigProperties) (*search.Index, error) {
+	_, err := os.Stat(filepath.Join(cfg.Paths.Data, "search.sqlite"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("search index not found, run gitar index first")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return openSearchIndex(cfg)
+}
+
+// selectIndexedSnapshots 按仓库和版本选出查询的快照. 版本可以是归档名, Tag, Branch, Commit 前缀,
+// 也可以是匹配归档名或 Tag 的通配符
+func selectIndexedSnapshots(snapshots []search.Snapshot, repos, versions []string) ([]int64, error) {
+	ids := []int64{}
+	groups := make(map[string][]data.Snapshot)
+	var names []string
+	for _, snapshot := range snapshots {
+		if !matchRepoFilters(repos, snapshot.Owner, snapshot.Repo) {
+			continue
+		}
+		if len(versions) == 0 {
+			ids = append(ids, snapshot.Id)
+			continue
+		}
+		name := strings.ToLower(snapshot.Owner + "/" + snapshot.Repo)
+		if _, found := groups[name]; !found {
+			names = append(names, name)
+		}
+		// matchSnapshot 需要从新到旧排列
+		groups[name] = append([]data.Snapshot{{
+			Id:       snapshot.Id,
+			Platform: snapshot.Platform,
+			Owner:    snapshot.Owner,
+			Repo:     snapshot.Repo,
+			Name:     snapshot.Name,
+			Commit:   snapshot.Commit,
+		}}, groups[name]...)
+	}
+
+	for _, version := range versions {
+		glob := strings.ContainsAny(version, "*?[")
+		if glob {
+			if _, err := path.Match(version, ""); err != nil {
+				return nil, fmt.Errorf("invalid version pattern %q: %w", version, err)
+			}
+		}
+		found := false
+		for _, name := range names {
+			group := groups[name]
+			if !glob {
+				snapshot := matchSnapshot(group, group[0].Repo, version)
+				if snapshot != nil {
+					ids = append(ids, snapshot.Id)
+					found = true
+				}
+				continue
+			}
+			for _, snapshot := range group {
+				tag := strings.TrimPrefix(snapshot.Name, snapshot.Repo+"-")
+				matched, _ := path.Match(version, snapshot.Name)
+				tagMatched, _ := path.Match(version, tag)
+				if matched || tagMatched {
+					ids = append(ids, snapshot.Id)
+					found = true
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("version %s is not indexed", version)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no indexed snapshot matches, run gitar index first")
+	}
+	return ids, nil
+}
+
+func includeFilter(include []string) func(path string) bool {
+	if len(include) == 0 {
+		return nil
+	}
+	return func(path string) bool {
+		return matchInclude(include, path)
+	}
+}
@@ -0,0 +1,240 @@
+package app
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gitar/pkg/config"
+	"gitar/pkg/data"
+	"gitar/pkg/diff"
+	"gitar/pkg/fslock"
+	"gitar/pkg/search"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// 超过该大小的文件不建立索引
+	maxIndexFileSize = 1 << 20
+	// 倒排表的段数超过三元组数的该倍数时合并
+	compactSegments = 16
+)
+
+type IndexOptions struct {
+	Repos []string
+}
+
+// RunIndex 为保存的快照中的文本文件建立全文索引, 已建立索引的快照会跳过,
+// 数据库中已经删除的快照从索引中移除
+func RunIndex(ctx context.Context, opts IndexOptions) error {
+	err := checkRepoFilters(opts.Repos)
+	if err != nil {
+		return err
+	}
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(cfg.Paths.Data, os.ModePerm); err != nil {
+		return err
+	}
+	store, err := openDataStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer func(store data.DataStore) {
+		_ = store.Close()
+	}(store)
+
+	lock := fslock.New(filepath.Join(cfg.Paths.Data, "search.lock"))
+	err = lock.TryLock()
+	if err != nil {
+		return err
+	}
+	defer func(lock fslock.Lock) {
+		_ = lock.Unlock()
+	}(lock)
+
+	index, err := openSearchIndex(cfg)
+	if err != nil {
+		return err
+	}
+	defer func(index *search.Index) {
+		_ = index.Close()
+	}(index)
+
+	snapshots, err := listStoredSnapshots(store)
+	if err != nil {
+		return err
+	}
+	stored := make(map[string]bool, len(snapshots))
+	for _, snapshot := range snapshots {
+		stored[snapshotKey(snapshot.Platform, snapshot.Owner, snapshot.Repo, snapshot.Name)] = true
+	}
+	removed, err := index.Prune(func(snapshot search.Snapshot) bool {
+		return stored[snapshotKey(snapshot.Platform, snapshot.Owner, snapshot.Repo, snapshot.Name)]
+	})
+	if err != nil {
+		return err
+	}
+	if removed > 0 {
+		logrus.Infof("Removed %d snapshots from index", removed)
+	}
+
+	indexed, failed := 0, 0
+	for i := range snapshots {
+		snapshot := &snapshots[i]
+		if !matchRepoFilters(opts.Repos, snapshot.Owner, snapshot.Repo) {
+			continue
+		}
+		found, err := index.HasSnapshot(snapshot.Platform, snapshot.Owner, snapshot.Repo, snapshot.Name,
+			snapshot.Sha256)
+		if err != nil {
+			return err
+		}
+		if found {
+			continue
+		}
+		logrus.Infof("Index: %s/%s %s", snapshot.Owner, snapshot.Repo, snapshot.Name)
+		files, err := indexSnapshot(ctx, cfg, index, snapshot)
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			logrus.Errorf("Failed: %s: %s", snapshot.Name, err.Error())
+			failed++
+			continue
+		}
+		logrus.Infof("Indexed %d files", files)
+		indexed++
+	}
+
+	if indexed > 0 || removed > 0 {
+		compacted, err := index.Compact(compactSegments)
+		if err != nil {
+			return err
+		}
+		if compacted {
+			logrus.Infof("Compacted index")
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d snapshots failed", failed, indexed+failed)
+	}
+	logrus.Infof("Indexed %d snapshots", indexed)
+	return nil
+}
+
+// indexSnapshot 读取快照中不超过 maxIndexFileSize 的文本文件, 路径去掉顶层目录
+func indexSnapshot(ctx context.Context, cfg *config.ConfigProperties, index *search.Index,
+	snapshot *data.Snapshot) (int, error) {
+	writer, err := index.Begin(search.Snapshot{
+		Platform: snapshot.Platform,
+		Owner:    snapshot.Owner,
+		Repo:     snapshot.Repo,
+		Name:     snapshot.Name,
+		Commit:   snapshot.Commit,
+		Sha256:   snapshot.Sha256,
+		Created:  snapshot.Created,
+	})
+	if err != nil {
+		return 0, err
+	}
+	opts := ExtractOptions{Strip: true}
+	err = walkSnapshot(ctx, cfg, snapshot, func(header *tar.Header, r io.Reader) error {
+		if header.Typeflag != tar.TypeReg || header.Size > maxIndexFileSize {
+			return nil
+		}
+		name, ok := selectEntry(header.Name, opts)
+		if !ok {
+			return nil
+		}
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if !diff.IsText(content) {
+			return nil
+		}
+		return writer.Add(name, content)
+	})
+	if err != nil {
+		_ = writer.Rollback()
+		return 0, err
+	}
+	return writer.Commit()
+}
+
+func openSearchIndex(cfg *config.ConfigProperties) (*search.Index, error) {
+	index := search.NewIndex(filepath.Join(cfg.Paths.Data, "search.sqlite"))
+	err := index.Open()
+	if err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// listStoredSnapshots 返回数据库中所有的快照, 按保存时间从旧到新排列
+func listStoredSnapshots(store data.DataStore) ([]data.Snapshot, error) {
+	repos, err := store.ListSnapshotRepos()
+	if err != nil {
+		return nil, err
+	}
+	// ListSnapshots 不区分平台和大小写, 同名的仓库只需要查询一次
+	visited := make(map[string]bool)
+	var snapshots []data.Snapshot
+	for _, repo := range repos {
+		name := strings.ToLower(repo.Owner + "/" + repo.Repo)
+		if visited[name] {
+			continue
+		}
+		visited[name] = true
+		items, err := store.ListSnapshots(repo.Owner, repo.Repo)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, items...)
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		if !snapshots[i].Created.Equal(snapshots[j].Created) {
+			return snapshots[i].Created.Before(snapshots[j].Created)
+		}
+		return snapshots[i].Id < snapshots[j].Id
+	})
+	return snapshots, nil
+}
+
+func snapshotKey(platform, owner, repo, name string) string {
+	return strings.Join([]string{platform, owner, repo, name}, "/")
+}
+
+func checkRepoFilters(repos []string) error {
+	for _, item := range repos {
+		_, _, ref, err := parseSnapshotSpec(item)
+		if err != nil {
+			return err
+		}
+		if ref != "" {
+			return fmt.Errorf("invalid repo %q, expect owner/repo", item)
+		}
+	}
+	return nil
+}
+
+func matchRepoFilters(repos []string, owner, repo string) bool {
+	if len(repos) == 0 {
+		return true
+	}
+	for _, item := range repos {
+		itemOwner, itemRepo, _, _ := parseSnapshotSpec(item)
+		if strings.EqualFold(itemOwner, owner) && strings.EqualFold(itemRepo, repo) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,451 @@
+package app
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp/syntax"
+	"strings"
+	"time"
+
+	"gitar/pkg/config"
+	"gitar/pkg/data"
+	"gitar/pkg/diff"
+	"gitar/pkg/search"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultServeListen = "127.0.0.1:8080"
+
+	// 搜索页面和 /api/search 最多返回的行数
+	maxSearchResults = 1000
+)
+
+type ServeOptions struct {
+	Listen string
+}
+
+// RunServe 启动 HTTP 服务, 浏览保存的快照和其中的文件, 配置了 Token 时开放 /api/ 接口,
+// ctx 取消时优雅退出
+func RunServe(ctx context.Context, opts ServeOptions) error {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return err
+	}
+	store, err := openDataStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer func(store data.DataStore) {
+		_ = store.Close()
+	}(store)
+	index, err := openSearchIndex(cfg)
+	if err != nil {
+		return err
+	}
+	defer func(index *search.Index) {
+		_ = index.Close()
+	}(index)
+
+	listen := opts.Listen
+	if listen == "" {
+		listen = cfg.Serve.Listen
+	}
+	if listen == "" {
+		listen = DefaultServeListen
+	}
+
+	server := &archiveServer{
+		cfg:   cfg,
+		store: store,
+		cache: newTarCache(cfg),
+		jobs:  newJobQueue(),
+		index: index,
+	}
+	httpServer := &http.Server{
+		Addr:              listen,
+		Handler:           server.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = httpServer.Shutdown(shutdownCtx)
+	}()
+
+	jobsCtx, cancelJobs := context.WithCancel(ctx)
+	jobsDone := make(chan struct{})
+	go func() {
+		defer close(jobsDone)
+		server.jobs.Run(jobsCtx)
+	}()
+	// 等待正在执行的下载任务中止后再关闭数据库
+	defer func() {
+		cancelJobs()
+		<-jobsDone
+	}()
+
+	logrus.Infof("Listening on http://%s", listen)
+	if len(cfg.Serve.AllTokens()) == 0 {
+		logrus.Infof("API is disabled, set serve.token to enable it")
+	}
+	err = httpServer.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+type archiveServer struct {
+	cfg   *config.ConfigProperties
+	store data.DataStore
+	cache *tarCache
+	jobs  *jobQueue
+	index *search.Index
+}
+
+func (me *archiveServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", me.handleHome)
+	mux.HandleFunc("/repos/", me.handleRepos)
+	mux.HandleFunc("/search", me.handleSearch)
+	me.apiRoutes(mux)
+	return mux
+}
+
+type pageData struct {
+	Title     string
+	Crumbs    []pageCrumb
+	Repos     []data.SnapshotRepo
+	Snapshots []data.Snapshot
+	Base      string
+	Entries   []tarEntry
+	Entry     *tarEntry
+	Text      string
+	Search    *searchForm
+	Matches   []search.Match
+	Truncated bool
+	Message   string
+}
+
+func (me *archiveServer) handleHome(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if !allowMethod(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+	repos, err := me.store.ListSnapshotRepos()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	renderPage(w, "repos", &pageData{Title: "Repositories", Repos: repos})
+}
+
+// handleSearch 在全文索引中搜索, 参数与 gitar grep 相同
+func (me *archiveServer) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+	form := parseSearchForm(r)
+	page := &pageData{Title: "Search", Search: form}
+	if form.Pattern != "" {
+		matches, truncated, status, err := me.search(r.Context(), form)
+		switch {
+		case status == http.StatusInternalServerError:
+			serverError(w, err)
+			return
+		case err != nil:
+			page.Message = err.Error()
+		case len(matches) == 0:
+			page.Message = "No match."
+		}
+		page.Matches = matches
+		page.Truncated = truncated
+	}
+	renderPage(w, "search", page)
+}
+
+// handleRepos 处理 /repos/{platform}/{owner}/{repo}[/{name}/{tree|raw|archive}[/{path}]]
+func (me *archiveServer) handleRepos(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/repos/"), "/", 6)
+	for len(parts) < 6 {
+		parts = append(parts, "")
+	}
+	platform, owner, repo, name, action, rest := parts[0], parts[1], parts[2], parts[3], parts[4], parts[5]
+	if platform == "" || owner == "" || repo == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if name == "" {
+		me.handleSnapshots(w, r, platform, owner, repo)
+		return
+	}
+
+	snapshot, err := me.store.GetSnapshot(platform, owner, repo, name)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if snapshot == nil {
+		http.NotFound(w, r)
+		return
+	}
+	switch action {
+	case "":
+		http.Redirect(w, r, pageLink(snapshotBase(platform, owner, repo, name), "tree")+"/", http.StatusFound)
+	case "tree":
+		me.handleTree(w, r, snapshot, rest)
+	case "raw":
+		me.handleRaw(w, r, snapshot, rest)
+	case "archive":
+		me.handleArchive(w, r, snapshot)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (me *archiveServer) handleSnapshots(w http.ResponseWriter, r *http.Request, platform, owner, repo string) {
+	snapshots, err := me.store.ListSnapshots(owner, repo)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	var matched []data.Snapshot
+	for _, snapshot := range snapshots {
+		if snapshot.Platform == platform {
+			matched = append(matched, snapshot)
+		}
+	}
+	if len(matched) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	renderPage(w, "snapshots", &pageData{
+		Title:     owner + "/" + repo,
+		Crumbs:    pathCrumbs(platform, owner, repo, "", ""),
+		Snapshots: matched,
+	})
+}
+
+// handleTree 列出目录, 文件则显示内容
+func (me *archiveServer) handleTree(w http.ResponseWriter, r *http.Request, snapshot *data.Snapshot, name string) {
+	index, err := me.cache.Get(snapshot)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	entry := index.Lookup(name)
+	if entry == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	page := &pageData{
+		Title: snapshot.Name + "/" + entry.Path,
+		Base:  snapshotBase(snapshot.Platform, snapshot.Owner, snapshot.Repo, snapshot.Name),
+		Entry: entry,
+	}
+	if entry.Type == tar.TypeDir {
+		page.Crumbs = pathCrumbs(snapshot.Platform, snapshot.Owner, snapshot.Repo, snapshot.Name, entry.Path)
+		page.Entries = index.Children(entry.Path)
+		renderPage(w, "tree", page)
+		return
+	}
+
+	page.Crumbs = pathCrumbs(snapshot.Platform, snapshot.Owner, snapshot.Repo, snapshot.Name, path.Dir(entry.Path))
+	if entry.Type == tar.TypeReg && entry.Size <= maxDiffFileSize {
+		content, err := readIndexedFile(index, entry)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		if diff.IsText(content) {
+			page.Text = string(content)
+		}
+	}
+	renderPage(w, "blob", page)
+}
+
+// handleRaw 按偏移直接读取缓存的 tar 中的文件, 支持 Range
+func (me *archiveServer) handleRaw(w http.ResponseWriter, r *http.Request, snapshot *data.Snapshot, name string) {
+	index, err := me.cache.Get(snapshot)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	entry := index.Lookup(name)
+	if entry == nil || entry.Type != tar.TypeReg {
+		http.NotFound(w, r)
+		return
+	}
+	file, content, err := index.Open(entry)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+
+	// 归档中的 html 等文件不能按原类型在本站点下渲染
+	head := make([]byte, 8000)
+	n, _ := io.ReadFull(content, head)
+	if diff.IsText(head[:n]) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_, err = content.Seek(0, io.SeekStart)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	http.ServeContent(w, r, path.Base(entry.Path), entry.ModTime, content)
+}
+
+// handleArchive 下载整个归档, tar.xz 直接发送, 其它格式发送缓存中还原的 tar
+func (me *archiveServer) handleArchive(w http.ResponseWriter, r *http.Request, snapshot *data.Snapshot) {
+	format, err := archiveFormat(snapshot.Path)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	filePath := snapshot.Path
+	fileName := snapshot.Name + ".tar.xz"
+	if format != config.StoreModeXz {
+		index, err := me.cache.Get(snapshot)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		filePath = index.tarPath
+		fileName = snapshot.Name + ".tar"
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	http.ServeContent(w, r, filepath.Base(filePath), snapshot.Created, file)
+}
+
+// searchForm 是搜索页面和 /api/search 的参数, repo, version 和 include 可以有多个
+type searchForm struct {
+	Pattern    string
+	Repos      []string
+	Versions   []string
+	Include    []string
+	IgnoreCase bool
+	Fixed      bool
+}
+
+func parseSearchForm(r *http.Request) *searchForm {
+	query := r.URL.Query()
+	values := func(key string) []string {
+		var result []string
+		for _, value := range query[key] {
+			if value = strings.TrimSpace(value); value != "" {
+				result = append(result, value)
+			}
+		}
+		return result
+	}
+	return &searchForm{
+		Pattern:    query.Get("q"),
+		Repos:      values("repo"),
+		Versions:   values("version"),
+		Include:    values("include"),
+		IgnoreCase: query.Get("i") != "",
+		Fixed:      query.Get("fixed") != "",
+	}
+}
+
+// search 执行搜索, 参数错误时返回 400, 其它错误返回 500
+func (me *archiveServer) search(ctx context.Context, form *searchForm) ([]search.Match, bool, int, error) {
+	err := checkRepoFilters(form.Repos)
+	if err == nil {
+		err = checkIncludePatterns(form.Include)
+	}
+	if err != nil {
+		return nil, false, http.StatusBadRequest, err
+	}
+	snapshots, err := me.index.Snapshots()
+	if err != nil {
+		return nil, false, http.StatusInternalServerError, err
+	}
+	ids, err := selectIndexedSnapshots(snapshots, form.Repos, form.Versions)
+	if err != nil {
+		return nil, false, http.StatusBadRequest, err
+	}
+	matches, truncated, err := me.index.Search(ctx, search.Options{
+		Pattern:    form.Pattern,
+		IgnoreCase: form.IgnoreCase,
+		Fixed:      form.Fixed,
+		Snapshots:  ids,
+		Filter:     includeFilter(form.Include),
+		Limit:      maxSearchResults,
+	})
+	if syntaxErr := new(syntax.Error); errors.As(err, &syntaxErr) {
+		return nil, false, http.StatusBadRequest, err
+	}
+	if err != nil {
+		return nil, false, http.StatusInternalServerError, err
+	}
+	return matches, truncated, http.StatusOK, nil
+}
+
+func readIndexedFile(index *tarIndex, entry *tarEntry) ([]byte, error) {
+	file, content, err := index.Open(entry)
+	if err != nil {
+		return nil, err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+	return io.ReadAll(content)
+}
+
+func renderPage(w http.ResponseWriter, name string, page *pageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := serveTemplates.ExecuteTemplate(w, name, page)
+	if err != nil {
+		logrus.Error(err)
+	}
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	return false
+}
+
+func serverError(w http.ResponseWriter, err error) {
+	logrus.Error(err)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
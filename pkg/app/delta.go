@@ -0,0 +1,239 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gitar/pkg/client/common"
+	"gitar/pkg/config"
+	"gitar/pkg/data"
+	"gitar/pkg/delta"
+	"gitar/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// 计算差异时需要将两个版本的 tar 都读入内存, 超过该大小时保存完整归档
+	maxDeltaSize = 1 << 30
+
+	// 还原差异文件时基准归档的临时文件名前缀
+	deltaBasePrefix = "delta-base-"
+)
+
+// storeDelta 保存 delta 模式的快照: 与仓库上一个快照计算差异,
+// 没有可用的基准, 链长度达到间隔或差异不比完整归档小时保存完整归档
+func storeDelta(ctx context.Context, cfg *config.ConfigProperties, store data.DataStore, snapshot *data.Snapshot,
+	tempXzPath string) error {
+	digest, size, err := tarXzDigest(tempXzPath)
+	if err != nil {
+		return err
+	}
+	snapshot.Sha256, snapshot.Size = digest, size
+
+	base, err := selectDeltaBase(cfg, store, snapshot)
+	if err != nil {
+		return err
+	}
+	if base != nil {
+		tempDeltaPath := strings.TrimSuffix(tempXzPath, ".tar.xz") + delta.Suffix
+		smaller, err := writeDelta(ctx, cfg, base, tempXzPath, tempDeltaPath)
+		if err != nil && ctx.Err() != nil {
+			removeTempFiles(tempDeltaPath)
+			return ctx.Err()
+		}
+		if err != nil {
+			logrus.Warnf("Delta against %s failed, saving full archive: %s", base.Name, err.Error())
+		}
+		if err == nil && smaller {
+			destPath := strings.TrimSuffix(snapshot.Path, ".tar.xz") + delta.Suffix
+			err = utils.MoveFile(tempDeltaPath, destPath)
+			if err != nil {
+				return err
+			}
+			removeTempFiles(tempXzPath)
+			snapshot.Format = config.StoreModeDelta
+			snapshot.Path = destPath
+			snapshot.Base = base.Name
+			snapshot.Depth = base.Depth + 1
+			return nil
+		}
+		removeTempFiles(tempDeltaPath)
+	}
+
+	snapshot.Format = config.StoreModeXz
+	return utils.MoveFile(tempXzPath, snapshot.Path)
+}
+
+// selectDeltaBase 选择仓库最近的快照作为基准, 不适合计算差异时返回 nil
+func selectDeltaBase(cfg *config.ConfigProperties, store data.DataStore,
+	snapshot *data.Snapshot) (*data.Snapshot, error) {
+	base, err := store.LatestSnapshot(snapshot.Platform, snapshot.Owner, snapshot.Repo)
+	if err != nil || base == nil {
+		return nil, err
+	}
+	if base.Name == snapshot.Name || filepath.Dir(base.Path) != filepath.Dir(snapshot.Path) {
+		return nil, nil
+	}
+	if base.Depth+1 >= cfg.KeyframeInterval() {
+		logrus.Infof("Keyframe: %d snapshots since the last full archive", base.Depth+1)
+		return nil, nil
+	}
+	if base.Size > maxDeltaSize || snapshot.Size > maxDeltaSize {
+		return nil, nil
+	}
+	exists, err := utils.FileExists(base.Path)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return base, nil
+}
+
+// writeDelta 计算 tempXzPath 相对基准快照的差异并压缩保存, 返回差异是否比完整归档小
+func writeDelta(ctx context.Context, cfg *config.ConfigProperties, base *data.Snapshot,
+	tempXzPath, tempDeltaPath string) (bool, error) {
+	baseData, err := readSnapshotTar(cfg, base)
+	if err != nil {
+		return false, err
+	}
+	reader, err := utils.OpenTarXz(tempXzPath)
+	if err != nil {
+		return false, err
+	}
+	target, err := io.ReadAll(reader)
+	closeErr := reader.Close()
+	if err != nil {
+		return false, err
+	}
+	if closeErr != nil {
+		return false, closeErr
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		header := delta.Header{Base: filepath.Base(base.Path)}
+		err := delta.Encode(header, baseData, target, pipeWriter)
+		_ = pipeWriter.CloseWithError(err)
+	}()
+	err = utils.CompressXz(ctx, pipeReader, tempDeltaPath)
+	_ = pipeReader.Close()
+	if err != nil {
+		return false, err
+	}
+
+	deltaSize, err := utils.GetFileSize(tempDeltaPath)
+	if err != nil {
+		return false, err
+	}
+	xzSize, err := utils.GetFileSize(tempXzPath)
+	if err != nil {
+		return false, err
+	}
+	logrus.Infof("Delta: %s against %s (full archive %s)",
+		utils.HumanReadableSize(deltaSize), base.Name, utils.HumanReadableSize(xzSize))
+	return deltaSize < xzSize, nil
+}
+
+// readSnapshotTar 将快照还原为 tar 读入内存, 并校验 SHA-256
+func readSnapshotTar(cfg *config.ConfigProperties, snapshot *data.Snapshot) ([]byte, error) {
+	reader, err := openArchive(cfg, snapshot.Path)
+	if err != nil {
+		return nil, err
+	}
+	content, err := io.ReadAll(reader)
+	closeErr := reader.Close()
+	if err != nil {
+		return nil, err
+	}
+	if closeErr != nil {
+		return nil, closeErr
+	}
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+	if snapshot.Sha256 != "" && digest != snapshot.Sha256 {
+		return nil, fmt.Errorf("snapshot %s checksum mismatch: expected %s, got %s",
+			snapshot.Name, snapshot.Sha256, digest)
+	}
+	return content, nil
+}
+
+// openDelta 返回差异文件还原后的 tar 流. 基准归档与差异文件在同一目录,
+// 先还原到临时文件并校验, 基准也是差异文件时沿着链递归还原
+func openDelta(cfg *config.ConfigProperties, path string) (io.ReadCloser, error) {
+	reader, err := utils.OpenTarXz(path)
+	if err != nil {
+		return nil, err
+	}
+	deltaReader := bufio.NewReader(reader)
+	header, err := delta.ReadHeader(deltaReader)
+	if err != nil {
+		_ = reader.Close()
+		return nil, fmt.Errorf("read delta %s: %w", path, err)
+	}
+
+	baseFile, err := materializeDeltaBase(cfg, filepath.Join(filepath.Dir(path), header.Base), header.BaseSha256)
+	if err != nil {
+		_ = reader.Close()
+		return nil, err
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		err := delta.Apply(header, baseFile, deltaReader, pipeWriter)
+		_ = pipeWriter.CloseWithError(err)
+		_ = reader.Close()
+		_ = baseFile.Close()
+		removeTempFiles(baseFile.Name())
+	}()
+	return pipeReader, nil
+}
+
+// materializeDeltaBase 将基准归档还原到临时文件, 调用方负责关闭和删除
+func materializeDeltaBase(cfg *config.ConfigProperties, basePath, baseSha256 string) (*os.File, error) {
+	baseReader, err := openArchive(cfg, basePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func(baseReader io.ReadCloser) {
+		_ = baseReader.Close()
+	}(baseReader)
+
+	baseFile, err := os.CreateTemp(cfg.Paths.Temp, deltaBasePrefix+"*.tar")
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(baseFile, hash), baseReader)
+	if err == nil {
+		digest := hex.EncodeToString(hash.Sum(nil))
+		if digest != baseSha256 {
+			err = fmt.Errorf("delta base %s checksum mismatch: expected %s, got %s", basePath, baseSha256, digest)
+		}
+	}
+	if err != nil {
+		_ = baseFile.Close()
+		removeTempFiles(baseFile.Name())
+		return nil, err
+	}
+	return baseFile, nil
+}
+
+// isDeltaBaseMailed 检查差异快照的基准是否已经通过邮件发送过
+func isDeltaBaseMailed(store data.DataStore, repoUrl *common.RepoUrl, arc *common.ArchiveInfo) (bool, error) {
+	platform := repoPlatform(repoUrl)
+	snapshot, err := store.GetSnapshot(platform, repoUrl.Owner, repoUrl.Repo, arc.Name)
+	if err != nil || snapshot == nil || snapshot.Base == "" {
+		return false, err
+	}
+	base, err := store.GetSnapshot(platform, repoUrl.Owner, repoUrl.Repo, snapshot.Base)
+	if err != nil || base == nil {
+		return false, err
+	}
+	return store.IsCommitMailed(base.Commit)
+}
@@ -0,0 +1,192 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+
+	// 排队中的任务上限, 以及保留的已结束任务数量
+	maxQueuedJobs   = 1000
+	maxFinishedJobs = 1000
+)
+
+var errQueueFull = errors.New("job queue is full")
+
+// downloadJob 是通过 API 提交的一个下载任务
+type downloadJob struct {
+	Id       int64      `json:"id"`
+	Url      string     `json:"url"`
+	Options  jobOptions `json:"options"`
+	State    string     `json:"state"`
+	Error    string     `json:"error,omitempty"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+type jobOptions struct {
+	Mail       bool   `json:"mail,omitempty"`
+	Submodules bool   `json:"submodules,omitempty"`
+	NoCache    bool   `json:"no_cache,omitempty"`
+	Patch      bool   `json:"patch,omitempty"`
+	At         string `json:"at,omitempty"`
+}
+
+// jobQueue 按提交顺序逐个执行下载, 与 gitar dl 共用 DoDownloadArchive, 任务只保存在内存中
+type jobQueue struct {
+	mutex   sync.Mutex
+	jobs    []*downloadJob
+	nextId  int64
+	pending chan *downloadJob
+	// download 执行一个任务, 测试中可以替换
+	download func(ctx context.Context, url string, opts DownloadOptions) error
+}
+
+func newJobQueue() *jobQueue {
+	return &jobQueue{
+		nextId:   1,
+		pending:  make(chan *downloadJob, maxQueuedJobs),
+		download: DoDownloadArchive,
+	}
+}
+
+// Submit 提交下载任务, 相同的 url 和选项已在排队时返回已有的任务
+func (me *jobQueue) Submit(url string, opts jobOptions) (*downloadJob, bool, error) {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	for _, job := range me.jobs {
+		if job.State == JobQueued && job.Url == url && job.Options == opts {
+			copied := *job
+			return &copied, false, nil
+		}
+	}
+
+	job := &downloadJob{
+		Id:      me.nextId,
+		Url:     url,
+		Options: opts,
+		State:   JobQueued,
+		Created: time.Now(),
+	}
+	select {
+	case me.pending <- job:
+	default:
+		return nil, false, errQueueFull
+	}
+	me.nextId++
+	me.jobs = append(me.jobs, job)
+	me.prune()
+	copied := *job
+	return &copied, true, nil
+}
+
+// List 返回所有任务的副本, 新提交的在前
+func (me *jobQueue) List() []downloadJob {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	jobs := make([]downloadJob, 0, len(me.jobs))
+	for i := len(me.jobs) - 1; i >= 0; i-- {
+		jobs = append(jobs, *me.jobs[i])
+	}
+	return jobs
+}
+
+// Get 按 id 查找任务, 找不到时返回 nil
+func (me *jobQueue) Get(id int64) *downloadJob {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	for _, job := range me.jobs {
+		if job.Id == id {
+			copied := *job
+			return &copied
+		}
+	}
+	return nil
+}
+
+// Run 逐个执行排队的任务, ctx 取消时正在执行的下载也会中止
+func (me *jobQueue) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-me.pending:
+			me.run(ctx, job)
+		}
+	}
+}
+
+func (me *jobQueue) run(ctx context.Context, job *downloadJob) {
+	me.update(job, func() {
+		now := time.Now()
+		job.State = JobRunning
+		job.Started = &now
+	})
+	logrus.Infof("Job %d: %s", job.Id, job.Url)
+
+	err := func() error {
+		at, err := parseAtDate(job.Options.At)
+		if err != nil {
+			return err
+		}
+		return me.download(ctx, job.Url, DownloadOptions{
+			Mail:       job.Options.Mail,
+			Submodules: job.Options.Submodules,
+			NoCache:    job.Options.NoCache,
+			Patch:      job.Options.Patch,
+			At:         at,
+		})
+	}()
+	if err != nil {
+		logrus.Errorf("Job %d failed: %s", job.Id, err.Error())
+	}
+
+	me.update(job, func() {
+		now := time.Now()
+		job.Finished = &now
+		job.State = JobDone
+		if err != nil {
+			job.State = JobFailed
+			job.Error = err.Error()
+		}
+	})
+}
+
+func (me *jobQueue) update(job *downloadJob, fn func()) {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	fn()
+	me.prune()
+}
+
+// prune 只保留最近 maxFinishedJobs 个已结束的任务
+func (me *jobQueue) prune() {
+	finished := 0
+	for _, job := range me.jobs {
+		if job.State == JobDone || job.State == JobFailed {
+			finished++
+		}
+	}
+	if finished <= maxFinishedJobs {
+		return
+	}
+	jobs := me.jobs[:0]
+	for _, job := range me.jobs {
+		if finished > maxFinishedJobs && (job.State == JobDone || job.State == JobFailed) {
+			finished--
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	me.jobs = jobs
+}
@@ -0,0 +1,90 @@
+package app
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"gitar/pkg/client/common"
+	"gitar/pkg/client/github"
+	"gitar/pkg/config"
+	"gitar/pkg/lfs"
+	"gitar/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+type tempFileReader struct {
+	*os.File
+}
+
+func (me *tempFileReader) Close() error {
+	err := me.File.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(me.Name())
+}
+
+// newLfsRewriter 将归档中的 LFS 指针文件替换为实际的对象内容
+func newLfsRewriter(ctx context.Context, cfg *config.ConfigProperties, repoUrl *common.RepoUrl,
+	props config.LfsProperties) utils.TarRewriteFunc {
+	endpoint := props.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s/%s/%s.git/info/lfs", repoUrl.Host, repoUrl.Owner, repoUrl.Repo)
+	}
+	client := lfs.NewClient(&http.Client{}, endpoint, lfsToken(cfg, repoUrl, endpoint))
+
+	return func(header *tar.Header, content []byte) (io.ReadCloser, int64, error) {
+		pointer := lfs.ParsePointer(content)
+		if pointer == nil {
+			return nil, 0, nil
+		}
+		if props.MaxSize > 0 && pointer.Size > props.MaxSize {
+			logrus.Warnf("LFS object too large, keep pointer: %s (%s)",
+				header.Name, utils.HumanReadableSize(int(pointer.Size)))
+			return nil, 0, nil
+		}
+
+		logrus.Infof("LFS: %s (%s)", header.Name, utils.HumanReadableSize(int(pointer.Size)))
+		file, err := os.CreateTemp(cfg.Paths.Temp, "lfs-*")
+		if err != nil {
+			return nil, 0, err
+		}
+		reader := &tempFileReader{File: file}
+
+		err = client.Download(ctx, pointer, file)
+		if err == nil {
+			_, err = file.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			_ = reader.Close()
+			return nil, 0, fmt.Errorf("%s: %w", header.Name, err)
+		}
+		return reader, pointer.Size, nil
+	}
+}
+
+// lfsToken 返回仓库所在 GitHub 主机配置的第一个 Token, 自定义的 endpoint 不在同一主机时不发送
+func lfsToken(cfg *config.ConfigProperties, repoUrl *common.RepoUrl, endpoint string) string {
+	if repoUrl.Platform != github.Platform {
+		return ""
+	}
+	parsed, err := url.Parse(endpoint)
+	if err != nil || !strings.EqualFold(parsed.Hostname(), repoUrl.Host) {
+		return ""
+	}
+	hostCfg := cfg.GitHub.FindHost(repoUrl.Host)
+	if hostCfg == nil {
+		return ""
+	}
+	tokens := hostCfg.AllTokens()
+	if len(tokens) <= 0 {
+		return ""
+	}
+	return tokens[0]
+}
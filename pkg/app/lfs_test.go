@@ -0,0 +1,123 @@
+package app
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"gitar/pkg/client/common"
+	"gitar/pkg/client/github"
+	"gitar/pkg/config"
+)
+
+func TestLfsRewriter(t *testing.T) {
+	small := []byte("small object")
+	large := []byte("an object larger than max-size")
+	objects := map[string][]byte{}
+	pointers := map[string][]byte{}
+	for name, content := range map[string][]byte{"small": small, "large": large} {
+		hash := sha256.Sum256(content)
+		oid := hex.EncodeToString(hash[:])
+		objects[oid] = content
+		pointers[name] = []byte(fmt.Sprintf("version https://git-lfs.github.com/spec/v1\noid sha256:%s\nsize %d\n",
+			oid, len(content)))
+	}
+
+	var batchAuth []string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/owner/repo.git/info/lfs/objects/batch" {
+			_, password, _ := r.BasicAuth()
+			batchAuth = append(batchAuth, password)
+			var request struct {
+				Objects []struct {
+					Oid  string `json:"oid"`
+					Size int64  `json:"size"`
+				} `json:"objects"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&request)
+			objects := []map[string]any{}
+			for _, object := range request.Objects {
+				objects = append(objects, map[string]any{
+					"oid": object.Oid, "size": object.Size,
+					"actions": map[string]any{"download": map[string]any{"href": server.URL + "/storage/" + object.Oid}},
+				})
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"objects": objects})
+			return
+		}
+		content, found := objects[r.URL.Path[len("/storage/"):]]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(content)
+	}))
+	defer server.Close()
+
+	serverUrl, _ := url.Parse(server.URL)
+	cfg := &config.ConfigProperties{
+		Paths:  config.PathsProperties{Temp: t.TempDir()},
+		GitHub: config.GitHubProperties{Hosts: []config.GitHubHostProperties{{Host: serverUrl.Hostname(), Token: "secret"}}},
+	}
+	repoUrl := &common.RepoUrl{Platform: github.Platform, Host: serverUrl.Hostname(), Owner: "owner", Repo: "repo"}
+	rewrite := newLfsRewriter(context.Background(), cfg, repoUrl, config.LfsProperties{
+		Enabled:  true,
+		Endpoint: server.URL + "/owner/repo.git/info/lfs",
+		MaxSize:  int64(len(small)),
+	})
+
+	// 普通文件不替换
+	reader, _, err := rewrite(&tar.Header{Name: "README.md"}, []byte("# readme"))
+	if reader != nil || err != nil {
+		t.Fatalf("plain file should not be rewritten: %v", err)
+	}
+
+	// 超过 max-size 的对象保留指针
+	reader, _, err = rewrite(&tar.Header{Name: "large.bin"}, pointers["large"])
+	if reader != nil || err != nil {
+		t.Fatalf("large object should keep its pointer: %v", err)
+	}
+
+	reader, size, err := rewrite(&tar.Header{Name: "small.bin"}, pointers["small"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(reader)
+	_ = reader.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != string(small) || size != int64(len(small)) {
+		t.Errorf("got %q (%d), expected %q", content, size, small)
+	}
+	if len(batchAuth) != 1 || batchAuth[0] != "secret" {
+		t.Errorf("batch requests should send the host token, got %v", batchAuth)
+	}
+}
+
+func TestLfsToken(t *testing.T) {
+	cfg := &config.ConfigProperties{GitHub: config.GitHubProperties{Token: "token-1"}}
+	repoUrl := &common.RepoUrl{Platform: github.Platform, Host: github.Host, Owner: "owner", Repo: "repo"}
+	cases := []struct {
+		endpoint string
+		expected string
+	}{
+		{"https://github.com/owner/repo.git/info/lfs", "token-1"},
+		{"https://lfs.example.com/owner/repo", ""},
+	}
+	for _, c := range cases {
+		token := lfsToken(cfg, repoUrl, c.endpoint)
+		if token != c.expected {
+			t.Errorf("lfsToken(%s) = %q, expected %q", c.endpoint, token, c.expected)
+		}
+	}
+}
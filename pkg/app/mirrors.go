@@ -0,0 +1,107 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"gitar/pkg/client/common"
+	"gitar/pkg/config"
+	"gitar/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	mirrorMaxTries = 3
+)
+
+// mirrorUrls 按配置顺序返回改写后的镜像地址, 最后一个总是原始地址
+func mirrorUrls(cfg *config.ConfigProperties, rawUrl string) ([]string, error) {
+	urls := []string{}
+	for _, mirror := range cfg.Mirrors {
+		re, err := regexp.Compile(mirror.Match)
+		if err != nil {
+			return nil, err
+		}
+		if !re.MatchString(rawUrl) {
+			continue
+		}
+		urls = append(urls, re.ReplaceAllString(rawUrl, mirror.Replace))
+	}
+	return append(urls, rawUrl), nil
+}
+
+// downloadTarball 依次尝试从镜像下载归档, 镜像返回的内容必须与解析到的 Commit 一致.
+// 临时目录中有上次遗留的部分文件时先尝试续传, 返回值表示结果是否来自续传
+func downloadTarball(ctx context.Context, cfg *config.ConfigProperties, arc *common.ArchiveInfo,
+	tempFile string) (bool, error) {
+	tempPath := filepath.Join(cfg.Paths.Temp, tempFile)
+	urls, err := mirrorUrls(cfg, arc.TarUrl)
+	if err != nil {
+		return false, err
+	}
+
+	partialSize, err := utils.GetFileSize(tempPath)
+	if err == nil && partialSize > 0 {
+		resumed, err := resumeTarball(ctx, cfg, arc, urls[0], len(urls) == 1, tempFile)
+		if resumed || ctx.Err() != nil {
+			return resumed, err
+		}
+	}
+
+	for i, url := range urls {
+		isOrigin := i == len(urls)-1
+		maxTries := mirrorMaxTries
+		if isOrigin {
+			maxTries = -1
+		}
+
+		err = os.RemoveAll(tempPath)
+		if err != nil {
+			return false, err
+		}
+
+		logrus.Infof("Downloading: %s", url)
+		err = utils.CurlDownload(ctx, url, cfg.Paths.Temp, tempFile, maxTries)
+		if err == nil && !isOrigin {
+			err = verifyTarballCommit(tempPath, arc.Commit)
+		}
+		if err == nil || isOrigin || ctx.Err() != nil {
+			return false, err
+		}
+		logrus.Warnf("Mirror failed: %s: %s", url, err.Error())
+	}
+	return false, nil
+}
+
+// resumeTarball 从第一个地址续传一次, 失败时删除部分文件, 由调用方重新下载
+func resumeTarball(ctx context.Context, cfg *config.ConfigProperties, arc *common.ArchiveInfo,
+	url string, isOrigin bool, tempFile string) (bool, error) {
+	tempPath := filepath.Join(cfg.Paths.Temp, tempFile)
+	logrus.Infof("Resuming: %s", url)
+	err := utils.CurlResume(ctx, url, cfg.Paths.Temp, tempFile)
+	if err == nil && !isOrigin {
+		err = verifyTarballCommit(tempPath, arc.Commit)
+	}
+	if err == nil {
+		return true, nil
+	}
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	logrus.Warnf("Resume failed: %s: %s", url, err.Error())
+	return false, os.RemoveAll(tempPath)
+}
+
+func verifyTarballCommit(path, commit string) error {
+	actual, err := utils.ReadTarGzCommit(path)
+	if err != nil {
+		return err
+	}
+	if actual != commit {
+		return fmt.Errorf("commit mismatch: expected %s, got %q", commit, actual)
+	}
+	return nil
+}
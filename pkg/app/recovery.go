@@ -0,0 +1,146 @@
+package app
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gitar/pkg/fslock"
+	"gitar/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// 超过该时间的 tar.gz 不再续传
+	tempMaxAge = 24 * time.Hour
+	// 超过该时间没有写入的 .partial 文件视为中断后的遗留文件
+	partialMaxAge = time.Hour
+)
+
+var (
+	tempArchivePattern = regexp.MustCompile(`-([0-9a-f]{40})\.(tar\.gz|tar\.xz|delta\.xz)$`)
+)
+
+// recoverTempDir 清理上次异常退出遗留在临时目录中的文件:
+// 没有进程持有的锁文件, tar.xz 和差异文件直接删除, 较新的 tar.gz 保留用于续传, 过旧的删除.
+// 正被其它进程使用的 Commit 的文件不做处理
+func recoverTempDir(tempDir string) error {
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		path := filepath.Join(tempDir, name)
+
+		if strings.HasSuffix(name, ".lock") {
+			commit := strings.TrimSuffix(name, ".lock")
+			if !isCommitLocked(tempDir, commit) {
+				logrus.Debugf("Recovered stale lock: %s", name)
+			}
+			continue
+		}
+
+		// 还原差异文件时使用的基准, 正常情况下用完即删除
+		if strings.HasPrefix(name, deltaBasePrefix) {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			if time.Since(info.ModTime()) >= tempMaxAge {
+				logrus.Infof("Purge stale temp file: %s", name)
+				err = os.RemoveAll(path)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		match := tempArchivePattern.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+		if isCommitLocked(tempDir, match[1]) {
+			continue
+		}
+
+		if match[2] == "tar.gz" {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			if time.Since(info.ModTime()) < tempMaxAge {
+				logrus.Infof("Keep partial download for resuming: %s", name)
+				continue
+			}
+		}
+		logrus.Infof("Purge stale temp file: %s", name)
+		err = os.RemoveAll(path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverPartialFiles 删除复制到目标目录时中断遗留的 .partial 文件, 这些文件无法续传.
+// 其它进程可能正在写入, 只删除一段时间内没有修改过的文件
+func recoverPartialFiles(dirs ...string) error {
+	visited := utils.NewStringSet(nil)
+	for _, dir := range dirs {
+		if visited.Contains(dir) {
+			continue
+		}
+		visited.Add(dir)
+		err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), utils.PartialSuffix) {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			if time.Since(info.ModTime()) < partialMaxAge {
+				return nil
+			}
+			logrus.Infof("Purge partial file: %s", path)
+			err = os.Remove(path)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isCommitLocked 检查 Commit 的锁是否被其它进程持有, 没有被持有时锁文件会在解锁时删除
+func isCommitLocked(tempDir, commit string) bool {
+	lock := fslock.New(filepath.Join(tempDir, commit+".lock"))
+	err := lock.TryLock()
+	if err != nil {
+		return true
+	}
+	err = lock.Unlock()
+	if err != nil {
+		logrus.Error(err)
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecoverPartialFiles(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-2 * partialMaxAge)
+	files := map[string]bool{
+		"github.com/owner/repo/.repo-v1.tar.xz.123.partial": false,
+		"github.com/owner/repo/repo-v1.tar.xz":              true,
+		"cache/archive/ab/.456.partial":                     false,
+		"cache/archive/ab/.789.partial":                     true,
+	}
+	for name := range files {
+		path := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(name), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// .789.partial 是正在写入的文件
+		if name != "cache/archive/ab/.789.partial" {
+			_ = os.Chtimes(path, old, old)
+		}
+	}
+
+	err := recoverPartialFiles(dir, filepath.Join(dir, "cache"), filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, kept := range files {
+		_, err = os.Stat(filepath.Join(dir, name))
+		if kept != (err == nil) {
+			t.Errorf("%s: expected kept=%v, got err=%v", name, kept, err)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package app
+
+import (
+	"archive/tar"
+	"html/template"
+	"net/url"
+	"path"
+	"strings"
+
+	"gitar/pkg/utils"
+)
+
+var serveTemplates = template.Must(template.New("").Funcs(template.FuncMap{
+	"size": func(size int64) string {
+		return utils.HumanReadableSize(int(size))
+	},
+	"short": shortCommit,
+	"link":  pageLink,
+	"base":  path.Base,
+	"isDir": func(entry tarEntry) bool {
+		return entry.Type == tar.TypeDir
+	},
+	"isLink": func(entry tarEntry) bool {
+		return entry.Type == tar.TypeSymlink
+	},
+	"first": func(values []string) string {
+		if len(values) == 0 {
+			return ""
+		}
+		return values[0]
+	},
+}).Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} - gitar</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { padding: 2px 12px; text-align: left; }
+td.num { text-align: right; }
+pre { background: #f6f8fa; padding: 1em; overflow: auto; }
+</style>
+</head>
+<body>
+<p><a href="/">gitar</a>{{range .Crumbs}} / <a href="{{.Link}}">{{.Name}}</a>{{end}}</p>
+{{end}}
+
+{{define "footer"}}</body>
+</html>
+{{end}}
+
+{{define "repos"}}{{template "header" .}}
+<h2>Repositories</h2>
+<p><a href="/search">Search</a></p>
+<table>
+<tr><th>Repository</th><th>Platform</th><th>Snapshots</th></tr>
+{{range .Repos}}<tr>
+<td><a href="{{link "repos" .Platform .Owner .Repo}}">{{.Owner}}/{{.Repo}}</a></td>
+<td>{{.Platform}}</td>
+<td class="num">{{.Snapshots}}</td>
+</tr>
+{{end}}</table>
+{{template "footer" .}}{{end}}
+
+{{define "snapshots"}}{{template "header" .}}
+<h2>{{.Title}}</h2>
+<table>
+<tr><th>Snapshot</th><th>Commit</th><th>Format</th><th>Size</th><th>Saved</th><th></th></tr>
+{{range .Snapshots}}<tr>
+<td><a href="{{link "repos" .Platform .Owner .Repo .Name "tree"}}/">{{.Name}}</a></td>
+<td>{{short .Commit}}</td>
+<td>{{.Format}}</td>
+<td class="num">{{size .Size}}</td>
+<td>{{.Created.Format "2006-01-02 15:04"}}</td>
+<td><a href="{{link "repos" .Platform .Owner .Repo .Name "archive"}}">download</a></td>
+</tr>
+{{end}}</table>
+{{template "footer" .}}{{end}}
+
+{{define "tree"}}{{template "header" .}}
+<h2>{{.Title}}</h2>
+<table>
+{{range .Entries}}<tr>
+{{if isDir .}}<td><a href="{{link $.Base "tree" .Path}}/">{{base .Path}}/</a></td><td></td>
+{{else if isLink .}}<td>{{base .Path}} &rarr; {{.Link}}</td><td></td>
+{{else}}<td><a href="{{link $.Base "tree" .Path}}">{{base .Path}}</a></td><td class="num">{{size .Size}}</td>
+{{end}}</tr>
+{{end}}</table>
+{{template "footer" .}}{{end}}
+
+{{define "blob"}}{{template "header" .}}
+<h2>{{.Title}}</h2>
+<p>{{size .Entry.Size}} &middot; <a href="{{link .Base "raw" .Entry.Path}}">raw</a></p>
+{{if .Text}}<pre>{{.Text}}</pre>{{else}}<p>Binary or large file.</p>{{end}}
+{{template "footer" .}}{{end}}
+
+{{define "search"}}{{template "header" .}}
+<h2>Search</h2>
+<form action="/search" method="get">
+<p>
+<input type="text" name="q" size="40" placeholder="regexp" value="{{.Search.Pattern}}">
+<input type="text" name="repo" placeholder="owner/repo" value="{{first .Search.Repos}}">
+<input type="text" name="version" placeholder="tag, branch, commit or glob" value="{{first .Search.Versions}}">
+<input type="text" name="include" placeholder="path glob" value="{{first .Search.Include}}">
+<label><input type="checkbox" name="i" value="1"{{if .Search.IgnoreCase}} checked{{end}}> ignore case</label>
+<label><input type="checkbox" name="fixed" value="1"{{if .Search.Fixed}} checked{{end}}> fixed string</label>
+<input type="submit" value="Search">
+</p>
+</form>
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+{{if .Matches}}<table>
+{{range .Matches}}<tr>
+<td><a href="{{link "repos" .Snapshot.Platform .Snapshot.Owner .Snapshot.Repo .Snapshot.Name "tree"}}/">{{.Snapshot.Name}}</a></td>
+<td><a href="{{link "repos" .Snapshot.Platform .Snapshot.Owner .Snapshot.Repo .Snapshot.Name "tree" .Path}}">{{.Path}}</a>:{{.Line}}</td>
+<td><code>{{.Text}}</code></td>
+</tr>
+{{end}}</table>
+{{if .Truncated}}<p>Only the first {{len .Matches}} lines are shown.</p>{{end}}{{end}}
+{{template "footer" .}}{{end}}
+`))
+
+type pageCrumb struct {
+	Name string
+	Link string
+}
+
+// pageLink 拼接页面链接, 每一段分别转义, 段内的 / 保留为路径分隔符
+func pageLink(parts ...string) string {
+	var builder strings.Builder
+	for _, part := range parts {
+		for _, segment := range strings.Split(strings.Trim(part, "/"), "/") {
+			if segment == "" {
+				continue
+			}
+			builder.WriteString("/")
+			builder.WriteString(url.PathEscape(segment))
+		}
+	}
+	if builder.Len() == 0 {
+		return "/"
+	}
+	return builder.String()
+}
+
+// snapshotBase 返回快照页面的路径前缀, 由 pageLink 统一转义
+func snapshotBase(platform, owner, repo, name string) string {
+	return strings.Join([]string{"repos", platform, owner, repo, name}, "/")
+}
+
+// pathCrumbs 返回仓库, 快照和目录的导航链接
+func pathCrumbs(platform, owner, repo, name, dir string) []pageCrumb {
+	crumbs := []pageCrumb{{Name: owner + "/" + repo, Link: pageLink("repos", platform, owner, repo)}}
+	if name == "" {
+		return crumbs
+	}
+	base := snapshotBase(platform, owner, repo, name)
+	crumbs = append(crumbs, pageCrumb{Name: name, Link: pageLink(base, "tree") + "/"})
+	current := ""
+	for _, segment := range strings.Split(strings.Trim(dir, "/"), "/") {
+		if segment == "" {
+			continue
+		}
+		current = path.Join(current, segment)
+		crumbs = append(crumbs, pageCrumb{Name: segment, Link: pageLink(base, "tree", current) + "/"})
+	}
+	return crumbs
+}
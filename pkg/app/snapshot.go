@@ -0,0 +1,296 @@
+package app
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gitar/pkg/cas"
+	"gitar/pkg/client/common"
+	"gitar/pkg/config"
+	"gitar/pkg/data"
+	"gitar/pkg/delta"
+	"gitar/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// gitar 不会写入 zstd 归档, 只读取手动放入仓库目录的 .tar.zst
+	archiveFormatZstd = "zstd"
+)
+
+var (
+	archiveSuffixes = []string{".tar.xz", cas.ManifestSuffix, delta.Suffix, ".tar.zst"}
+)
+
+// archiveFileName 返回归档在仓库目录中的文件名, 取决于保存方式.
+// delta 模式先按完整归档命名, 保存时可能改为差异文件
+func archiveFileName(cfg *config.ConfigProperties, name string) string {
+	if cfg.StoreMode() == config.StoreModeCas {
+		return name + cas.ManifestSuffix
+	}
+	return name + ".tar.xz"
+}
+
+// findArchivePath 查找仓库目录中已保存的归档, 不论保存方式, 没有时返回按当前方式命名的路径
+func findArchivePath(cfg *config.ConfigProperties, destDir, name string) (string, bool, error) {
+	for _, suffix := range archiveSuffixes {
+		path := filepath.Join(destDir, name+suffix)
+		exists, err := utils.FileExists(path)
+		if err != nil {
+			return "", false, err
+		}
+		if exists {
+			return path, true, nil
+		}
+	}
+	return filepath.Join(destDir, archiveFileName(cfg, name)), false, nil
+}
+
+// archiveFormat 根据文件名判断归档的保存方式
+func archiveFormat(path string) (string, error) {
+	if strings.HasSuffix(path, cas.ManifestSuffix) {
+		return config.StoreModeCas, nil
+	}
+	if strings.HasSuffix(path, delta.Suffix) {
+		return config.StoreModeDelta, nil
+	}
+	if strings.HasSuffix(path, ".tar.xz") {
+		return config.StoreModeXz, nil
+	}
+	if strings.HasSuffix(path, ".tar.zst") {
+		return archiveFormatZstd, nil
+	}
+	return "", fmt.Errorf("unknown archive format: %s", path)
+}
+
+// storeArchive 按保存方式将校验过的 tar.xz 保存到 destPath, 并记录快照.
+// delta 模式可能保存为差异文件, 返回实际保存的路径
+func storeArchive(ctx context.Context, cfg *config.ConfigProperties, store data.DataStore,
+	repoUrl *common.RepoUrl, arc *common.ArchiveInfo, tempXzPath, destPath string) (string, error) {
+	snapshot := data.Snapshot{
+		Platform: repoPlatform(repoUrl),
+		Owner:    repoUrl.Owner,
+		Repo:     repoUrl.Repo,
+		Name:     arc.Name,
+		Commit:   arc.Commit,
+		Format:   cfg.StoreMode(),
+		Path:     destPath,
+	}
+
+	switch snapshot.Format {
+	case config.StoreModeXz:
+		digest, size, err := tarXzDigest(tempXzPath)
+		if err != nil {
+			return "", err
+		}
+		snapshot.Sha256, snapshot.Size = digest, size
+		err = utils.MoveFile(tempXzPath, destPath)
+		if err != nil {
+			return "", err
+		}
+
+	case config.StoreModeCas:
+		manifest, err := ingestArchive(cfg, arc, tempXzPath)
+		if err != nil {
+			return "", err
+		}
+		snapshot.Sha256, snapshot.Size = manifest.Sha256, manifest.Size
+		tempManifest := strings.TrimSuffix(tempXzPath, ".tar.xz") + cas.ManifestSuffix
+		err = cas.WriteManifest(tempManifest, manifest)
+		if err != nil {
+			return "", err
+		}
+		err = utils.MoveFile(tempManifest, destPath)
+		if err != nil {
+			return "", err
+		}
+		removeTempFiles(tempXzPath)
+
+	case config.StoreModeDelta:
+		err := storeDelta(ctx, cfg, store, &snapshot, tempXzPath)
+		if err != nil {
+			return "", err
+		}
+
+	default:
+		return "", fmt.Errorf("unknown store mode: %s", snapshot.Format)
+	}
+	return snapshot.Path, store.SaveSnapshot(snapshot)
+}
+
+func ingestArchive(cfg *config.ConfigProperties, arc *common.ArchiveInfo, xzPath string) (*cas.Manifest, error) {
+	reader, err := utils.OpenTarXz(xzPath)
+	if err != nil {
+		return nil, err
+	}
+	manifest, stats, err := cas.NewStore(cfg.ObjectsDir()).Ingest(reader)
+	closeErr := reader.Close()
+	if err != nil {
+		return nil, err
+	}
+	if closeErr != nil {
+		return nil, closeErr
+	}
+	manifest.Name = arc.Name
+	manifest.Commit = arc.Commit
+	logrus.Infof("Objects: %d files, %d new (%s)",
+		stats.Blobs, stats.NewBlobs, utils.HumanReadableSize(int(stats.NewBytes)))
+	return manifest, nil
+}
+
+func tarXzDigest(xzPath string) (string, int64, error) {
+	reader, err := utils.OpenTarXz(xzPath)
+	if err != nil {
+		return "", 0, err
+	}
+	digest, size, err := utils.ReaderSha256(reader)
+	closeErr := reader.Close()
+	if err != nil {
+		return "", 0, err
+	}
+	return digest, size, closeErr
+}
+
+// openArchive 返回归档解压或还原后的 tar 流
+func openArchive(cfg *config.ConfigProperties, path string) (io.ReadCloser, error) {
+	format, err := archiveFormat(path)
+	if err != nil {
+		return nil, err
+	}
+	if format == config.StoreModeXz {
+		return utils.OpenTarXz(path)
+	}
+	if format == config.StoreModeDelta {
+		return openDelta(cfg, path)
+	}
+	if format == archiveFormatZstd {
+		return utils.OpenTarZst(path)
+	}
+
+	manifest, err := cas.ReadManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		err := cas.NewStore(cfg.ObjectsDir()).Export(manifest, pipeWriter)
+		_ = pipeWriter.CloseWithError(err)
+	}()
+	return pipeReader, nil
+}
+
+// walkSnapshot 依次处理快照中的 tar 条目, 读完后校验整个 tar 的 SHA-256
+func walkSnapshot(ctx context.Context, cfg *config.ConfigProperties, snapshot *data.Snapshot,
+	fn func(header *tar.Header, r io.Reader) error) error {
+	reader, err := openArchive(cfg, snapshot.Path)
+	if err != nil {
+		return err
+	}
+	defer func(reader io.ReadCloser) {
+		_ = reader.Close()
+	}(reader)
+
+	hash := sha256.New()
+	tarReader := tar.NewReader(io.TeeReader(reader, hash))
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		err = fn(header, tarReader)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = io.Copy(hash, reader)
+	if err != nil {
+		return err
+	}
+	digest := hex.EncodeToString(hash.Sum(nil))
+	if snapshot.Sha256 != "" && digest != snapshot.Sha256 {
+		return fmt.Errorf("snapshot %s checksum mismatch: expected %s, got %s", snapshot.Name, snapshot.Sha256, digest)
+	}
+	return nil
+}
+
+func readArchiveEntry(cfg *config.ConfigProperties, path, name string) ([]byte, error) {
+	reader, err := openArchive(cfg, path)
+	if err != nil {
+		return nil, err
+	}
+	defer func(reader io.ReadCloser) {
+		_ = reader.Close()
+	}(reader)
+	return utils.ReadTarEntry(reader, name)
+}
+
+// exportArchive 将归档还原为 tar 写入 outPath, 以 .xz 结尾时压缩
+func exportArchive(ctx context.Context, cfg *config.ConfigProperties, path, outPath string) error {
+	reader, err := openArchive(cfg, path)
+	if err != nil {
+		return err
+	}
+	defer func(reader io.ReadCloser) {
+		_ = reader.Close()
+	}(reader)
+
+	if strings.HasSuffix(outPath, ".xz") {
+		return utils.CompressXz(ctx, reader, outPath)
+	}
+	file, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, reader)
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// mailArchivePath 返回用于发送邮件的文件和邮件标题, cas 模式需要先还原到临时目录.
+// 差异文件的基准已经发送过时直接发送差异文件, 否则同样还原出完整的 tar.xz
+func mailArchivePath(ctx context.Context, cfg *config.ConfigProperties, store data.DataStore,
+	repoUrl *common.RepoUrl, arc *common.ArchiveInfo, destPath string) (string, string, func(), error) {
+	subject := fmt.Sprintf("%s:%s/%s.tar.xz", repoUrl.Platform, repoUrl.Owner, arc.Name)
+	format, err := archiveFormat(destPath)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if format == config.StoreModeXz {
+		return destPath, subject, func() {}, nil
+	}
+	if format == config.StoreModeDelta {
+		mailed, err := isDeltaBaseMailed(store, repoUrl, arc)
+		if err != nil {
+			return "", "", nil, err
+		}
+		if mailed {
+			subject = fmt.Sprintf("%s:%s/%s%s", repoUrl.Platform, repoUrl.Owner, arc.Name, delta.Suffix)
+			return destPath, subject, func() {}, nil
+		}
+	}
+
+	mailPath := filepath.Join(cfg.Paths.Temp, fmt.Sprintf("%s-%s-mail.tar.xz", arc.Name, arc.Commit))
+	err = exportArchive(ctx, cfg, destPath, mailPath)
+	if err != nil {
+		removeTempFiles(mailPath)
+		return "", "", nil, err
+	}
+	return mailPath, subject, func() { removeTempFiles(mailPath) }, nil
+}
@@ -0,0 +1,87 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"gitar/pkg/client"
+	"gitar/pkg/client/common"
+	"gitar/pkg/config"
+	"gitar/pkg/data"
+	"gitar/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// downloadSubmodules 解析归档中的 .gitmodules, 将每个子模块固定的 Commit 下载为独立的归档
+func downloadSubmodules(ctx context.Context, cfg *config.ConfigProperties, store data.DataStore,
+	repoUrl *common.RepoUrl,
+	arc *common.ArchiveInfo, arcPath string, opts DownloadOptions, visited *utils.StringSet) error {
+	content, err := readArchiveEntry(cfg, arcPath, ".gitmodules")
+	if err != nil {
+		return err
+	}
+	if content == nil {
+		logrus.Debugf("No submodules: %s", arc.Name)
+		return nil
+	}
+
+	modules, err := utils.ParseGitModules(content)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Submodules: %d", len(modules))
+
+	for _, module := range modules {
+		logrus.Infof("Submodule: %s (%s)", module.Path, module.Url)
+
+		subUrl, err := resolveSubmoduleUrl(repoUrl, module.Url)
+		if err != nil {
+			return err
+		}
+		subRepoUrl, err := client.ParseRepoUrl(subUrl, cfg)
+		if err != nil {
+			logrus.Warnf("Submodule skipped: %s", err.Error())
+			continue
+		}
+
+		subCommit, err := client.ResolveSubmoduleCommit(ctx, *repoUrl, cfg, arc.Commit, module.Path)
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			logrus.Warnf("Submodule skipped: %s: %s", module.Path, err.Error())
+			continue
+		}
+		subRepoUrl.Commit = subCommit
+
+		_, err = downloadRepoArchive(ctx, cfg, store, subRepoUrl, nil, opts, visited)
+		if err != nil {
+			return fmt.Errorf("submodule %s: %w", module.Path, err)
+		}
+
+		err = store.SaveSubmodule(arc.Commit, module.Path,
+			subRepoUrl.Platform, subRepoUrl.Owner, subRepoUrl.Repo, subCommit)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveSubmoduleUrl 将相对路径形式的子模块地址转为基于父仓库的绝对地址
+func resolveSubmoduleUrl(repoUrl *common.RepoUrl, rawUrl string) (string, error) {
+	if !strings.HasPrefix(rawUrl, "./") && !strings.HasPrefix(rawUrl, "../") {
+		return rawUrl, nil
+	}
+	base, err := url.Parse(fmt.Sprintf("https://%s/%s/%s/", repoUrl.Host, repoUrl.Owner, repoUrl.Repo))
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(ref).String(), nil
+}
@@ -0,0 +1,398 @@
+package app
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gitar/pkg/config"
+	"gitar/pkg/data"
+	"gitar/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// tarEntry 是解压后的 tar 中一个条目的位置, 路径去掉了顶层目录, 根目录为空字符串
+type tarEntry struct {
+	Path    string    `json:"path"`
+	Type    byte      `json:"type"`
+	Mode    int64     `json:"mode"`
+	Size    int64     `json:"size"`
+	Offset  int64     `json:"offset"`
+	Link    string    `json:"link,omitempty"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// tarIndex 是缓存的 tar 的目录, 文件内容可以按偏移直接读取
+type tarIndex struct {
+	Sha256  string     `json:"sha256"`
+	Entries []tarEntry `json:"entries"`
+
+	tarPath string
+	paths   map[string]int
+}
+
+// Lookup 按路径查找条目, 找不到时返回 nil
+func (me *tarIndex) Lookup(name string) *tarEntry {
+	i, found := me.paths[strings.Trim(name, "/")]
+	if !found {
+		return nil
+	}
+	return &me.Entries[i]
+}
+
+// Children 返回目录下的直接子条目, 目录在前
+func (me *tarIndex) Children(dir string) []tarEntry {
+	dir = strings.Trim(dir, "/")
+	var children []tarEntry
+	for _, entry := range me.Entries {
+		if entry.Path == "" {
+			continue
+		}
+		parent := path.Dir(entry.Path)
+		if parent == "." {
+			parent = ""
+		}
+		if parent == dir {
+			children = append(children, entry)
+		}
+	}
+	sort.SliceStable(children, func(i, j int) bool {
+		iDir, jDir := children[i].Type == tar.TypeDir, children[j].Type == tar.TypeDir
+		if iDir != jDir {
+			return iDir
+		}
+		return children[i].Path < children[j].Path
+	})
+	return children
+}
+
+// Open 返回文件内容, 调用方负责关闭
+func (me *tarIndex) Open(entry *tarEntry) (*os.File, io.ReadSeeker, error) {
+	file, err := os.Open(me.tarPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, io.NewSectionReader(file, entry.Offset, entry.Size), nil
+}
+
+func (me *tarIndex) init(tarPath string) {
+	me.tarPath = tarPath
+	me.paths = make(map[string]int, len(me.Entries))
+	for i, entry := range me.Entries {
+		me.paths[entry.Path] = i
+	}
+}
+
+// tarCache 将快照解压到 data/cache/archive 并建立索引, 每个归档只需要解压一次.
+// 缓存总大小超过 serve.cache-size 时删除最久没有访问的归档
+type tarCache struct {
+	cfg     *config.ConfigProperties
+	dir     string
+	maxSize int64
+	mutex   sync.Mutex
+	indexes map[string]*tarIndex
+	// locks 是正在建立索引的归档, 最后一个等待者返回后删除
+	locks map[string]*buildLock
+	// files 是缓存目录中的归档, 首次使用时扫描目录得到
+	files map[string]*cachedTar
+}
+
+type buildLock struct {
+	sync.Mutex
+	waiters int
+}
+
+// cachedTar 记录缓存的归档和索引的大小, 以及最后访问的时间
+type cachedTar struct {
+	size int64
+	used time.Time
+}
+
+func newTarCache(cfg *config.ConfigProperties) *tarCache {
+	return &tarCache{
+		cfg:     cfg,
+		dir:     filepath.Join(cfg.Paths.Data, "cache", "archive"),
+		maxSize: cfg.Serve.MaxCacheSize(),
+		indexes: make(map[string]*tarIndex),
+		locks:   make(map[string]*buildLock),
+	}
+}
+
+// Get 返回快照的索引, 没有缓存时解压并建立索引, 同一个归档同时只建立一次
+func (me *tarCache) Get(snapshot *data.Snapshot) (*tarIndex, error) {
+	if snapshot.Sha256 == "" {
+		return nil, fmt.Errorf("snapshot %s has no checksum", snapshot.Name)
+	}
+	me.mutex.Lock()
+	me.scan()
+	index, found := me.indexes[snapshot.Sha256]
+	if found {
+		me.files[snapshot.Sha256].used = time.Now()
+		me.mutex.Unlock()
+		return index, nil
+	}
+	lock, locked := me.locks[snapshot.Sha256]
+	if !locked {
+		lock = new(buildLock)
+		me.locks[snapshot.Sha256] = lock
+	}
+	lock.waiters++
+	me.mutex.Unlock()
+	defer me.release(snapshot.Sha256, lock)
+
+	lock.Lock()
+	defer lock.Unlock()
+	me.mutex.Lock()
+	index, found = me.indexes[snapshot.Sha256]
+	me.mutex.Unlock()
+	if found {
+		return index, nil
+	}
+
+	tarPath, indexPath := me.paths(snapshot.Sha256)
+	index, err := readTarIndex(indexPath)
+	if err == nil {
+		_, err = os.Stat(tarPath)
+	}
+	if err != nil {
+		logrus.Infof("Index archive: %s", snapshot.Name)
+		index, err = me.build(snapshot, tarPath, indexPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	index.init(tarPath)
+
+	// 更新修改时间, 重启后按修改时间恢复访问顺序
+	now := time.Now()
+	_ = os.Chtimes(tarPath, now, now)
+	size := fileSize(tarPath) + fileSize(indexPath)
+
+	me.mutex.Lock()
+	me.indexes[snapshot.Sha256] = index
+	me.files[snapshot.Sha256] = &cachedTar{size: size, used: now}
+	me.evict(snapshot.Sha256)
+	me.mutex.Unlock()
+	return index, nil
+}
+
+func (me *tarCache) release(digest string, lock *buildLock) {
+	me.mutex.Lock()
+	lock.waiters--
+	if lock.waiters <= 0 {
+		delete(me.locks, digest)
+	}
+	me.mutex.Unlock()
+}
+
+// scan 首次调用时从缓存目录中找出已有的归档, 调用方需要持有 mutex
+func (me *tarCache) scan() {
+	if me.files != nil {
+		return
+	}
+	me.files = make(map[string]*cachedTar)
+	tarPaths, _ := filepath.Glob(filepath.Join(me.dir, "*", "*.tar"))
+	for _, tarPath := range tarPaths {
+		info, err := os.Stat(tarPath)
+		if err != nil {
+			continue
+		}
+		digest := strings.TrimSuffix(filepath.Base(tarPath), ".tar")
+		if len(digest) != sha256.Size*2 {
+			continue
+		}
+		_, indexPath := me.paths(digest)
+		me.files[digest] = &cachedTar{size: info.Size() + fileSize(indexPath), used: info.ModTime()}
+	}
+}
+
+// evict 删除最久没有访问的归档, 直到总大小不超过上限. keep 是刚刚使用的归档, 即使超过上限也保留.
+// 正在读取的文件被删除后仍然可以读完, 调用方需要持有 mutex
+func (me *tarCache) evict(keep string) {
+	total := int64(0)
+	for _, file := range me.files {
+		total += file.size
+	}
+	for total > me.maxSize {
+		oldest := ""
+		for digest, file := range me.files {
+			if digest != keep && (oldest == "" || file.used.Before(me.files[oldest].used)) {
+				oldest = digest
+			}
+		}
+		if oldest == "" {
+			logrus.Warnf("Archive cache %s exceeds serve.cache-size %s",
+				utils.HumanReadableSize(int(total)), utils.HumanReadableSize(int(me.maxSize)))
+			return
+		}
+
+		tarPath, indexPath := me.paths(oldest)
+		logrus.Infof("Evict cached archive: %s", tarPath)
+		removeTempFiles(indexPath, tarPath)
+		total -= me.files[oldest].size
+		delete(me.files, oldest)
+		delete(me.indexes, oldest)
+	}
+}
+
+func (me *tarCache) paths(digest string) (string, string) {
+	dir := filepath.Join(me.dir, digest[:2])
+	return filepath.Join(dir, digest+".tar"), filepath.Join(dir, digest+".index.json.gz")
+}
+
+// build 解压快照到缓存, 同时记录每个条目内容的偏移并校验 SHA-256
+func (me *tarCache) build(snapshot *data.Snapshot, tarPath, indexPath string) (*tarIndex, error) {
+	err := os.MkdirAll(filepath.Dir(tarPath), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := openArchive(me.cfg, snapshot.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer func(reader io.ReadCloser) {
+		_ = reader.Close()
+	}(reader)
+
+	tempFile, err := os.CreateTemp(filepath.Dir(tarPath), ".*"+utils.PartialSuffix)
+	if err != nil {
+		return nil, err
+	}
+	tempPath := tempFile.Name()
+	defer removeTempFiles(tempPath)
+	defer func(tempFile *os.File) {
+		_ = tempFile.Close()
+	}(tempFile)
+
+	// tar.Reader 读完头部时, 已读取的字节数就是内容的偏移
+	hash := sha256.New()
+	counter := &countingReader{r: io.TeeReader(reader, io.MultiWriter(tempFile, hash))}
+	tarReader := tar.NewReader(counter)
+	index := &tarIndex{Sha256: snapshot.Sha256}
+	seen := make(map[string]bool)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		_, name, _ := strings.Cut(strings.TrimSuffix(strings.TrimPrefix(header.Name, "./"), "/"), "/")
+		index.Entries = append(index.Entries, tarEntry{
+			Path:    name,
+			Type:    header.Typeflag,
+			Mode:    header.Mode,
+			Size:    header.Size,
+			Offset:  counter.n,
+			Link:    header.Linkname,
+			ModTime: header.ModTime,
+		})
+		seen[name] = true
+	}
+	_, err = io.Copy(io.Discard, counter)
+	if err != nil {
+		return nil, err
+	}
+	digest := hex.EncodeToString(hash.Sum(nil))
+	if digest != snapshot.Sha256 {
+		return nil, fmt.Errorf("snapshot %s checksum mismatch: expected %s, got %s",
+			snapshot.Name, snapshot.Sha256, digest)
+	}
+
+	// 补上归档中没有单独记录的目录
+	for _, entry := range index.Entries {
+		for dir := path.Dir(entry.Path); dir != "." && dir != "/" && !seen[dir]; dir = path.Dir(dir) {
+			index.Entries = append(index.Entries, tarEntry{Path: dir, Type: tar.TypeDir, Mode: 0755})
+			seen[dir] = true
+		}
+	}
+	if !seen[""] {
+		index.Entries = append(index.Entries, tarEntry{Path: "", Type: tar.TypeDir, Mode: 0755})
+	}
+
+	err = tempFile.Sync()
+	if err != nil {
+		return nil, err
+	}
+	err = os.Rename(tempPath, tarPath)
+	if err != nil {
+		return nil, err
+	}
+	return index, writeTarIndex(indexPath, index)
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (me *countingReader) Read(p []byte) (int, error) {
+	n, err := me.r.Read(p)
+	me.n += int64(n)
+	return n, err
+}
+
+func readTarIndex(indexPath string) (*tarIndex, error) {
+	file, err := os.Open(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	index := new(tarIndex)
+	err = json.NewDecoder(gzipReader).Decode(index)
+	if err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+func writeTarIndex(indexPath string, index *tarIndex) error {
+	file, err := os.CreateTemp(filepath.Dir(indexPath), ".*"+utils.PartialSuffix)
+	if err != nil {
+		return err
+	}
+	tempPath := file.Name()
+	gzipWriter := gzip.NewWriter(file)
+	err = json.NewEncoder(gzipWriter).Encode(index)
+	if err == nil {
+		err = gzipWriter.Close()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		removeTempFiles(tempPath)
+		return err
+	}
+	return os.Rename(tempPath, indexPath)
+}
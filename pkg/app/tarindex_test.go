@@ -0,0 +1,122 @@
+package app
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"testing"
+
+	"gitar/pkg/config"
+	"gitar/pkg/data"
+)
+
+// writeTestSnapshot 生成只有一个文件的 tar.xz 快照
+func writeTestSnapshot(t *testing.T, dir, name, content string) *data.Snapshot {
+	return writeTestTarXz(t, dir, name, []testTarEntry{
+		{tar.Header{Typeflag: tar.TypeDir, Mode: 0755}, ""},
+		{tar.Header{Name: "README.md", Typeflag: tar.TypeReg}, content},
+	})
+}
+
+func readCachedFile(t *testing.T, cache *tarCache, snapshot *data.Snapshot) string {
+	index, err := cache.Get(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := index.Lookup("README.md")
+	if entry == nil {
+		t.Fatalf("README.md not found in %s", snapshot.Name)
+	}
+	file, reader, err := index.Open(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestTarCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	if _, err := exec.LookPath("xz"); err != nil {
+		t.Skip("xz not found")
+	}
+	dir := t.TempDir()
+	cfg := &config.ConfigProperties{Paths: config.PathsProperties{Data: dir}}
+	snapshots := map[string]*data.Snapshot{}
+	for _, name := range []string{"a", "b", "c"} {
+		snapshots[name] = writeTestSnapshot(t, dir, name, "content of "+name)
+	}
+
+	cache := newTarCache(cfg)
+	if readCachedFile(t, cache, snapshots["a"]) != "content of a" {
+		t.Fatal("unexpected content")
+	}
+	// 每个归档的大小相近, 上限只能容纳两个
+	size := cache.files[snapshots["a"].Sha256].size
+	cache.maxSize = size*2 + size/2
+
+	readCachedFile(t, cache, snapshots["b"])
+	readCachedFile(t, cache, snapshots["a"])
+	readCachedFile(t, cache, snapshots["c"])
+
+	cached := func(cache *tarCache, name string) bool {
+		tarPath, _ := cache.paths(snapshots[name].Sha256)
+		_, err := os.Stat(tarPath)
+		return err == nil
+	}
+	if !cached(cache, "a") || cached(cache, "b") || !cached(cache, "c") {
+		t.Errorf("expected b to be evicted, cached: a=%v b=%v c=%v",
+			cached(cache, "a"), cached(cache, "b"), cached(cache, "c"))
+	}
+
+	// 重启后从缓存目录恢复, 再次访问 b 时重新解压并清理其它归档
+	cache = newTarCache(cfg)
+	cache.maxSize = size*2 + size/2
+	if readCachedFile(t, cache, snapshots["b"]) != "content of b" {
+		t.Fatal("unexpected content")
+	}
+	if len(cache.files) != 2 || !cached(cache, "b") {
+		t.Errorf("expected 2 cached archives including b, got %d", len(cache.files))
+	}
+}
+
+func TestTarCacheConcurrentGet(t *testing.T) {
+	if _, err := exec.LookPath("xz"); err != nil {
+		t.Skip("xz not found")
+	}
+	dir := t.TempDir()
+	cfg := &config.ConfigProperties{Paths: config.PathsProperties{Data: dir}}
+	snapshot := writeTestSnapshot(t, dir, "a", "content of a")
+
+	cache := newTarCache(cfg)
+	indexes := make([]*tarIndex, 8)
+	var wg sync.WaitGroup
+	for i := range indexes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			index, err := cache.Get(snapshot)
+			if err != nil {
+				t.Error(err)
+			}
+			indexes[i] = index
+		}(i)
+	}
+	wg.Wait()
+
+	for _, index := range indexes {
+		if index != indexes[0] {
+			t.Fatal("archive should be indexed only once")
+		}
+	}
+	if len(cache.locks) != 0 {
+		t.Errorf("build locks should be released, got %d", len(cache.locks))
+	}
+}
@@ -0,0 +1,71 @@
+package cas
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"os"
+)
+
+const (
+	ManifestVersion = 1
+	ManifestSuffix  = ".manifest.json.gz"
+)
+
+// Manifest 描述如何用对象重建一个 tar, Header 保存 tar 中文件内容之外的原始字节
+type Manifest struct {
+	Version int     `json:"version"`
+	Name    string  `json:"name"`
+	Commit  string  `json:"commit"`
+	Sha256  string  `json:"sha256"`
+	Size    int64   `json:"size"`
+	Entries []Entry `json:"entries"`
+	Trailer []byte  `json:"trailer"`
+}
+
+type Entry struct {
+	Header []byte `json:"header"`
+	Path   string `json:"path"`
+	Blob   string `json:"blob"`
+	Size   int64  `json:"size"`
+}
+
+func WriteManifest(path string, manifest *Manifest) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	gzipWriter := gzip.NewWriter(file)
+	err = json.NewEncoder(gzipWriter).Encode(manifest)
+	if err == nil {
+		err = gzipWriter.Close()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+func ReadManifest(path string) (*Manifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	manifest := new(Manifest)
+	err = json.NewDecoder(gzipReader).Decode(manifest)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
@@ -0,0 +1,238 @@
+package cas
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// Store 按内容的 SHA-256 保存文件, 相同内容只保存一份
+type Store struct {
+	dir string
+}
+
+type IngestStats struct {
+	Blobs    int
+	NewBlobs int
+	NewBytes int64
+}
+
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// recordingReader 记录 tar.Reader 读取的原始字节, 读取文件内容时暂停记录
+type recordingReader struct {
+	r         io.Reader
+	recording bool
+	buf       bytes.Buffer
+}
+
+func (me *recordingReader) Read(p []byte) (int, error) {
+	n, err := me.r.Read(p)
+	if me.recording && n > 0 {
+		me.buf.Write(p[:n])
+	}
+	return n, err
+}
+
+func (me *recordingReader) take() []byte {
+	data := bytes.Clone(me.buf.Bytes())
+	me.buf.Reset()
+	return data
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (me *countingWriter) Write(p []byte) (int, error) {
+	me.n += int64(len(p))
+	return len(p), nil
+}
+
+// Ingest 将 tar 流拆分为对象和清单, 清单加上对象可以还原出逐字节相同的 tar
+func (me *Store) Ingest(r io.Reader) (*Manifest, *IngestStats, error) {
+	hash := sha256.New()
+	counter := &countingWriter{}
+	recorder := &recordingReader{r: io.TeeReader(r, io.MultiWriter(hash, counter)), recording: true}
+	tarReader := tar.NewReader(recorder)
+
+	manifest := &Manifest{Version: ManifestVersion}
+	stats := &IngestStats{}
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		// 其它类型的条目和 pax 扩展头一起留在下一段原始字节中
+		if header.Typeflag != tar.TypeReg || header.Size <= 0 {
+			continue
+		}
+
+		entry := Entry{Header: recorder.take(), Path: header.Name, Size: header.Size}
+		recorder.recording = false
+		blob, created, err := me.putBlob(tarReader)
+		recorder.recording = true
+		if err != nil {
+			return nil, nil, err
+		}
+		entry.Blob = blob
+		manifest.Entries = append(manifest.Entries, entry)
+
+		stats.Blobs++
+		if created {
+			stats.NewBlobs++
+			stats.NewBytes += header.Size
+		}
+	}
+
+	// 结束标记和补齐记录大小的空块
+	_, err := io.Copy(io.Discard, recorder)
+	if err != nil {
+		return nil, nil, err
+	}
+	manifest.Trailer = recorder.take()
+	manifest.Sha256 = hex.EncodeToString(hash.Sum(nil))
+	manifest.Size = counter.n
+	return manifest, stats, nil
+}
+
+func (me *Store) blobPath(blob string) string {
+	return filepath.Join(me.dir, blob[:2], blob+".gz")
+}
+
+// putBlob 先压缩写入临时文件, 得到哈希后再重命名, 已存在时丢弃
+func (me *Store) putBlob(r io.Reader) (string, bool, error) {
+	err := os.MkdirAll(me.dir, os.ModePerm)
+	if err != nil {
+		return "", false, err
+	}
+	temp, err := os.CreateTemp(me.dir, "tmp-*")
+	if err != nil {
+		return "", false, err
+	}
+	defer func(temp *os.File) {
+		_ = temp.Close()
+		_ = os.Remove(temp.Name())
+	}(temp)
+
+	hash := sha256.New()
+	gzipWriter := gzip.NewWriter(temp)
+	_, err = io.Copy(io.MultiWriter(gzipWriter, hash), r)
+	if err != nil {
+		return "", false, err
+	}
+	err = gzipWriter.Close()
+	if err != nil {
+		return "", false, err
+	}
+	blob := hex.EncodeToString(hash.Sum(nil))
+
+	path := me.blobPath(blob)
+	_, err = os.Stat(path)
+	if err == nil {
+		return blob, false, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", false, err
+	}
+
+	err = temp.Sync()
+	if err != nil {
+		return "", false, err
+	}
+	err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return "", false, err
+	}
+	err = os.Rename(temp.Name(), path)
+	if err != nil {
+		return "", false, err
+	}
+	return blob, true, nil
+}
+
+// Export 按清单还原 tar 并写入 w, 对象和整个 tar 的 SHA-256 都会校验
+func (me *Store) Export(manifest *Manifest, w io.Writer) error {
+	hash := sha256.New()
+	writer := io.MultiWriter(w, hash)
+	for _, entry := range manifest.Entries {
+		_, err := writer.Write(entry.Header)
+		if err != nil {
+			return err
+		}
+		err = me.copyBlob(entry, writer)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := writer.Write(manifest.Trailer)
+	if err != nil {
+		return err
+	}
+
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if actual != manifest.Sha256 {
+		return fmt.Errorf("%w: %s expected %s, got %s", ErrChecksumMismatch, manifest.Name, manifest.Sha256, actual)
+	}
+	return nil
+}
+
+// OpenBlob 打开对象的原始内容
+func (me *Store) OpenBlob(blob string) (io.ReadCloser, error) {
+	file, err := os.Open(me.blobPath(blob))
+	if err != nil {
+		return nil, err
+	}
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &blobReader{Reader: gzipReader, file: file}, nil
+}
+
+type blobReader struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (me *blobReader) Close() error {
+	_ = me.Reader.Close()
+	return me.file.Close()
+}
+
+func (me *Store) copyBlob(entry Entry, w io.Writer) error {
+	reader, err := me.OpenBlob(entry.Blob)
+	if err != nil {
+		return err
+	}
+	defer func(reader io.ReadCloser) {
+		_ = reader.Close()
+	}(reader)
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, hash), reader)
+	if err != nil {
+		return err
+	}
+	if n != entry.Size || hex.EncodeToString(hash.Sum(nil)) != entry.Blob {
+		return fmt.Errorf("%w: blob %s of %s", ErrChecksumMismatch, entry.Blob, entry.Path)
+	}
+	return nil
+}
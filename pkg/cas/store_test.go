@@ -0,0 +1,128 @@
+package cas
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// buildTar 生成与 GitHub 归档类似的 tar: pax 全局头, 目录, 长文件名, 空文件, 符号链接和重复内容,
+// 结尾额外补齐空块
+func buildTar(t *testing.T) []byte {
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	longName := "repo-1234567/" + strings.Repeat("deep/", 30) + "file.txt"
+	entries := []struct {
+		header  *tar.Header
+		content string
+	}{
+		{&tar.Header{Typeflag: tar.TypeXGlobalHeader, Name: "pax_global_header",
+			PAXRecords: map[string]string{"comment": "1234567890abcdef1234567890abcdef12345678"}}, ""},
+		{&tar.Header{Typeflag: tar.TypeDir, Name: "repo-1234567/", Mode: 0775}, ""},
+		{&tar.Header{Typeflag: tar.TypeReg, Name: "repo-1234567/README.md", Mode: 0664}, "# repo\n"},
+		{&tar.Header{Typeflag: tar.TypeReg, Name: "repo-1234567/empty", Mode: 0664}, ""},
+		{&tar.Header{Typeflag: tar.TypeSymlink, Name: "repo-1234567/link", Linkname: "README.md", Mode: 0777}, ""},
+		{&tar.Header{Typeflag: tar.TypeReg, Name: longName, Mode: 0664}, strings.Repeat("long\n", 300)},
+		{&tar.Header{Typeflag: tar.TypeReg, Name: "repo-1234567/copy.md", Mode: 0664}, "# repo\n"},
+	}
+	for _, entry := range entries {
+		entry.header.Size = int64(len(entry.content))
+		err := writer.WriteHeader(entry.header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = writer.Write([]byte(entry.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// git archive 按 10 KiB 的记录补齐
+	buf.Write(make([]byte, 20*512))
+	return buf.Bytes()
+}
+
+func ingestTestTar(t *testing.T, store *Store, input []byte) *Manifest {
+	manifest, stats, err := store.Ingest(bytes.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Blobs != 3 || stats.NewBlobs != 2 {
+		t.Errorf("got %d blobs and %d new, expected 3 and 2", stats.Blobs, stats.NewBlobs)
+	}
+	return manifest
+}
+
+func TestIngestExportRoundTrip(t *testing.T) {
+	input := buildTar(t)
+	dir := t.TempDir()
+	store := NewStore(filepath.Join(dir, "objects"))
+	manifest := ingestTestTar(t, store, input)
+
+	// 清单经过序列化后仍能还原
+	manifestPath := filepath.Join(dir, "repo"+ManifestSuffix)
+	err := WriteManifest(manifestPath, manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err = ReadManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var output bytes.Buffer
+	err = store.Export(manifest, &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sha256.Sum256(output.Bytes()) != sha256.Sum256(input) {
+		t.Fatalf("exported tar differs: %d bytes, expected %d", output.Len(), len(input))
+	}
+	if manifest.Size != int64(len(input)) {
+		t.Errorf("manifest size %d, expected %d", manifest.Size, len(input))
+	}
+}
+
+func TestExportMissingBlob(t *testing.T) {
+	input := buildTar(t)
+	store := NewStore(filepath.Join(t.TempDir(), "objects"))
+	manifest := ingestTestTar(t, store, input)
+
+	err := os.Remove(store.blobPath(manifest.Entries[0].Blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Export(manifest, &bytes.Buffer{})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected missing blob error, got %v", err)
+	}
+}
+
+func TestExportCorruptBlob(t *testing.T) {
+	input := buildTar(t)
+	store := NewStore(filepath.Join(t.TempDir(), "objects"))
+	manifest := ingestTestTar(t, store, input)
+
+	// 用另一个对象的内容替换, gzip 本身有效但内容不符
+	entries := manifest.Entries
+	other, err := os.ReadFile(store.blobPath(entries[len(entries)-2].Blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(store.blobPath(entries[0].Blob), other, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Export(manifest, &bytes.Buffer{})
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -18,6 +19,35 @@ type RepoUrl struct {
 	Branch   string
 	Commit   string
 	RefName  string
+	// 引用名称, 后面可能还跟着文件路径, 需要通过 API 确定引用
+	RefPath string
+	// 不为空时取分支在该时间点指向的 Commit
+	Until time.Time
+
+	PullRequest int
+}
+
+type AssetInfo struct {
+	Name        string
+	Size        int
+	ContentType string
+	Url         string
+}
+
+type RepoMeta struct {
+	Description   string
+	Topics        []string
+	License       string
+	Archived      bool
+	DefaultBranch string
+}
+
+type PullRequestInfo struct {
+	Number     int
+	Title      string
+	Author     string
+	State      string
+	SourceRepo string
 }
 
 type ArchiveInfo struct {
@@ -26,18 +56,61 @@ type ArchiveInfo struct {
 	Commit   string
 	TarUrl   string
 	ZipUrl   string
+	Release  string
+	Assets   []AssetInfo
+	Meta     *RepoMeta
+
+	PullRequest *PullRequestInfo
+}
+
+type ResolveOptions struct {
+	Assets        bool
+	TagIgnore     []string
+	TagSeries     string
+	PreferHighest bool
+
+	BranchFallback bool
+}
+
+// WaitError 表示需要等待到指定时间后才能重试, 如 API 限流
+type WaitError struct {
+	Until  time.Time
+	Reason string
+}
+
+func (e *WaitError) Error() string {
+	return fmt.Sprintf("%s, retry at %s", e.Reason, e.Until.Format(time.RFC3339))
 }
 
 type ArchiveResolver interface {
-	ResolveArchive(url RepoUrl) (*ArchiveInfo, error)
+	ResolveArchive(ctx context.Context, url RepoUrl) (*ArchiveInfo, error)
+}
+
+type SubmoduleResolver interface {
+	ResolveSubmoduleCommit(ctx context.Context, url RepoUrl, commit, path string) (string, error)
 }
 
-func ResolveArchiveWithRetry(url RepoUrl, resolver ArchiveResolver, maxAttempts int) (*ArchiveInfo, error) {
+func ResolveArchiveWithRetry(ctx context.Context, url RepoUrl, resolver ArchiveResolver,
+	maxAttempts int) (*ArchiveInfo, error) {
 	for i := 0; i < maxAttempts; i++ {
-		info, err := resolver.ResolveArchive(url)
+		info, err := resolver.ResolveArchive(ctx, url)
 		if err == nil {
 			return info, nil
 		}
+		var waitErr *WaitError
+		if errors.As(err, &waitErr) {
+			delay := time.Until(waitErr.Until) + time.Second
+			logrus.Warnf("%s, waiting %s", waitErr.Reason, delay.Round(time.Second))
+			err = Sleep(ctx, delay)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// 被取消时不再重试, 只重试单次请求的超时
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		if errors.Is(err, context.DeadlineExceeded) {
 			logrus.Warnf("Error while resolving: %s", err.Error())
 			continue
@@ -46,3 +119,15 @@ func ResolveArchiveWithRetry(url RepoUrl, resolver ArchiveResolver, maxAttempts
 	}
 	return nil, fmt.Errorf("Could not resolve archive after %d attempts", maxAttempts)
 }
+
+// Sleep 等待指定时间, ctx 被取消时提前返回
+func Sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
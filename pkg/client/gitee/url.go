@@ -62,5 +62,11 @@ func ParseGiteeRepoUrl(url string) (*common.RepoUrl, error) {
 		return info, nil
 	}
 
+	// HTTPS Pull Request URL, 目前只能通过 GitHub 的接口解析 Pull Request
+	re = regexp.MustCompile(`^https://gitee\.com/([\w\-.]+)/([\w\-.]+)/pulls/(\d+)(?:/(?:files|commits)?)?$`)
+	if re.MatchString(url) {
+		return nil, fmt.Errorf("unsupported Gitee url %s: pull requests are only supported on GitHub", url)
+	}
+
 	return nil, fmt.Errorf("unsupported Gitee url %s", url)
 }
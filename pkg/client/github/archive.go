@@ -0,0 +1,78 @@
+package github
+
+import (
+	"fmt"
+	"strings"
+
+	"gitar/pkg/client/common"
+)
+
+func newBranchArchive(url common.RepoUrl, branchName, commit string) *common.ArchiveInfo {
+	arc := &common.ArchiveInfo{
+		Platform: Platform,
+	}
+
+	// https://{host}/{owner}/{repo}/archive/{commit-sha}.{format}
+	// 使用 Commit ID 保证在下载时和 API 查到的保持一致
+
+	arcUrl := fmt.Sprintf("https://%s/%s/%s/archive/%s", urlHost(url), url.Owner, url.Repo, commit)
+
+	arc.Name = fmt.Sprintf("%s-%s-%s", url.Repo, branchName, shortSha(commit))
+	arc.Name = strings.ReplaceAll(arc.Name, "/", "-")
+	arc.Commit = commit
+	arc.TarUrl = arcUrl + ".tar.gz"
+	arc.ZipUrl = arcUrl + ".zip"
+	return arc
+}
+
+func newCommitArchive(url common.RepoUrl, commit string) *common.ArchiveInfo {
+	arc := &common.ArchiveInfo{
+		Platform: Platform,
+	}
+
+	arcUrl := fmt.Sprintf("https://%s/%s/%s/archive/%s", urlHost(url), url.Owner, url.Repo, commit)
+
+	arc.Name = fmt.Sprintf("%s-%s", url.Repo, shortSha(commit))
+	arc.Commit = commit
+	arc.TarUrl = arcUrl + ".tar.gz"
+	arc.ZipUrl = arcUrl + ".zip"
+	return arc
+}
+
+func newTagArchive(url common.RepoUrl, tagName, commit string) *common.ArchiveInfo {
+	arc := &common.ArchiveInfo{
+		Platform: Platform,
+	}
+
+	// https://{host}/{owner}/{repo}/archive/refs/tags/{tag}.{format}
+	// 这里使用 Archive URL 而不使用 REST API 返回的 URL 可以得到更友好的文件名
+
+	arcUrl := fmt.Sprintf("https://%s/%s/%s/archive/refs/tags/%s", urlHost(url), url.Owner, url.Repo, tagName)
+	arcName := tagName
+	if !strings.HasPrefix(tagName, url.Repo) {
+		arcName = fmt.Sprintf("%s-%s", url.Repo, tagName)
+	}
+
+	arc.Name = arcName
+	arc.Name = strings.ReplaceAll(arc.Name, "/", "-")
+	arc.Commit = commit
+	arc.TarUrl = arcUrl + ".tar.gz"
+	arc.ZipUrl = arcUrl + ".zip"
+	arc.Release = tagName
+	return arc
+}
+
+func urlHost(url common.RepoUrl) string {
+	if url.Host == "" {
+		return Host
+	}
+	return url.Host
+}
+
+// shortSha 返回用于文件名的 7 位 Commit ID
+func shortSha(commit string) string {
+	if len(commit) < 7 {
+		return commit
+	}
+	return commit[:7]
+}
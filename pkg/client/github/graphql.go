@@ -0,0 +1,318 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gitar/pkg/client/common"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	GraphQLEndpoint = "https://api.github.com/graphql"
+
+	graphqlBatchSize = 50
+)
+
+// GraphQLResolver 通过一次带别名的 GraphQL 查询批量解析多个仓库的归档,
+// 无法解析的仓库返回 nil, 由调用方回退到 REST 接口
+type GraphQLResolver struct {
+	client   *http.Client
+	endpoint string
+}
+
+type graphqlRequest struct {
+	Query     string         `json:"query"`
+	Variables map[string]any `json:"variables"`
+}
+
+type graphqlResponse struct {
+	Data   map[string]*graphqlRepository `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+		Path    []any  `json:"path"`
+	} `json:"errors"`
+}
+
+type graphqlObject struct {
+	Typename string         `json:"__typename"`
+	Oid      string         `json:"oid"`
+	Target   *graphqlObject `json:"target"`
+}
+
+type graphqlRef struct {
+	Name   string         `json:"name"`
+	Target *graphqlObject `json:"target"`
+}
+
+type graphqlRepository struct {
+	Description string `json:"description"`
+	IsArchived  bool   `json:"isArchived"`
+	LicenseInfo *struct {
+		SpdxId string `json:"spdxId"`
+	} `json:"licenseInfo"`
+	RepositoryTopics struct {
+		Nodes []struct {
+			Topic struct {
+				Name string `json:"name"`
+			} `json:"topic"`
+		} `json:"nodes"`
+	} `json:"repositoryTopics"`
+	DefaultBranchRef *graphqlRef `json:"defaultBranchRef"`
+	LatestRelease    *struct {
+		TagName      string `json:"tagName"`
+		IsDraft      bool   `json:"isDraft"`
+		IsPrerelease bool   `json:"isPrerelease"`
+		TagCommit    *struct {
+			Oid string `json:"oid"`
+		} `json:"tagCommit"`
+	} `json:"latestRelease"`
+	Releases struct {
+		TotalCount int `json:"totalCount"`
+	} `json:"releases"`
+	// Ref 是查询的分支, TagRef 是查询的 Tag
+	Ref    *graphqlRef `json:"ref"`
+	TagRef *graphqlRef `json:"tagRef"`
+}
+
+const graphqlRepositoryFields = `
+	description
+	isArchived
+	licenseInfo { spdxId }
+	repositoryTopics(first: 20) { nodes { topic { name } } }
+	defaultBranchRef { name target { oid } }
+	latestRelease { tagName isDraft isPrerelease tagCommit { oid } }
+	releases { totalCount }
+`
+
+const graphqlRefFields = `
+	%s: ref(qualifiedName: $%s) {
+		name
+		target {
+			__typename
+			oid
+			... on Tag { target { __typename oid ... on Tag { target { __typename oid } } } }
+		}
+	}
+`
+
+func NewGraphQLResolver(httpClient *http.Client, endpoint string) *GraphQLResolver {
+	if endpoint == "" {
+		endpoint = GraphQLEndpoint
+	}
+	return &GraphQLResolver{
+		client:   httpClient,
+		endpoint: endpoint,
+	}
+}
+
+// EnterpriseGraphQLEndpoint 根据 GitHub Enterprise Server 的 REST 地址得到 GraphQL 地址,
+// 如 https://ghe.example.com/api/v3/ => https://ghe.example.com/api/graphql
+func EnterpriseGraphQLEndpoint(apiUrl string) string {
+	base := strings.TrimSuffix(apiUrl, "/")
+	base = strings.TrimSuffix(base, "/v3")
+	return base + "/graphql"
+}
+
+// ResolveArchives 批量解析, 返回结果与 urls 一一对应
+func (me *GraphQLResolver) ResolveArchives(ctx context.Context, urls []common.RepoUrl) ([]*common.ArchiveInfo, error) {
+	archives := make([]*common.ArchiveInfo, len(urls))
+	for start := 0; start < len(urls); start += graphqlBatchSize {
+		end := start + graphqlBatchSize
+		if end > len(urls) {
+			end = len(urls)
+		}
+		err := me.resolveBatch(ctx, urls[start:end], archives[start:end])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return archives, nil
+}
+
+func (me *GraphQLResolver) resolveBatch(ctx context.Context, urls []common.RepoUrl,
+	archives []*common.ArchiveInfo) error {
+	params := []string{}
+	fields := []string{}
+	variables := map[string]any{}
+
+	for i, url := range urls {
+		owner := fmt.Sprintf("o%d", i)
+		name := fmt.Sprintf("n%d", i)
+		params = append(params, fmt.Sprintf("$%s: String!, $%s: String!", owner, name))
+		variables[owner] = url.Owner
+		variables[name] = url.Repo
+
+		body := graphqlRepositoryFields
+		branchRef, tagRef := qualifiedRefNames(url)
+		if branchRef != "" {
+			qualified := fmt.Sprintf("h%d", i)
+			params = append(params, fmt.Sprintf("$%s: String!", qualified))
+			variables[qualified] = branchRef
+			body += fmt.Sprintf(graphqlRefFields, "ref", qualified)
+		}
+		if tagRef != "" {
+			qualified := fmt.Sprintf("t%d", i)
+			params = append(params, fmt.Sprintf("$%s: String!", qualified))
+			variables[qualified] = tagRef
+			body += fmt.Sprintf(graphqlRefFields, "tagRef", qualified)
+		}
+		fields = append(fields, fmt.Sprintf("r%d: repository(owner: $%s, name: $%s) {%s}", i, owner, name, body))
+	}
+
+	query := fmt.Sprintf("query(%s) {\n%s\n}", strings.Join(params, ", "), strings.Join(fields, "\n"))
+	result, err := me.query(ctx, query, variables)
+	if err != nil {
+		return err
+	}
+	for _, item := range result.Errors {
+		logrus.Debugf("GraphQL: %s %v", item.Message, item.Path)
+	}
+
+	for i, url := range urls {
+		repository := result.Data[fmt.Sprintf("r%d", i)]
+		if repository == nil {
+			continue
+		}
+		arc := toArchive(url, repository)
+		if arc != nil {
+			arc.Meta = repository.toRepoMeta()
+		}
+		archives[i] = arc
+	}
+	return nil
+}
+
+func (me *GraphQLResolver) query(ctx context.Context, query string,
+	variables map[string]any) (*graphqlResponse, error) {
+	body, err := json.Marshal(&graphqlRequest{Query: query, Variables: variables})
+	if err != nil {
+		return nil, err
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, time.Second*30)
+	defer cancel()
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, me.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := me.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("graphql: http %d", resp.StatusCode)
+	}
+
+	result := new(graphqlResponse)
+	err = json.NewDecoder(resp.Body).Decode(result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// qualifiedRefNames 返回需要查询的分支和 Tag, 不确定类型的引用名同时查询两者
+func qualifiedRefNames(url common.RepoUrl) (string, string) {
+	if tagName := urlTagName(url); tagName != "" {
+		return "", "refs/tags/" + tagName
+	}
+	if url.Branch != "" {
+		return "refs/heads/" + url.Branch, ""
+	}
+	if url.RefName != "" {
+		return "refs/heads/" + url.RefName, "refs/tags/" + url.RefName
+	}
+	return "", ""
+}
+
+func urlTagName(url common.RepoUrl) string {
+	if len(url.Tag) > 0 {
+		return url.Tag
+	}
+	return url.Release
+}
+
+func toArchive(url common.RepoUrl, repository *graphqlRepository) *common.ArchiveInfo {
+	if tagName := urlTagName(url); tagName != "" {
+		commit := repository.TagRef.commit()
+		if commit == "" {
+			return nil
+		}
+		return newTagArchive(url, tagName, commit)
+	}
+	if url.Branch != "" {
+		commit := repository.Ref.commit()
+		if commit == "" {
+			return nil
+		}
+		return newBranchArchive(url, url.Branch, commit)
+	}
+	if url.RefName != "" {
+		// 与 REST 接口一致, 同名时优先使用分支
+		if commit := repository.Ref.commit(); commit != "" {
+			return newBranchArchive(url, url.RefName, commit)
+		}
+		if commit := repository.TagRef.commit(); commit != "" {
+			return newTagArchive(url, url.RefName, commit)
+		}
+		return nil
+	}
+	if url.Commit != "" {
+		return newCommitArchive(url, url.Commit)
+	}
+
+	release := repository.LatestRelease
+	if release != nil && !release.IsDraft && !release.IsPrerelease && release.TagCommit != nil {
+		return newTagArchive(url, release.TagName, release.TagCommit.Oid)
+	}
+	// 只有预发布版本时 findBestRelease 会选择预发布版本, 交给 REST 接口按相同的规则解析
+	if release != nil || repository.Releases.TotalCount > 0 {
+		return nil
+	}
+	// 默认分支不存在时由 REST 接口根据 BranchFallback 决定是否猜测分支
+	branch := repository.DefaultBranchRef
+	if branch != nil && branch.Target != nil {
+		return newBranchArchive(url, branch.Name, branch.Target.Oid)
+	}
+	return nil
+}
+
+// commit 返回引用指向的 Commit, 附注标签会被解析, 无法解析时返回空
+func (me *graphqlRef) commit() string {
+	if me == nil {
+		return ""
+	}
+	object := me.Target
+	for object != nil && object.Typename == "Tag" {
+		object = object.Target
+	}
+	if object == nil || object.Typename != "Commit" {
+		return ""
+	}
+	return object.Oid
+}
+
+func (me *graphqlRepository) toRepoMeta() *common.RepoMeta {
+	meta := &common.RepoMeta{
+		Description: me.Description,
+		Archived:    me.IsArchived,
+	}
+	for _, node := range me.RepositoryTopics.Nodes {
+		meta.Topics = append(meta.Topics, node.Topic.Name)
+	}
+	if me.LicenseInfo != nil {
+		meta.License = me.LicenseInfo.SpdxId
+	}
+	if me.DefaultBranchRef != nil {
+		meta.DefaultBranch = me.DefaultBranchRef.Name
+	}
+	return meta
+}
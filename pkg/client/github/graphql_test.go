@@ -0,0 +1,103 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gitar/pkg/client/common"
+)
+
+func TestToArchive(t *testing.T) {
+	url := common.RepoUrl{Platform: Platform, Host: Host, Owner: "owner", Repo: "repo"}
+	withTag := url
+	withTag.Tag = "v1.0"
+	withRefName := url
+	withRefName.RefName = "v1.0"
+
+	cases := []struct {
+		name       string
+		url        common.RepoUrl
+		repository string
+		// expected 为空表示交给 REST 接口解析
+		expected string
+	}{
+		{"latest release", url, `{
+			"defaultBranchRef": {"name": "main", "target": {"oid": "` + commitMain + `"}},
+			"latestRelease": {"tagName": "v1.0", "tagCommit": {"oid": "` + commitV1 + `"}},
+			"releases": {"totalCount": 3}}`, "repo-v1.0"},
+		{"no release", url, `{
+			"defaultBranchRef": {"name": "main", "target": {"oid": "` + commitMain + `"}},
+			"releases": {"totalCount": 0}}`, "repo-main-4444444"},
+		{"prerelease only", url, `{
+			"defaultBranchRef": {"name": "main", "target": {"oid": "` + commitMain + `"}},
+			"releases": {"totalCount": 2}}`, ""},
+		{"release tag missing", url, `{
+			"defaultBranchRef": {"name": "main", "target": {"oid": "` + commitMain + `"}},
+			"latestRelease": {"tagName": "v1.0"},
+			"releases": {"totalCount": 1}}`, ""},
+		{"no default branch", url, `{"releases": {"totalCount": 0}}`, ""},
+		{"annotated tag", withTag, `{
+			"tagRef": {"name": "v1.0", "target": {"__typename": "Tag", "oid": "` + tagObjectV2 + `",
+				"target": {"__typename": "Commit", "oid": "` + commitV1 + `"}}}}`, "repo-v1.0"},
+		{"tag not found", withTag, `{"tagRef": null}`, ""},
+		{"ref name is branch", withRefName, `{
+			"ref": {"name": "v1.0", "target": {"__typename": "Commit", "oid": "` + commitMain + `"}},
+			"tagRef": {"name": "v1.0", "target": {"__typename": "Commit", "oid": "` + commitV1 + `"}}}`,
+			"repo-v1.0-4444444"},
+		{"ref name is tag", withRefName, `{
+			"ref": null,
+			"tagRef": {"name": "v1.0", "target": {"__typename": "Commit", "oid": "` + commitV1 + `"}}}`, "repo-v1.0"},
+		{"ref name not found", withRefName, `{"ref": null, "tagRef": null}`, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			repository := new(graphqlRepository)
+			err := json.Unmarshal([]byte(c.repository), repository)
+			if err != nil {
+				t.Fatal(err)
+			}
+			arc := toArchive(c.url, repository)
+			if c.expected == "" {
+				if arc != nil {
+					t.Errorf("expected fallback to REST, got %s", arc.Name)
+				}
+				return
+			}
+			if arc == nil || arc.Name != c.expected {
+				t.Errorf("got %+v, expected %s", arc, c.expected)
+			}
+		})
+	}
+}
+
+func TestResolveBatchQueriesBranchAndTag(t *testing.T) {
+	var request graphqlRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": {"r0": {
+			"ref": null,
+			"tagRef": {"name": "v1.0", "target": {"__typename": "Commit", "oid": "` + commitV1 + `"}}}}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	resolver := NewGraphQLResolver(server.Client(), server.URL)
+	url := common.RepoUrl{Platform: Platform, Host: Host, Owner: "owner", Repo: "repo", RefName: "v1.0"}
+	archives, err := resolver.ResolveArchives(context.Background(), []common.RepoUrl{url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Variables["h0"] != "refs/heads/v1.0" || request.Variables["t0"] != "refs/tags/v1.0" {
+		t.Errorf("unexpected variables: %v", request.Variables)
+	}
+	if !strings.Contains(request.Query, "tagRef: ref(qualifiedName: $t0)") {
+		t.Errorf("tag ref not queried: %s", request.Query)
+	}
+	if archives[0] == nil || archives[0].Name != "repo-v1.0" || archives[0].Commit != commitV1 {
+		t.Errorf("got %+v", archives[0])
+	}
+}
@@ -0,0 +1,288 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitar/pkg/client/common"
+	"github.com/google/go-github/v56/github"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	headerRateLimit     = "X-RateLimit-Limit"
+	headerRateRemaining = "X-RateLimit-Remaining"
+	headerRateReset     = "X-RateLimit-Reset"
+	headerRateResource  = "X-RateLimit-Resource"
+	headerRetryAfter    = "Retry-After"
+
+	secondaryRateLimitDelay = time.Minute
+)
+
+type tokenState struct {
+	token     string
+	limit     int
+	remaining int
+	reset     time.Time
+}
+
+// TokenPool 是带 Token 轮换的 http.RoundTripper, 某个 Token 的额度用完时切换到下一个,
+// 所有 Token 都用完时返回 common.WaitError 由调用方等待到重置时间
+type TokenPool struct {
+	mu      sync.Mutex
+	tokens  []*tokenState
+	current int
+	base    http.RoundTripper
+}
+
+func NewTokenPool(tokens []string, base http.RoundTripper) *TokenPool {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	pool := &TokenPool{base: base}
+	for _, token := range tokens {
+		if token != "" {
+			pool.tokens = append(pool.tokens, &tokenState{token: token, remaining: -1})
+		}
+	}
+	if len(pool.tokens) <= 0 {
+		// 匿名访问
+		pool.tokens = append(pool.tokens, &tokenState{remaining: -1})
+	}
+	return pool
+}
+
+func (me *TokenPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; attempt < len(me.tokens); attempt++ {
+		state, wait := me.pick()
+		if state == nil {
+			return nil, &common.WaitError{Until: wait, Reason: "github rate limit exceeded"}
+		}
+
+		resp, err := me.base.RoundTrip(withToken(req, state.token))
+		if err != nil {
+			return nil, err
+		}
+		me.update(state, resp)
+
+		if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+			// go-github 会根据响应头在本地拒绝后续请求并返回 RateLimitError, 这里报告整个池的剩余额度.
+			// 所有 Token 都用完时也至少报告 1, 让下一个请求到达 pick 并返回 WaitError
+			if resp.Header.Get(headerRateRemaining) == "0" {
+				resp.Header.Set(headerRateRemaining, strconv.Itoa(max(me.remaining(), 1)))
+			}
+			return resp, nil
+		}
+
+		if retryAfter := resp.Header.Get(headerRetryAfter); retryAfter != "" || isSecondaryRateLimit(resp) {
+			// 次级限流, 换 Token 也没有用
+			closeBody(resp)
+			seconds, _ := strconv.Atoi(retryAfter)
+			delay := time.Duration(seconds) * time.Second
+			if delay <= 0 {
+				delay = secondaryRateLimitDelay
+			}
+			return nil, &common.WaitError{Until: time.Now().Add(delay), Reason: "github secondary rate limit"}
+		}
+		if resp.Header.Get(headerRateRemaining) != "0" {
+			return resp, nil
+		}
+
+		logrus.Warnf("GitHub token exhausted: %s, reset at %s", MaskToken(state.token), state.reset.Format(time.RFC3339))
+		closeBody(resp)
+		if req.Body != nil && req.GetBody == nil {
+			return nil, &common.WaitError{Until: state.reset, Reason: "github rate limit exceeded"}
+		}
+	}
+
+	_, wait := me.pick()
+	return nil, &common.WaitError{Until: wait, Reason: "github rate limit exceeded"}
+}
+
+// pick 选择一个还有额度的 Token, 都没有额度时返回最早的重置时间
+func (me *TokenPool) pick() (*tokenState, time.Time) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	now := time.Now()
+	var earliest time.Time
+	for i := 0; i < len(me.tokens); i++ {
+		index := (me.current + i) % len(me.tokens)
+		state := me.tokens[index]
+		if state.remaining != 0 || now.After(state.reset) {
+			me.current = index
+			return state, time.Time{}
+		}
+		if earliest.IsZero() || state.reset.Before(earliest) {
+			earliest = state.reset
+		}
+	}
+	return nil, earliest
+}
+
+// remaining 返回所有 Token 的剩余额度之和, 未知额度的 Token 按 1 计算
+func (me *TokenPool) remaining() int {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	now := time.Now()
+	total := 0
+	for _, state := range me.tokens {
+		switch {
+		case state.remaining < 0 || state.remaining == 0 && now.After(state.reset):
+			total++
+		default:
+			total += state.remaining
+		}
+	}
+	return total
+}
+
+func (me *TokenPool) update(state *tokenState, resp *http.Response) {
+	remaining, err := strconv.Atoi(resp.Header.Get(headerRateRemaining))
+	if err != nil {
+		return
+	}
+	limit, _ := strconv.Atoi(resp.Header.Get(headerRateLimit))
+	reset, _ := strconv.ParseInt(resp.Header.Get(headerRateReset), 10, 64)
+
+	me.mu.Lock()
+	state.limit = limit
+	state.remaining = remaining
+	state.reset = time.Unix(reset, 0)
+	me.mu.Unlock()
+
+	logrus.Debugf("GitHub rate limit: %d/%d %s (token %s, reset at %s)",
+		remaining, limit, resp.Header.Get(headerRateResource),
+		MaskToken(state.token), state.reset.Format(time.RFC3339))
+}
+
+// isSecondaryRateLimit 判断没有 Retry-After 的 403/429 响应是否为次级限流, 读取后恢复响应体
+func isSecondaryRateLimit(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+	message := strings.ToLower(string(body))
+	return strings.Contains(message, "secondary rate limit") || strings.Contains(message, "abuse detection")
+}
+
+// toWaitError 把 go-github 在本地拒绝请求时返回的限流错误转换为 common.WaitError
+func toWaitError(err error) error {
+	var rateErr *github.RateLimitError
+	if errors.As(err, &rateErr) {
+		return &common.WaitError{Until: rateErr.Rate.Reset.Time, Reason: "github rate limit exceeded"}
+	}
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) {
+		delay := abuseErr.GetRetryAfter()
+		if delay <= 0 {
+			delay = secondaryRateLimitDelay
+		}
+		return &common.WaitError{Until: time.Now().Add(delay), Reason: "github secondary rate limit"}
+	}
+	return err
+}
+
+func withToken(req *http.Request, token string) *http.Request {
+	clone := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err == nil {
+			clone.Body = body
+		}
+	}
+	if token != "" {
+		clone.Header.Set("Authorization", "Bearer "+token)
+	}
+	return clone
+}
+
+func closeBody(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+}
+
+func MaskToken(token string) string {
+	if token == "" {
+		return "anonymous"
+	}
+	if len(token) <= 8 {
+		return "****"
+	}
+	return token[:4] + "****" + token[len(token)-4:]
+}
+
+type RateQuota struct {
+	Resource  string
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+// QueryRateQuotas 通过池的连接查询 Token 的剩余额度, 同时更新池中该 Token 的 core 额度, 该接口本身不消耗额度
+func (me *TokenPool) QueryRateQuotas(ctx context.Context, token, apiUrl string) ([]RateQuota, error) {
+	client := github.NewClient(&http.Client{Transport: me.base})
+	if token != "" {
+		client = client.WithAuthToken(token)
+	}
+	if apiUrl != "" {
+		var err error
+		client, err = client.WithEnterpriseURLs(apiUrl, apiUrl)
+		if err != nil {
+			return nil, err
+		}
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	limits, _, err := client.RateLimits(reqCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	if limits.Core != nil {
+		me.mu.Lock()
+		for _, state := range me.tokens {
+			if state.token == token {
+				state.limit = limits.Core.Limit
+				state.remaining = limits.Core.Remaining
+				state.reset = limits.Core.Reset.Time
+			}
+		}
+		me.mu.Unlock()
+	}
+
+	quotas := []RateQuota{}
+	resources := []struct {
+		name string
+		rate *github.Rate
+	}{
+		{"core", limits.Core},
+		{"search", limits.Search},
+		{"graphql", limits.GraphQL},
+	}
+	for _, resource := range resources {
+		if resource.rate == nil {
+			continue
+		}
+		quotas = append(quotas, RateQuota{
+			Resource:  resource.name,
+			Limit:     resource.rate.Limit,
+			Remaining: resource.rate.Remaining,
+			Reset:     resource.rate.Reset.Time,
+		})
+	}
+	return quotas, nil
+}
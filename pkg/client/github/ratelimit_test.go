@@ -0,0 +1,197 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gitar/pkg/client/common"
+	"github.com/google/go-github/v56/github"
+)
+
+func TestTokenPoolWaitsWhenLastTokenExhausted(t *testing.T) {
+	reset := time.Now().Add(time.Hour).Unix()
+	requests := 0
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set(headerRateLimit, "5000")
+		w.Header().Set(headerRateRemaining, "0")
+		w.Header().Set(headerRateReset, strconv.FormatInt(reset, 10))
+		_, _ = w.Write([]byte(`{"name": "repo"}`))
+	}, "token-1")
+
+	_, _, err := client.Repositories.Get(context.Background(), "owner", "repo")
+	if err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+	_, _, err = client.Repositories.Get(context.Background(), "owner", "repo")
+	var waitErr *common.WaitError
+	if !errors.As(toWaitError(err), &waitErr) {
+		t.Fatalf("expected WaitError, got %v", err)
+	}
+	if waitErr.Until.Unix() != reset {
+		t.Errorf("wait until %v, expected %v", waitErr.Until.Unix(), reset)
+	}
+	if requests != 1 {
+		t.Errorf("expected 1 request to server, got %d", requests)
+	}
+}
+
+func TestTokenPoolRotatesTokens(t *testing.T) {
+	reset := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	var seen []string
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("Authorization")
+		seen = append(seen, token)
+		w.Header().Set(headerRateReset, reset)
+		if token == "Bearer token-1" {
+			w.Header().Set(headerRateRemaining, "0")
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"message": "API rate limit exceeded"}`))
+			return
+		}
+		w.Header().Set(headerRateRemaining, "10")
+		_, _ = w.Write([]byte(`{"name": "repo"}`))
+	}, "token-1", "token-2")
+
+	_, _, err := client.Repositories.Get(context.Background(), "owner", "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 2 || seen[1] != "Bearer token-2" {
+		t.Errorf("unexpected tokens: %v", seen)
+	}
+}
+
+func TestTokenPoolSecondaryRateLimit(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{"403 with message", http.StatusForbidden,
+			`{"message": "You have exceeded a secondary rate limit. Please wait a few minutes before you try again."}`},
+		{"429", http.StatusTooManyRequests, `{"message": "slow down"}`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set(headerRateRemaining, "4000")
+				w.WriteHeader(c.status)
+				_, _ = w.Write([]byte(c.body))
+			}, "token-1", "token-2")
+
+			start := time.Now()
+			_, _, err := client.Repositories.Get(context.Background(), "owner", "repo")
+			var waitErr *common.WaitError
+			if !errors.As(err, &waitErr) {
+				t.Fatalf("expected WaitError, got %v", err)
+			}
+			if waitErr.Until.Before(start.Add(secondaryRateLimitDelay)) {
+				t.Errorf("wait until %v, expected at least %v", waitErr.Until, start.Add(secondaryRateLimitDelay))
+			}
+		})
+	}
+}
+
+func TestTokenPoolPlainForbidden(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(headerRateRemaining, "4000")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"message": "Resource not accessible by integration"}`))
+	}, "token-1")
+
+	_, _, err := client.Repositories.Get(context.Background(), "owner", "repo")
+	var waitErr *common.WaitError
+	var responseErr *github.ErrorResponse
+	if errors.As(err, &waitErr) || !errors.As(err, &responseErr) {
+		t.Fatalf("expected ErrorResponse, got %v", err)
+	}
+	if responseErr.Message != "Resource not accessible by integration" {
+		t.Errorf("body was not restored: %q", responseErr.Message)
+	}
+}
+
+// redirectTransport 把请求转发到测试服务器
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (me redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = me.target.Scheme
+	req.URL.Host = me.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestTokenPoolSharedBetweenClients(t *testing.T) {
+	reset := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	var seen []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("Authorization")
+		seen = append(seen, token)
+		w.Header().Set(headerRateReset, reset)
+		if token == "Bearer token-1" {
+			w.Header().Set(headerRateRemaining, "0")
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"message": "API rate limit exceeded"}`))
+			return
+		}
+		w.Header().Set(headerRateRemaining, "10")
+		_, _ = w.Write([]byte(`{"name": "repo"}`))
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+	pool := NewTokenPool([]string{"token-1", "token-2"}, redirectTransport{target})
+
+	for i := 0; i < 2; i++ {
+		service := NewGitHubService(&http.Client{Transport: pool}, common.ResolveOptions{})
+		_, _, err := service.client.Repositories.Get(context.Background(), "owner", "repo")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 第二个客户端直接使用 token-2, 不再先试用已经耗尽的 token-1
+	expected := []string{"Bearer token-1", "Bearer token-2", "Bearer token-2"}
+	if strings.Join(seen, ",") != strings.Join(expected, ",") {
+		t.Errorf("tokens: %v, expected %v", seen, expected)
+	}
+}
+
+func TestTokenPoolQueryRateQuotas(t *testing.T) {
+	reset := time.Now().Add(time.Hour).Unix()
+	var seen []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("Authorization")
+		seen = append(seen, r.URL.Path+" "+token)
+		remaining := 100
+		if token == "Bearer token-1" {
+			remaining = 0
+		}
+		_, _ = fmt.Fprintf(w, `{"resources": {"core": {"limit": 5000, "remaining": %d, "reset": %d},
+			"search": {"limit": 30, "remaining": 30, "reset": %d}}}`, remaining, reset, reset)
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+	pool := NewTokenPool([]string{"token-1", "token-2"}, redirectTransport{target})
+
+	quotas, err := pool.QueryRateQuotas(context.Background(), "token-1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quotas) != 2 || quotas[0].Resource != "core" || quotas[0].Remaining != 0 || quotas[1].Resource != "search" {
+		t.Errorf("unexpected quotas: %+v", quotas)
+	}
+	// 查询到的额度记录在池中, 之后的请求跳过已经耗尽的 token-1
+	state, _ := pool.pick()
+	if state == nil || state.token != "token-2" {
+		t.Errorf("expected token-2 to be picked, got %+v", state)
+	}
+}
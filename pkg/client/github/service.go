@@ -2,8 +2,11 @@ package github
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"strings"
 	"time"
 
@@ -14,19 +17,54 @@ import (
 
 type GitHubService struct {
 	client *github.Client
+	opts   common.ResolveOptions
 }
 
-func NewGitHubService(token string) *GitHubService {
-	client := github.NewClient(nil)
-	if token != "" {
-		client = client.WithAuthToken(token)
+func NewGitHubService(httpClient *http.Client, opts common.ResolveOptions) *GitHubService {
+	client := github.NewClient(httpClient)
+	return &GitHubService{
+		client: client,
+		opts:   opts,
+	}
+}
+
+// NewEnterpriseGitHubService 创建使用 GitHub Enterprise Server 接口的服务
+func NewEnterpriseGitHubService(httpClient *http.Client, apiUrl, uploadUrl string,
+	opts common.ResolveOptions) (*GitHubService, error) {
+	client, err := github.NewClient(httpClient).WithEnterpriseURLs(apiUrl, uploadUrl)
+	if err != nil {
+		return nil, err
 	}
 	return &GitHubService{
 		client: client,
+		opts:   opts,
+	}, nil
+}
+
+func (me *GitHubService) ResolveArchive(ctx context.Context, url common.RepoUrl) (*common.ArchiveInfo, error) {
+	arc, err := me.resolveArchive(ctx, url)
+	if err != nil {
+		return nil, toWaitError(err)
 	}
+	return arc, nil
 }
 
-func (me *GitHubService) ResolveArchive(url common.RepoUrl) (*common.ArchiveInfo, error) {
+// resolveArchive 只有需要默认分支时才查询仓库信息, 此时同时返回仓库的元数据
+func (me *GitHubService) resolveArchive(ctx context.Context, url common.RepoUrl) (*common.ArchiveInfo, error) {
+	if len(url.RefPath) > 0 {
+		resolved, err := me.resolveRefPath(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+		url = *resolved
+	} else if len(url.RefName) > 0 && len(url.Branch) <= 0 && len(url.Tag) <= 0 && len(url.Release) <= 0 {
+		resolved, err := me.resolveRefName(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+		url = *resolved
+	}
+
 	tagName := ""
 	if len(url.Release) > 0 {
 		tagName = url.Release
@@ -35,76 +73,207 @@ func (me *GitHubService) ResolveArchive(url common.RepoUrl) (*common.ArchiveInfo
 		tagName = url.Tag
 	}
 
+	if !url.Until.IsZero() {
+		if url.PullRequest > 0 || len(tagName) > 0 || len(url.Commit) > 0 {
+			return nil, errors.New("snapshot by date only works with branches")
+		}
+		return me.resolveArchiveUntil(ctx, url)
+	}
+	if url.PullRequest > 0 {
+		return me.resolveArchiveByPullRequest(ctx, url)
+	}
 	if len(tagName) > 0 {
-		return me.resolveArchiveByTag(url, tagName)
+		return me.resolveArchiveByTag(ctx, url, tagName)
 	}
 	if len(url.Branch) > 0 {
-		return me.resolveArchiveByBranch(url)
+		return me.resolveArchiveByBranch(ctx, url)
 	}
 	if len(url.Commit) > 0 {
-		return me.resolveArchiveByCommit(url)
+		return me.resolveArchiveByCommit(ctx, url)
 	}
 
-	release, err := me.findBestRelease(url.Owner, url.Repo)
+	release, err := me.findBestRelease(ctx, url.Owner, url.Repo)
 	if err != nil {
 		return nil, err
 	}
 	if release != nil {
-		return me.resolveArchiveByTag(url, *release.TagName)
+		return me.resolveArchiveByTag(ctx, url, *release.TagName)
+	}
+
+	if me.opts.PreferHighest || me.opts.TagSeries != "" {
+		tag, err := me.findBestTag(ctx, url.Owner, url.Repo)
+		if err != nil {
+			return nil, err
+		}
+		if tag != nil {
+			return me.resolveArchiveByTag(ctx, url, *tag.Name)
+		}
 	}
 
-	branch, err := me.findBestBranch(url.Owner, url.Repo)
+	repository, err := me.getRepository(ctx, url.Owner, url.Repo)
+	if err != nil {
+		return nil, err
+	}
+	branch, err := me.findBestBranch(ctx, url.Owner, url.Repo, repository.GetDefaultBranch())
 	if err != nil {
 		return nil, err
 	}
 	if branch != nil {
-		return me.branchToArchive(url, branch)
+		arc, err := me.branchToArchive(url, branch)
+		if err != nil {
+			return nil, err
+		}
+		arc.Meta = toRepoMeta(repository)
+		return arc, nil
 	}
 
 	return nil, errors.New("could not resolve archive")
 }
 
-func (me *GitHubService) branchToArchive(url common.RepoUrl, branch *github.Branch) (*common.ArchiveInfo, error) {
-	arc := &common.ArchiveInfo{
-		Platform: Platform,
+// resolveRefPath 从长到短尝试 RefPath 的前缀, 找到第一个存在的分支或 Tag
+func (me *GitHubService) resolveRefPath(ctx context.Context, url common.RepoUrl) (*common.RepoUrl, error) {
+	parts := strings.Split(url.RefPath, "/")
+	for i := len(parts); i > 0; i-- {
+		resolved, err := me.findRef(ctx, url, strings.Join(parts[:i], "/"))
+		if err != nil || resolved != nil {
+			return resolved, err
+		}
 	}
+	return nil, fmt.Errorf("no matched ref: %s", url.RefPath)
+}
 
-	// https://github.com/{owner}/{repo}/archive/{commit-sha}.{format}
-	// 使用 Commit ID 保证在下载时和 API 查到的保持一致
-
-	commit := *branch.Commit.SHA
-	arcUrl := fmt.Sprintf("https://github.com/%s/%s/archive/%s", url.Owner, url.Repo, commit)
+// resolveRefName 确定没有 / 的引用名是分支还是 Tag, 同名时优先使用分支
+func (me *GitHubService) resolveRefName(ctx context.Context, url common.RepoUrl) (*common.RepoUrl, error) {
+	resolved, err := me.findRef(ctx, url, url.RefName)
+	if err != nil || resolved != nil {
+		return resolved, err
+	}
+	if shortCommitPattern.MatchString(url.RefName) {
+		url.Commit = url.RefName
+		url.RefName = ""
+		return &url, nil
+	}
+	return nil, fmt.Errorf("no matched ref: %s", url.RefName)
+}
 
-	arc.Name = fmt.Sprintf("%s-%s-%s", url.Repo, *branch.Name, commit[:7])
-	arc.Name = strings.ReplaceAll(arc.Name, "/", "-")
-	arc.Commit = commit
-	arc.TarUrl = arcUrl + ".tar.gz"
-	arc.ZipUrl = arcUrl + ".zip"
+// findRef 依次查找名为 name 的分支和 Tag, 都不存在时返回 nil
+func (me *GitHubService) findRef(ctx context.Context, url common.RepoUrl, name string) (*common.RepoUrl, error) {
+	branch, err := me.findBranch(ctx, url.Owner, url.Repo, name)
+	if err != nil {
+		return nil, err
+	}
+	if branch != nil {
+		url.Branch = name
+		url.RefName = name
+		url.RefPath = ""
+		return &url, nil
+	}
 
-	return validateArchive(arc)
+	tag, err := me.findTag(ctx, url.Owner, url.Repo, name)
+	if err != nil {
+		return nil, err
+	}
+	if tag != nil {
+		url.Tag = name
+		url.RefName = name
+		url.RefPath = ""
+		return &url, nil
+	}
+	return nil, nil
 }
 
-func (me *GitHubService) resolveArchiveByCommit(url common.RepoUrl) (*common.ArchiveInfo, error) {
-	arc := &common.ArchiveInfo{
-		Platform: Platform,
+// ResolveSubmoduleCommit 从父仓库指定 Commit 的树中查找子模块固定的 Commit
+func (me *GitHubService) ResolveSubmoduleCommit(ctx context.Context, url common.RepoUrl, commit,
+	path string) (string, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	opts := &github.RepositoryContentGetOptions{Ref: commit}
+	content, _, _, err := me.client.Repositories.GetContents(reqCtx, url.Owner, url.Repo, path, opts)
+	if err != nil {
+		return "", err
 	}
+	if content == nil || content.GetType() != "submodule" {
+		return "", fmt.Errorf("not a submodule: %s", path)
+	}
+	return content.GetSHA(), nil
+}
 
-	arcUrl := fmt.Sprintf("https://github.com/%s/%s/archive/%s", url.Owner, url.Repo, url.Commit)
+func (me *GitHubService) branchToArchive(url common.RepoUrl, branch *github.Branch) (*common.ArchiveInfo, error) {
+	return validateArchive(newBranchArchive(url, *branch.Name, *branch.Commit.SHA))
+}
 
-	arc.Name = fmt.Sprintf("%s-%s", url.Repo, url.Commit[:7])
-	arc.Commit = url.Commit
-	arc.TarUrl = arcUrl + ".tar.gz"
-	arc.ZipUrl = arcUrl + ".zip"
+func (me *GitHubService) resolveArchiveByCommit(ctx context.Context, url common.RepoUrl) (*common.ArchiveInfo, error) {
+	commit := url.Commit
+	if IsShortCommit(commit) {
+		expanded, err := me.expandCommit(ctx, url.Owner, url.Repo, commit)
+		if err != nil {
+			return nil, err
+		}
+		commit = expanded
+	}
+	return validateArchive(newCommitArchive(url, commit))
+}
 
-	return validateArchive(arc)
+// expandCommit 将缩写的 Commit ID 补全, 有歧义或不存在时 GitHub 返回 422 或 404
+func (me *GitHubService) expandCommit(ctx context.Context, owner, repo, commit string) (string, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	found, resp, err := me.client.Repositories.GetCommit(reqCtx, owner, repo, commit, &github.ListOptions{PerPage: 1})
+	if err != nil {
+		if resp != nil && (resp.StatusCode == http.StatusUnprocessableEntity || resp.StatusCode == http.StatusNotFound) {
+			return "", fmt.Errorf("short commit %s is ambiguous or unknown, use a longer one", commit)
+		}
+		return "", err
+	}
+	sha := found.GetSHA()
+	if !strings.HasPrefix(strings.ToLower(sha), strings.ToLower(commit)) {
+		return "", fmt.Errorf("short commit %s resolved to unexpected commit %s", commit, sha)
+	}
+	return sha, nil
 }
 
-func (me *GitHubService) resolveArchiveByTag(url common.RepoUrl, tagName string) (*common.ArchiveInfo, error) {
-	arc := &common.ArchiveInfo{
-		Platform: Platform,
+// resolveArchiveUntil 取分支在 url.Until 时刻指向的 Commit, 未指定分支时使用默认分支
+func (me *GitHubService) resolveArchiveUntil(ctx context.Context, url common.RepoUrl) (*common.ArchiveInfo, error) {
+	branchName := url.Branch
+	var repository *github.Repository
+	if branchName == "" {
+		var err error
+		repository, err = me.getRepository(ctx, url.Owner, url.Repo)
+		if err != nil {
+			return nil, err
+		}
+		branchName = repository.GetDefaultBranch()
+	}
+	if branchName == "" {
+		return nil, errors.New("no default branch")
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	commits, _, err := me.client.Repositories.ListCommits(reqCtx, url.Owner, url.Repo, &github.CommitsListOptions{
+		SHA:         branchName,
+		Until:       url.Until,
+		ListOptions: github.ListOptions{PerPage: 1},
+	})
+	if err != nil {
+		return nil, err
 	}
+	if len(commits) <= 0 {
+		return nil, fmt.Errorf("no commit on %s before %s", branchName, url.Until.Format(time.DateOnly))
+	}
+	arc, err := validateArchive(newBranchArchive(url, branchName, commits[0].GetSHA()))
+	if err != nil {
+		return nil, err
+	}
+	if repository != nil {
+		arc.Meta = toRepoMeta(repository)
+	}
+	return arc, nil
+}
 
-	tag, err := me.findTag(url.Owner, url.Repo, tagName)
+func (me *GitHubService) resolveArchiveByTag(ctx context.Context, url common.RepoUrl,
+	tagName string) (*common.ArchiveInfo, error) {
+	tag, err := me.findTag(ctx, url.Owner, url.Repo, tagName)
 	if err != nil {
 		return nil, err
 	}
@@ -112,63 +281,156 @@ func (me *GitHubService) resolveArchiveByTag(url common.RepoUrl, tagName string)
 		return nil, errors.New("no matched tag")
 	}
 
-	// https://github.com/{owner}/{repo}/archive/refs/tags/{tag}.{format}
-	// 这里使用 Archive URL 而不使用 REST API 返回的 URL 可以得到更友好的文件名
+	arc := newTagArchive(url, tagName, *tag.Commit.SHA)
 
-	arcUrl := fmt.Sprintf("https://github.com/%s/%s/archive/refs/tags/%s", url.Owner, url.Repo, tagName)
-	arcName := tagName
-	if !strings.HasPrefix(tagName, url.Repo) {
-		arcName = fmt.Sprintf("%s-%s", url.Repo, tagName)
+	if me.opts.Assets {
+		assets, err := me.findReleaseAssets(ctx, url.Owner, url.Repo, tagName)
+		if err != nil {
+			return nil, err
+		}
+		arc.Assets = assets
 	}
 
-	arc.Name = arcName
-	arc.Name = strings.ReplaceAll(arc.Name, "/", "-")
-	arc.Commit = *tag.Commit.SHA
-	arc.TarUrl = arcUrl + ".tar.gz"
-	arc.ZipUrl = arcUrl + ".zip"
+	return validateArchive(arc)
+}
+
+func (me *GitHubService) findReleaseAssets(ctx context.Context, owner, repo,
+	tagName string) ([]common.AssetInfo, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	release, resp, err := me.client.Repositories.GetReleaseByTag(reqCtx, owner, repo, tagName)
+	if err != nil {
+		// 只有 Tag 没有 Release 时没有附件
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
 
+	assets := []common.AssetInfo{}
+	for _, item := range release.Assets {
+		assets = append(assets, common.AssetInfo{
+			Name:        item.GetName(),
+			Size:        item.GetSize(),
+			ContentType: item.GetContentType(),
+			Url:         item.GetBrowserDownloadURL(),
+		})
+	}
+	return assets, nil
+}
+
+// resolveArchiveByPullRequest 下载 Pull Request 当前的 head, 即使来源仓库已删除也可以从目标仓库获取
+func (me *GitHubService) resolveArchiveByPullRequest(ctx context.Context,
+	url common.RepoUrl) (*common.ArchiveInfo, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	pr, _, err := me.client.PullRequests.Get(reqCtx, url.Owner, url.Repo, url.PullRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	head := pr.GetHead()
+	commit := head.GetSHA()
+	if len(commit) < 7 {
+		return nil, errors.New("no head commit found")
+	}
+
+	arc := newCommitArchive(url, commit)
+	arc.Name = fmt.Sprintf("%s-pr%d-%s", url.Repo, url.PullRequest, shortSha(commit))
+
+	state := pr.GetState()
+	if pr.GetMerged() {
+		state = "merged"
+	}
+	arc.PullRequest = &common.PullRequestInfo{
+		Number:     url.PullRequest,
+		Title:      pr.GetTitle(),
+		Author:     pr.GetUser().GetLogin(),
+		State:      state,
+		SourceRepo: head.GetRepo().GetFullName(),
+	}
 	return validateArchive(arc)
 }
 
-func (me *GitHubService) resolveArchiveByBranch(url common.RepoUrl) (*common.ArchiveInfo, error) {
-	arc := &common.ArchiveInfo{
-		Platform: Platform,
+// DownloadPatch 通过接口下载 Pull Request 的 patch, 私有仓库和 GitHub Enterprise Server 也需要认证
+func (me *GitHubService) DownloadPatch(ctx context.Context, url common.RepoUrl, w io.Writer) error {
+	reqCtx, cancel := context.WithTimeout(ctx, time.Minute*5)
+	defer cancel()
+	path := fmt.Sprintf("repos/%s/%s/pulls/%d", url.Owner, url.Repo, url.PullRequest)
+	req, err := me.client.NewRequest(http.MethodGet, path, nil)
+	if err != nil {
+		return err
 	}
+	req.Header.Set("Accept", "application/vnd.github.patch")
+	_, err = me.client.Do(reqCtx, req, w)
+	return err
+}
 
-	branch, err := me.findBranch(url.Owner, url.Repo, url.Branch)
+func (me *GitHubService) resolveArchiveByBranch(ctx context.Context, url common.RepoUrl) (*common.ArchiveInfo, error) {
+	branch, err := me.findBranch(ctx, url.Owner, url.Repo, url.Branch)
 	if err != nil {
 		return nil, err
 	}
 	if branch == nil {
 		return nil, errors.New("no matched branch")
 	}
+	return me.branchToArchive(url, branch)
+}
 
-	// https://github.com/{owner}/{repo}/archive/{commit-sha}.{format}
-	// 使用 Commit ID 保证在下载时和 API 查到的保持一致
-
-	commit := *branch.Commit.SHA
-	arcUrl := fmt.Sprintf("https://github.com/%s/%s/archive/%s", url.Owner, url.Repo, commit)
+func (me *GitHubService) getRepository(ctx context.Context, owner, repo string) (*github.Repository, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	repository, _, err := me.client.Repositories.Get(reqCtx, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	return repository, nil
+}
 
-	arc.Name = fmt.Sprintf("%s-%s-%s", url.Repo, *branch.Name, commit[:7])
-	arc.Name = strings.ReplaceAll(arc.Name, "/", "-")
-	arc.Commit = commit
-	arc.TarUrl = arcUrl + ".tar.gz"
-	arc.ZipUrl = arcUrl + ".zip"
+func toRepoMeta(repository *github.Repository) *common.RepoMeta {
+	meta := &common.RepoMeta{
+		Description:   repository.GetDescription(),
+		Topics:        repository.Topics,
+		Archived:      repository.GetArchived(),
+		DefaultBranch: repository.GetDefaultBranch(),
+	}
+	if repository.License != nil {
+		meta.License = repository.License.GetSPDXID()
+	}
+	return meta
+}
 
-	return validateArchive(arc)
+// findBestBranch 优先使用仓库的默认分支, 找不到时按配置决定是否使用常见分支名猜测
+func (me *GitHubService) findBestBranch(ctx context.Context, owner, repo,
+	defaultBranch string) (*github.Branch, error) {
+	if defaultBranch != "" {
+		reqCtx, cancel := context.WithTimeout(ctx, time.Second*10)
+		branch, resp, err := me.client.Repositories.GetBranch(reqCtx, owner, repo, defaultBranch, 1)
+		cancel()
+		if err == nil {
+			return branch, nil
+		}
+		if resp == nil || resp.StatusCode != http.StatusNotFound {
+			return nil, err
+		}
+	}
+	if !me.opts.BranchFallback {
+		return nil, nil
+	}
+	return me.guessBestBranch(ctx, owner, repo)
 }
 
-func (me *GitHubService) findBestBranch(owner, repo string) (*github.Branch, error) {
+func (me *GitHubService) guessBestBranch(ctx context.Context, owner, repo string) (*github.Branch, error) {
 	desired := utils.NewStringSet([]string{"master", "main", "trunk", "release", "develop"})
 	branches := []*github.Branch{}
 
 	for page := 1; page < 100; page++ {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-		defer cancel()
+		reqCtx, cancel := context.WithTimeout(ctx, time.Second*10)
 		opts := &github.BranchListOptions{
 			ListOptions: github.ListOptions{Page: page, PerPage: 100},
 		}
-		items, _, err := me.client.Repositories.ListBranches(ctx, owner, repo, opts)
+		items, _, err := me.client.Repositories.ListBranches(reqCtx, owner, repo, opts)
+		cancel()
 		if err != nil {
 			return nil, err
 		}
@@ -199,25 +461,22 @@ func (me *GitHubService) findBestBranch(owner, repo string) (*github.Branch, err
 	return branches[0], nil
 }
 
-func (me *GitHubService) findAnyWellKnownBranch(owner, repo string) (*github.Branch, error) {
+func (me *GitHubService) findAnyWellKnownBranch(ctx context.Context, owner, repo string) (*github.Branch, error) {
 	desired := utils.NewStringSet([]string{"master", "main", "trunk", "release", "develop"})
 
 	for page := 1; page < 100; page++ {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-		defer cancel()
+		reqCtx, cancel := context.WithTimeout(ctx, time.Second*10)
 		opts := &github.BranchListOptions{
 			ListOptions: github.ListOptions{Page: page, PerPage: 100},
 		}
-		branches, _, err := me.client.Repositories.ListBranches(ctx, owner, repo, opts)
+		branches, _, err := me.client.Repositories.ListBranches(reqCtx, owner, repo, opts)
+		cancel()
 		if err != nil {
 			return nil, err
 		}
 
-		if err != nil {
-			return nil, err
-		}
 		if branches == nil || len(branches) <= 0 {
-			return nil, errors.New("no branch fetched")
+			break
 		}
 		for _, branch := range branches {
 			if desired.Contains(*branch.Name) {
@@ -229,17 +488,21 @@ func (me *GitHubService) findAnyWellKnownBranch(owner, repo string) (*github.Bra
 	return nil, errors.New("no matched branch")
 }
 
-func (me *GitHubService) findBestRelease(owner, repo string) (*github.RepositoryRelease, error) {
+func (me *GitHubService) findBestRelease(ctx context.Context, owner, repo string) (*github.RepositoryRelease, error) {
+	filter, err := newTagFilter(me.opts)
+	if err != nil {
+		return nil, err
+	}
 	releases := []*github.RepositoryRelease{}
 
 	for page := 1; page < 100; page++ {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-		defer cancel()
+		reqCtx, cancel := context.WithTimeout(ctx, time.Second*10)
 		opts := &github.ListOptions{
 			Page:    page,
 			PerPage: 100,
 		}
-		items, _, err := me.client.Repositories.ListReleases(ctx, owner, repo, opts)
+		items, _, err := me.client.Repositories.ListReleases(reqCtx, owner, repo, opts)
+		cancel()
 		if err != nil {
 			return nil, err
 		}
@@ -248,7 +511,10 @@ func (me *GitHubService) findBestRelease(owner, repo string) (*github.Repository
 		}
 
 		for _, item := range items {
-			if !*item.Draft && !*item.Prerelease {
+			if !filter.Accept(item.GetTagName()) {
+				continue
+			}
+			if !me.opts.PreferHighest && !*item.Draft && !*item.Prerelease {
 				return item, nil
 			}
 			releases = append(releases, item)
@@ -259,6 +525,23 @@ func (me *GitHubService) findBestRelease(owner, repo string) (*github.Repository
 		return nil, nil
 	}
 
+	if me.opts.PreferHighest {
+		stable := []*github.RepositoryRelease{}
+		names := []string{}
+		for _, release := range releases {
+			if !*release.Draft && !*release.Prerelease {
+				stable = append(stable, release)
+				names = append(names, release.GetTagName())
+			}
+		}
+		if i := highestVersion(names); i >= 0 {
+			return stable[i], nil
+		}
+		if len(stable) > 0 {
+			return stable[0], nil
+		}
+	}
+
 	for _, release := range releases {
 		if !*release.Prerelease {
 			return release, nil
@@ -268,10 +551,10 @@ func (me *GitHubService) findBestRelease(owner, repo string) (*github.Repository
 	return releases[0], nil
 }
 
-func (me *GitHubService) findAnyRelease(owner, repo string) (*github.RepositoryRelease, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+func (me *GitHubService) findAnyRelease(ctx context.Context, owner, repo string) (*github.RepositoryRelease, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
-	releases, _, err := me.client.Repositories.ListReleases(ctx, owner, repo, nil)
+	releases, _, err := me.client.Repositories.ListReleases(reqCtx, owner, repo, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -281,68 +564,124 @@ func (me *GitHubService) findAnyRelease(owner, repo string) (*github.RepositoryR
 	return releases[0], nil
 }
 
-func (me *GitHubService) findBestTag(owner, repo string) (*github.RepositoryTag, error) {
+func (me *GitHubService) findBestTag(ctx context.Context, owner, repo string) (*github.RepositoryTag, error) {
+	filter, err := newTagFilter(me.opts)
+	if err != nil {
+		return nil, err
+	}
+	tags := []*github.RepositoryTag{}
+	names := []string{}
+
 	for page := 1; page < 100; page++ {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-		defer cancel()
+		reqCtx, cancel := context.WithTimeout(ctx, time.Second*10)
 		opts := &github.ListOptions{Page: page, PerPage: 100}
-		tags, _, err := me.client.Repositories.ListTags(ctx, owner, repo, opts)
+		items, _, err := me.client.Repositories.ListTags(reqCtx, owner, repo, opts)
+		cancel()
 		if err != nil {
 			return nil, err
 		}
 
-		if tags == nil || len(tags) <= 0 {
+		if items == nil || len(items) <= 0 {
 			break
 		}
 
-		for _, tag := range tags {
-			if strings.HasPrefix(*tag.Name, "release/") ||
-				strings.HasPrefix(*tag.Name, "release-") {
-				return tag, nil
+		for _, tag := range items {
+			if !filter.Accept(*tag.Name) {
+				continue
 			}
+			tags = append(tags, tag)
+			names = append(names, *tag.Name)
+		}
+	}
+
+	if me.opts.PreferHighest {
+		if i := highestVersion(names); i >= 0 {
+			return tags[i], nil
+		}
+	}
+
+	for _, tag := range tags {
+		if strings.HasPrefix(*tag.Name, "release/") ||
+			strings.HasPrefix(*tag.Name, "release-") {
+			return tag, nil
 		}
 	}
 
+	if me.opts.TagSeries != "" && len(tags) > 0 {
+		return tags[0], nil
+	}
 	return nil, nil
 }
 
-func (me *GitHubService) findBranch(owner, repo, name string) (*github.Branch, error) {
-	for page := 1; page < 100; page++ {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-		defer cancel()
-		opts := &github.BranchListOptions{
-			ListOptions: github.ListOptions{Page: page, PerPage: 100},
-		}
-		branches, _, err := me.client.Repositories.ListBranches(ctx, owner, repo, opts)
-		if err != nil {
-			return nil, err
+func (me *GitHubService) findBranch(ctx context.Context, owner, repo, name string) (*github.Branch, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	branch, resp, err := me.client.Repositories.GetBranch(reqCtx, owner, repo, name, 1)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, nil
 		}
+		return nil, err
+	}
+	return branch, nil
+}
 
-		if branches == nil || len(branches) <= 0 {
-			return nil, errors.New("no branch fetched")
+// findTag 通过单个引用的接口查找 Tag, 附注标签会被解析到其指向的 Commit
+func (me *GitHubService) findTag(ctx context.Context, owner, repo, name string) (*github.RepositoryTag, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	ref, resp, err := me.client.Git.GetRef(reqCtx, owner, repo, "tags/"+name)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, nil
 		}
-		for _, branch := range branches {
-			if *branch.Name == name {
-				return branch, nil
-			}
+		// 引用名称是其它引用的前缀时接口返回的是数组, 只能通过列表查找
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return me.findTagByListing(ctx, owner, repo, name)
 		}
+		return nil, err
 	}
-	return nil, errors.New("no matched branch")
+
+	sha, err := me.peelTag(ctx, owner, repo, ref.GetObject())
+	if err != nil {
+		return nil, err
+	}
+	return &github.RepositoryTag{
+		Name:   github.String(name),
+		Commit: &github.Commit{SHA: github.String(sha)},
+	}, nil
 }
 
-func (me *GitHubService) findTag(owner, repo, name string) (*github.RepositoryTag, error) {
-	for page := 1; page < 100; page++ {
+func (me *GitHubService) peelTag(ctx context.Context, owner, repo string, object *github.GitObject) (string, error) {
+	for i := 0; i < 10 && object.GetType() == "tag"; i++ {
+		reqCtx, cancel := context.WithTimeout(ctx, time.Second*10)
+		tag, _, err := me.client.Git.GetTag(reqCtx, owner, repo, object.GetSHA())
+		cancel()
+		if err != nil {
+			return "", err
+		}
+		object = tag.GetObject()
+	}
+	if object.GetType() != "commit" {
+		return "", fmt.Errorf("tag does not point to a commit: %s %s", object.GetType(), object.GetSHA())
+	}
+	return object.GetSHA(), nil
+}
 
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-		defer cancel()
+func (me *GitHubService) findTagByListing(ctx context.Context, owner, repo,
+	name string) (*github.RepositoryTag, error) {
+	for page := 1; page < 100; page++ {
+		reqCtx, cancel := context.WithTimeout(ctx, time.Second*10)
 		opts := &github.ListOptions{Page: page, PerPage: 100}
-		tags, _, err := me.client.Repositories.ListTags(ctx, owner, repo, opts)
+		tags, _, err := me.client.Repositories.ListTags(reqCtx, owner, repo, opts)
+		cancel()
 		if err != nil {
 			return nil, err
 		}
 
 		if tags == nil || len(tags) <= 0 {
-			return nil, errors.New("no tag fetched")
+			break
 		}
 
 		for _, tag := range tags {
@@ -352,7 +691,7 @@ func (me *GitHubService) findTag(owner, repo, name string) (*github.RepositoryTa
 		}
 	}
 
-	return nil, errors.New("no matched tag")
+	return nil, nil
 }
 
 func validateArchive(arc *common.ArchiveInfo) (*common.ArchiveInfo, error) {
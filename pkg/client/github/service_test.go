@@ -0,0 +1,322 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"gitar/pkg/client/common"
+	"github.com/google/go-github/v56/github"
+)
+
+const (
+	commitV1      = "1111111111111111111111111111111111111111"
+	commitV2      = "2222222222222222222222222222222222222222"
+	commitV3      = "3333333333333333333333333333333333333333"
+	commitMain    = "4444444444444444444444444444444444444444"
+	commitFeature = "5555555555555555555555555555555555555555"
+	tagObjectV2   = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	tagObjectV2b  = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc, tokens ...string) *github.Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	client := github.NewClient(&http.Client{Transport: NewTokenPool(tokens, nil)})
+	baseUrl, _ := url.Parse(server.URL + "/")
+	client.BaseURL = baseUrl
+	return client
+}
+
+// fakeGitHub 模拟 GitHub 接口中与引用相关的部分:
+// v1 是轻量标签, v2 是指向附注标签的附注标签, v3 同时是 v3.1 的前缀, 接口返回数组
+func fakeGitHub(t *testing.T) (*GitHubService, *[]string) {
+	var requests []string
+	writeJson := func(w http.ResponseWriter, v any) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(v)
+	}
+	ref := func(name, kind, sha string) map[string]any {
+		return map[string]any{"ref": "refs/tags/" + name, "object": map[string]any{"type": kind, "sha": sha}}
+	}
+	tags := []map[string]any{
+		{"name": "v3.1", "commit": map[string]any{"sha": commitV3}},
+		{"name": "v3", "commit": map[string]any{"sha": commitV3}},
+		{"name": "v2", "commit": map[string]any{"sha": commitV2}},
+		{"name": "v1", "commit": map[string]any{"sha": commitV1}},
+	}
+
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		path := strings.TrimPrefix(r.URL.Path, "/repos/owner/repo/")
+		switch {
+		case path == "git/ref/tags/v1":
+			writeJson(w, ref("v1", "commit", commitV1))
+		case path == "git/ref/tags/v2":
+			writeJson(w, ref("v2", "tag", tagObjectV2))
+		case path == "git/tags/"+tagObjectV2:
+			writeJson(w, map[string]any{"sha": tagObjectV2, "object": map[string]any{"type": "tag", "sha": tagObjectV2b}})
+		case path == "git/tags/"+tagObjectV2b:
+			writeJson(w, map[string]any{"sha": tagObjectV2b, "object": map[string]any{"type": "commit", "sha": commitV2}})
+		case path == "git/ref/tags/v3":
+			writeJson(w, []map[string]any{ref("v3", "commit", commitV3), ref("v3.1", "commit", commitV3)})
+		case path == "tags":
+			if r.URL.Query().Get("page") == "1" {
+				writeJson(w, tags)
+			} else {
+				writeJson(w, []any{})
+			}
+		case path == "branches/main":
+			writeJson(w, map[string]any{"name": "main", "commit": map[string]any{"sha": commitMain}})
+		case path == "branches/feature/login":
+			writeJson(w, map[string]any{"name": "feature/login", "commit": map[string]any{"sha": commitFeature}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "Not Found"}`))
+		}
+	}, "token")
+	return &GitHubService{client: client}, &requests
+}
+
+func TestFindTag(t *testing.T) {
+	cases := []struct {
+		name    string
+		tag     string
+		commit  string
+		listing bool
+	}{
+		{"lightweight tag", "v1", commitV1, false},
+		{"annotated tag is peeled", "v2", commitV2, false},
+		{"prefix of another ref falls back to listing", "v3", commitV3, true},
+		{"missing tag", "v9", "", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			service, requests := fakeGitHub(t)
+			tag, err := service.findTag(context.Background(), "owner", "repo", c.tag)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.commit == "" {
+				if tag != nil {
+					t.Fatalf("expected no tag, got %v", tag)
+				}
+				return
+			}
+			if tag == nil {
+				t.Fatal("tag not found")
+			}
+			if tag.GetName() != c.tag || tag.GetCommit().GetSHA() != c.commit {
+				t.Errorf("got %s %s, expected %s %s", tag.GetName(), tag.GetCommit().GetSHA(), c.tag, c.commit)
+			}
+			listed := false
+			for _, path := range *requests {
+				listed = listed || strings.HasSuffix(path, "/tags")
+			}
+			if listed != c.listing {
+				t.Errorf("listing = %v, expected %v, requests: %v", listed, c.listing, *requests)
+			}
+		})
+	}
+}
+
+func TestPeelTagRejectsNonCommit(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `{"sha": "%s", "object": {"type": "tree", "sha": "%s"}}`, tagObjectV2, commitV1)
+	}, "token")
+	service := &GitHubService{client: client}
+	object := &github.GitObject{Type: github.String("tag"), SHA: github.String(tagObjectV2)}
+	_, err := service.peelTag(context.Background(), "owner", "repo", object)
+	if err == nil || !strings.Contains(err.Error(), "does not point to a commit") {
+		t.Fatalf("expected error, got %v", err)
+	}
+}
+
+func TestFindBranch(t *testing.T) {
+	cases := []struct {
+		branch string
+		commit string
+	}{
+		{"main", commitMain},
+		{"feature/login", commitFeature},
+		{"missing", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.branch, func(t *testing.T) {
+			service, _ := fakeGitHub(t)
+			branch, err := service.findBranch(context.Background(), "owner", "repo", c.branch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.commit == "" {
+				if branch != nil {
+					t.Fatalf("expected no branch, got %v", branch)
+				}
+				return
+			}
+			if branch == nil || branch.GetCommit().GetSHA() != c.commit {
+				t.Fatalf("got %v, expected %s", branch, c.commit)
+			}
+		})
+	}
+}
+
+func TestFindTagByListing(t *testing.T) {
+	service, _ := fakeGitHub(t)
+	tag, err := service.findTagByListing(context.Background(), "owner", "repo", "v3.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag == nil || tag.GetCommit().GetSHA() != commitV3 {
+		t.Fatalf("got %v, expected %s", tag, commitV3)
+	}
+	tag, err = service.findTagByListing(context.Background(), "owner", "repo", "v3.2")
+	if err != nil || tag != nil {
+		t.Fatalf("expected no tag, got %v %v", tag, err)
+	}
+}
+
+func fakePullRequest(t *testing.T) *GitHubService {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/owner/repo/pulls/42" || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Accept") == "application/vnd.github.patch" {
+			_, _ = w.Write([]byte("From " + commitFeature + " Mon Sep 17 00:00:00 2001\n"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"number": 42,
+			"title":  "Add login",
+			"state":  "closed",
+			"merged": true,
+			"user":   map[string]any{"login": "alice"},
+			"head": map[string]any{
+				"sha":  commitFeature,
+				"repo": map[string]any{"full_name": "alice/repo"},
+			},
+		})
+	}, "token")
+	return &GitHubService{client: client}
+}
+
+func TestResolveArchiveByPullRequest(t *testing.T) {
+	service := fakePullRequest(t)
+	url := common.RepoUrl{Platform: Platform, Host: Host, Owner: "owner", Repo: "repo", PullRequest: 42}
+	arc, err := service.resolveArchiveByPullRequest(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if arc.Name != "repo-pr42-5555555" || arc.Commit != commitFeature {
+		t.Errorf("got %s %s", arc.Name, arc.Commit)
+	}
+	expect := common.PullRequestInfo{
+		Number: 42, Title: "Add login", Author: "alice", State: "merged", SourceRepo: "alice/repo",
+	}
+	if arc.PullRequest == nil || *arc.PullRequest != expect {
+		t.Errorf("got %+v, expected %+v", arc.PullRequest, expect)
+	}
+}
+
+func TestDownloadPatch(t *testing.T) {
+	service := fakePullRequest(t)
+	url := common.RepoUrl{Platform: Platform, Host: Host, Owner: "owner", Repo: "repo", PullRequest: 42}
+	var patch strings.Builder
+	err := service.DownloadPatch(context.Background(), url, &patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(patch.String(), "From "+commitFeature) {
+		t.Errorf("unexpected patch: %q", patch.String())
+	}
+}
+
+func TestResolveRefPath(t *testing.T) {
+	cases := []struct {
+		refPath string
+		branch  string
+		tag     string
+	}{
+		{"feature/login/docs/README.md", "feature/login", ""},
+		{"v2/src", "", "v2"},
+		{"main/docs", "main", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.refPath, func(t *testing.T) {
+			service, _ := fakeGitHub(t)
+			resolved, err := service.resolveRefPath(context.Background(), common.RepoUrl{
+				Platform: Platform, Owner: "owner", Repo: "repo", RefPath: c.refPath,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resolved.Branch != c.branch || resolved.Tag != c.tag || resolved.RefPath != "" {
+				t.Errorf("got branch %q tag %q, expected branch %q tag %q", resolved.Branch, resolved.Tag, c.branch, c.tag)
+			}
+		})
+	}
+
+	service, _ := fakeGitHub(t)
+	_, err := service.resolveRefPath(context.Background(), common.RepoUrl{
+		Platform: Platform, Owner: "owner", Repo: "repo", RefPath: "nothing/here",
+	})
+	if err == nil {
+		t.Error("expected error for unknown ref")
+	}
+}
+
+func TestResolveRefNameShortCommit(t *testing.T) {
+	service, _ := fakeGitHub(t)
+	resolved, err := service.resolveRefName(context.Background(), common.RepoUrl{
+		Platform: Platform, Owner: "owner", Repo: "repo", RefName: "abc1234",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.Commit != "abc1234" || resolved.RefName != "" {
+		t.Errorf("got %+v, expected short commit", *resolved)
+	}
+}
+
+func TestResolveRefName(t *testing.T) {
+	cases := []struct {
+		refName string
+		branch  string
+		tag     string
+	}{
+		{"main", "main", ""},
+		{"v1", "", "v1"},
+		{"missing", "", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.refName, func(t *testing.T) {
+			service, requests := fakeGitHub(t)
+			resolved, err := service.resolveRefName(context.Background(), common.RepoUrl{
+				Platform: Platform, Owner: "owner", Repo: "repo", RefName: c.refName,
+			})
+			if c.branch == "" && c.tag == "" {
+				if err == nil {
+					t.Errorf("expected error, got %+v", resolved)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resolved.Branch != c.branch || resolved.Tag != c.tag || resolved.RefName != c.refName {
+				t.Errorf("got branch %q tag %q, expected branch %q tag %q", resolved.Branch, resolved.Tag, c.branch, c.tag)
+			}
+			// 单段引用名不需要尝试前缀
+			if len(*requests) > 2 {
+				t.Errorf("too many requests: %v", *requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package github
+
+import (
+	"regexp"
+	"strings"
+
+	"gitar/pkg/client/common"
+	"gitar/pkg/version"
+)
+
+type tagFilter struct {
+	ignore []*regexp.Regexp
+	series string
+}
+
+func newTagFilter(opts common.ResolveOptions) (*tagFilter, error) {
+	filter := &tagFilter{series: opts.TagSeries}
+	for _, pattern := range opts.TagIgnore {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		filter.ignore = append(filter.ignore, re)
+	}
+	return filter, nil
+}
+
+// Accept 判断 Tag 是否属于配置的系列且未被忽略
+func (me *tagFilter) Accept(name string) bool {
+	if me.series != "" && !strings.HasPrefix(name, me.series) {
+		return false
+	}
+	for _, re := range me.ignore {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// highestVersion 返回版本号最高的 Tag 的下标, 优先选择正式版本, 都无法解析时返回 -1.
+// 前缀不同的 Tag 属于不同的系列, 只在 Tag 最多的系列中选择, 数量相同时选择先出现的系列
+func highestVersion(names []string) int {
+	versions := make([]*version.Version, len(names))
+	counts := make(map[string]int)
+	for i, name := range names {
+		versions[i] = version.Parse(name)
+		if versions[i] != nil {
+			counts[versions[i].Prefix]++
+		}
+	}
+	prefix, most := "", 0
+	for _, ver := range versions {
+		if ver != nil && counts[ver.Prefix] > most {
+			prefix, most = ver.Prefix, counts[ver.Prefix]
+		}
+	}
+
+	best := -1
+	var bestVer *version.Version
+	for i, ver := range versions {
+		if ver == nil || ver.Prefix != prefix {
+			continue
+		}
+		if bestVer == nil ||
+			bestVer.IsPrerelease() && !ver.IsPrerelease() ||
+			bestVer.IsPrerelease() == ver.IsPrerelease() && version.Compare(ver, bestVer) > 0 {
+			best = i
+			bestVer = ver
+		}
+	}
+	return best
+}
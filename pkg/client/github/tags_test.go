@@ -0,0 +1,25 @@
+package github
+
+import "testing"
+
+func TestHighestVersion(t *testing.T) {
+	cases := []struct {
+		names    []string
+		expected int
+	}{
+		{[]string{"v1.2.0", "v1.10.0", "v1.9.3"}, 1},
+		{[]string{"v2.0.0-rc.1", "v1.9.0"}, 1},
+		{[]string{"v2.0.0-rc.1", "v2.0.0-rc.2"}, 1},
+		{[]string{"latest", "nightly"}, -1},
+		// 子模块的 Tag 不与主版本比较
+		{[]string{"api/v9.0.0", "v1.2.0", "v1.3.0", "api/v9.1.0", "v1.1.0"}, 2},
+		// 数量相同时选择先出现的系列
+		{[]string{"server-0.9.0", "sdk-3.0.0", "server-1.0.0", "sdk-2.0.0"}, 2},
+		{[]string{"v1.0.0", "2023.06.01", "2023.10.01"}, 2},
+	}
+	for _, c := range cases {
+		if got := highestVersion(c.names); got != c.expected {
+			t.Errorf("highestVersion(%v) = %d, expected %d", c.names, got, c.expected)
+		}
+	}
+}
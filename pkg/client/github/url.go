@@ -4,74 +4,240 @@ import (
 	"fmt"
 	"net/url"
 	"regexp"
+	"strconv"
+	"strings"
 
 	"gitar/pkg/client/common"
 )
 
-func ParseGithubRepoUrl(rawUrl string) (*common.RepoUrl, error) {
+var (
+	namePattern        = regexp.MustCompile(`^[\w\-.]+$`)
+	commitPattern      = regexp.MustCompile(`^[0-9a-fA-F]{40}$`)
+	shortCommitPattern = regexp.MustCompile(`^[0-9a-fA-F]{7,40}$`)
+)
+
+// ParseGithubRepoUrl 解析 GitHub 的各种地址形式:
+//
+//	git@{host}:{owner}/{repo}.git, ssh://git@{host}/{owner}/{repo}.git
+//	https://{host}/{owner}/{repo}, {host}/{owner}/{repo}, {owner}/{repo}, gh:{owner}/{repo}
+//	.../tree/{ref}, .../blob/{ref}/{path}, .../commits/{ref}, .../commit/{sha}
+//	.../releases/tag/{tag}, .../releases/latest, .../releases/download/{tag}/{asset}
+//	.../archive/refs/tags/{tag}.tar.gz, .../archive/refs/heads/{branch}.tar.gz, .../archive/{ref}.zip
+//	.../compare/{base}...{head}, .../pull/{number}
+//
+// 含有 / 的引用无法仅从地址中与文件路径区分, 会放在 RefPath 中由 API 确定
+func ParseGithubRepoUrl(rawUrl string, host string) (*common.RepoUrl, error) {
 	info := &common.RepoUrl{
 		Platform: Platform,
-		Host:     Host,
+		Host:     host,
 	}
 
-	// SSH URL
-	re := regexp.MustCompile(`^git@github\.com:([\w\-.]+)/([\w\-.]+)\.git$`)
-	match := re.FindStringSubmatch(rawUrl)
-	if match != nil {
-		info.Owner = match[1]
-		info.Repo = match[2]
-		return info, nil
+	segments, ok := splitGithubUrl(rawUrl, host)
+	if !ok || len(segments) < 2 {
+		return nil, fmt.Errorf("unsupported GitHub url %s", rawUrl)
 	}
 
-	// HTTPS URL
-	re = regexp.MustCompile(`^https://github\.com/([\w\-.]+)/([\w\-.]+?)(?:/|\.git)?$`)
-	match = re.FindStringSubmatch(rawUrl)
-	if match != nil {
-		info.Owner = match[1]
-		info.Repo = match[2]
-		return info, nil
+	info.Owner = segments[0]
+	info.Repo = strings.TrimSuffix(segments[1], ".git")
+	if !namePattern.MatchString(info.Owner) || !namePattern.MatchString(info.Repo) {
+		return nil, fmt.Errorf("unsupported GitHub url %s", rawUrl)
 	}
 
-	// HTTPS Tag URL
-	re = regexp.MustCompile(`^https://github\.com/([\w\-.]+)/([\w\-.]+)/releases/tag/([\w\-.%]+)?$`)
-	match = re.FindStringSubmatch(rawUrl)
-	if match != nil {
-		tag, err := url.QueryUnescape(match[3])
-		if err != nil {
-			return nil, err
-		}
+	err := parseGithubPath(info, segments[2:])
+	if err != nil {
+		return nil, fmt.Errorf("unsupported GitHub url %s: %w", rawUrl, err)
+	}
+	return info, nil
+}
 
-		info.Owner = match[1]
-		info.Repo = match[2]
-		info.Release = tag
-		info.Tag = tag
-		return info, nil
+// IsGithubShorthand 判断是否为 {owner}/{repo} 或 gh:{owner}/{repo} 形式的简写
+func IsGithubShorthand(rawUrl string) bool {
+	if strings.HasPrefix(rawUrl, "gh:") {
+		return true
 	}
+	owner, repo, found := strings.Cut(rawUrl, "/")
+	return found && namePattern.MatchString(owner) && namePattern.MatchString(repo)
+}
 
-	// HTTPS Tree of Commit URL
-	re = regexp.MustCompile(`^https://github\.com/([\w\-.]+)/([\w\-.]+)/tree/([0-9a-fA-F]{40})?$`)
-	match = re.FindStringSubmatch(rawUrl)
-	if match != nil {
-		info.Owner = match[1]
-		info.Repo = match[2]
-		info.Commit = match[3]
-		return info, nil
+// splitGithubUrl 去掉协议, 主机, 查询参数和片段, 返回解码后的路径段
+func splitGithubUrl(rawUrl string, host string) ([]string, bool) {
+	rawUrl = strings.TrimSpace(rawUrl)
+	if i := strings.IndexAny(rawUrl, "?#"); i >= 0 {
+		rawUrl = rawUrl[:i]
+	}
+
+	path := ""
+	prefixes := []string{
+		"git@" + host + ":",
+		"ssh://git@" + host + "/",
+		"git://" + host + "/",
+		"https://" + host + "/",
+		"http://" + host + "/",
+		"https://www." + host + "/",
+		host + "/",
+	}
+	matched := false
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(rawUrl, prefix) {
+			path = rawUrl[len(prefix):]
+			matched = true
+			break
+		}
+	}
+	if !matched && host == Host {
+		if strings.HasPrefix(rawUrl, "gh:") {
+			path = rawUrl[len("gh:"):]
+			matched = true
+		} else if IsGithubShorthand(rawUrl) {
+			path = rawUrl
+			matched = true
+		}
+	}
+	if !matched {
+		return nil, false
 	}
 
-	// HTTPS Tree URL
-	re = regexp.MustCompile(`^https://github\.com/([\w\-.]+)/([\w\-.]+)/tree/([\w\-.%]+)?$`)
-	match = re.FindStringSubmatch(rawUrl)
-	if match != nil {
-		ref, err := url.QueryUnescape(match[3])
+	segments := []string{}
+	for _, segment := range strings.Split(strings.Trim(path, "/"), "/") {
+		if segment == "" {
+			continue
+		}
+		decoded, err := url.PathUnescape(segment)
 		if err != nil {
-			return nil, err
+			return nil, false
+		}
+		segments = append(segments, decoded)
+	}
+	return segments, true
+}
+
+func parseGithubPath(info *common.RepoUrl, rest []string) error {
+	if len(rest) <= 0 {
+		return nil
+	}
+
+	switch rest[0] {
+	case "tree", "blob", "commits":
+		if len(rest) < 2 {
+			return nil
+		}
+		if len(rest) == 2 && commitPattern.MatchString(rest[1]) {
+			info.Commit = rest[1]
+			return nil
+		}
+		setRefPath(info, strings.Join(rest[1:], "/"))
+		return nil
+
+	case "commit":
+		if len(rest) != 2 || !shortCommitPattern.MatchString(rest[1]) {
+			return fmt.Errorf("invalid commit")
+		}
+		info.Commit = rest[1]
+		return nil
+
+	case "releases":
+		if len(rest) == 1 || len(rest) == 2 && rest[1] == "latest" {
+			// 最新 Release 即默认的解析规则
+			return nil
 		}
-		info.Owner = match[1]
-		info.Repo = match[2]
-		info.Branch = ref
-		info.RefName = ref
-		return info, nil
+		if rest[1] == "tag" && len(rest) >= 3 {
+			info.Release = strings.Join(rest[2:], "/")
+			info.Tag = info.Release
+			return nil
+		}
+		if rest[1] == "download" && len(rest) >= 4 {
+			info.Release = strings.Join(rest[2:len(rest)-1], "/")
+			info.Tag = info.Release
+			return nil
+		}
+		return fmt.Errorf("invalid release path")
+
+	case "archive":
+		if len(rest) < 2 {
+			return fmt.Errorf("invalid archive path")
+		}
+		last := len(rest) - 1
+		name, ok := trimArchiveExt(rest[last])
+		if !ok {
+			return fmt.Errorf("unknown archive format")
+		}
+		parts := append(append([]string{}, rest[1:last]...), name)
+		if len(parts) >= 3 && parts[0] == "refs" && parts[1] == "tags" {
+			info.Release = strings.Join(parts[2:], "/")
+			info.Tag = info.Release
+			return nil
+		}
+		if len(parts) >= 3 && parts[0] == "refs" && parts[1] == "heads" {
+			info.Branch = strings.Join(parts[2:], "/")
+			info.RefName = info.Branch
+			return nil
+		}
+		if len(parts) == 1 && commitPattern.MatchString(parts[0]) {
+			info.Commit = parts[0]
+			return nil
+		}
+		setRefPath(info, strings.Join(parts, "/"))
+		return nil
+
+	case "compare":
+		// 对比页面归档 head 一侧
+		if len(rest) < 2 {
+			return fmt.Errorf("invalid compare path")
+		}
+		spec := strings.Join(rest[1:], "/")
+		if i := strings.LastIndex(spec, "..."); i >= 0 {
+			spec = spec[i+3:]
+		} else if i := strings.LastIndex(spec, ".."); i >= 0 {
+			spec = spec[i+2:]
+		}
+		if strings.Contains(spec, ":") {
+			return fmt.Errorf("cross-repository compare is not supported")
+		}
+		if commitPattern.MatchString(spec) {
+			info.Commit = spec
+			return nil
+		}
+		setRefPath(info, spec)
+		return nil
+
+	case "pull":
+		if len(rest) < 2 {
+			return fmt.Errorf("invalid pull request path")
+		}
+		number, err := strconv.Atoi(rest[1])
+		if err != nil || number <= 0 {
+			return fmt.Errorf("invalid pull request number")
+		}
+		info.PullRequest = number
+		return nil
+
+	case "tags", "branches":
+		return nil
 	}
 
-	return nil, fmt.Errorf("unsupported GitHub url %s", rawUrl)
+	return fmt.Errorf("unknown path %q", rest[0])
+}
+
+// IsShortCommit 判断是否为需要通过 API 补全的缩写 Commit ID
+func IsShortCommit(commit string) bool {
+	return len(commit) < 40 && shortCommitPattern.MatchString(commit)
+}
+
+// setRefPath 没有 / 的引用名可以直接使用, 但不能确定是分支还是 Tag,
+// 否则需要通过 API 区分引用和文件路径
+func setRefPath(info *common.RepoUrl, refPath string) {
+	if strings.Contains(refPath, "/") {
+		info.RefPath = refPath
+	} else {
+		info.RefName = refPath
+	}
+}
+
+func trimArchiveExt(name string) (string, bool) {
+	for _, ext := range []string{".tar.gz", ".tgz", ".zip"} {
+		if strings.HasSuffix(name, ext) {
+			return strings.TrimSuffix(name, ext), true
+		}
+	}
+	return name, false
 }
@@ -0,0 +1,91 @@
+package github
+
+import (
+	"testing"
+
+	"gitar/pkg/client/common"
+)
+
+func TestParseGithubRepoUrl(t *testing.T) {
+	const sha = "0123456789abcdef0123456789abcdef01234567"
+	repo := func(mutate func(info *common.RepoUrl)) *common.RepoUrl {
+		info := &common.RepoUrl{Platform: Platform, Host: Host, Owner: "owner", Repo: "repo"}
+		if mutate != nil {
+			mutate(info)
+		}
+		return info
+	}
+	refName := func(ref string) *common.RepoUrl {
+		return repo(func(info *common.RepoUrl) { info.RefName = ref })
+	}
+	refPath := func(ref string) *common.RepoUrl {
+		return repo(func(info *common.RepoUrl) { info.RefPath = ref })
+	}
+
+	cases := []struct {
+		url      string
+		expected *common.RepoUrl
+	}{
+		{"owner/repo", repo(nil)},
+		{"gh:owner/repo", repo(nil)},
+		{"github.com/owner/repo", repo(nil)},
+		{"https://github.com/owner/repo.git", repo(nil)},
+		{"git@github.com:owner/repo.git", repo(nil)},
+		{"ssh://git@github.com/owner/repo.git", repo(nil)},
+		{"https://github.com/owner/repo/", repo(nil)},
+		{"https://github.com/owner/repo?tab=readme-ov-file", repo(nil)},
+		{"https://github.com/owner/repo#readme", repo(nil)},
+		{"https://github.com/owner/repo/tree/main?plain=1#L10", refName("main")},
+		{"https://github.com/owner/repo/tree/main", refName("main")},
+		{"https://github.com/owner/repo/tree/feature/login", refPath("feature/login")},
+		{"https://github.com/owner/repo/tree/feature%2Flogin", refPath("feature/login")},
+		{"https://github.com/owner/repo/blob/v1.0/src/main.go", refPath("v1.0/src/main.go")},
+		{"https://github.com/owner/repo/tree/" + sha, repo(func(info *common.RepoUrl) { info.Commit = sha })},
+		{"https://github.com/owner/repo/commits/release/1.x", refPath("release/1.x")},
+		{"https://github.com/owner/repo/commit/abc1234", repo(func(info *common.RepoUrl) { info.Commit = "abc1234" })},
+		{"https://github.com/owner/repo/releases/latest", repo(nil)},
+		{"https://github.com/owner/repo/releases", repo(nil)},
+		{"https://github.com/owner/repo/releases/tag/v1.2.3",
+			repo(func(info *common.RepoUrl) { info.Release = "v1.2.3"; info.Tag = "v1.2.3" })},
+		{"https://github.com/owner/repo/releases/download/v1.2.3/repo-linux.tar.gz",
+			repo(func(info *common.RepoUrl) { info.Release = "v1.2.3"; info.Tag = "v1.2.3" })},
+		{"https://github.com/owner/repo/archive/refs/tags/x.tar.gz",
+			repo(func(info *common.RepoUrl) { info.Release = "x"; info.Tag = "x" })},
+		{"https://github.com/owner/repo/archive/refs/heads/feature/login.zip",
+			repo(func(info *common.RepoUrl) { info.Branch = "feature/login"; info.RefName = "feature/login" })},
+		{"https://github.com/owner/repo/archive/" + sha + ".tar.gz", repo(func(info *common.RepoUrl) { info.Commit = sha })},
+		{"https://github.com/owner/repo/archive/v1.0.zip", refName("v1.0")},
+		{"https://github.com/owner/repo/compare/v1.0...feature/login", refPath("feature/login")},
+		{"https://github.com/owner/repo/pull/42", repo(func(info *common.RepoUrl) { info.PullRequest = 42 })},
+	}
+	for _, c := range cases {
+		t.Run(c.url, func(t *testing.T) {
+			info, err := ParseGithubRepoUrl(c.url, Host)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *info != *c.expected {
+				t.Errorf("got %+v, expected %+v", *info, *c.expected)
+			}
+		})
+	}
+}
+
+func TestParseGithubRepoUrlInvalid(t *testing.T) {
+	for _, url := range []string{
+		"owner",
+		"https://gitlab.com/owner/repo",
+		"https://github.com/owner/repo/commit/xyz",
+		"https://github.com/owner/repo/pull/abc",
+		"https://github.com/owner/repo/archive/v1.0.rar",
+		"https://github.com/owner/repo/compare/main...other:main",
+		"https://github.com/owner/repo/wiki",
+	} {
+		t.Run(url, func(t *testing.T) {
+			info, err := ParseGithubRepoUrl(url, Host)
+			if err == nil {
+				t.Errorf("expected error, got %+v", *info)
+			}
+		})
+	}
+}
@@ -1,22 +1,37 @@
 package client
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
 	"strings"
+	"sync"
 
 	"gitar/pkg/client/common"
 	"gitar/pkg/client/gitee"
 	"gitar/pkg/client/github"
 	"gitar/pkg/config"
+	"gitar/pkg/httpcache"
+	"github.com/sirupsen/logrus"
 )
 
-func ParseRepoUrl(url string) (*common.RepoUrl, error) {
+// tokenPools 按主机保存 GitHub Token 池, 同一进程中的所有请求共享 Token 的轮换和额度状态
+var tokenPools sync.Map
+
+func ParseRepoUrl(url string, config *config.ConfigProperties) (*common.RepoUrl, error) {
 	if len(url) <= 0 {
 		return nil, errors.New("url is empty")
 	}
-	if strings.Contains(url, github.Host) {
-		return github.ParseGithubRepoUrl(url)
+	if github.IsGithubShorthand(url) {
+		return github.ParseGithubRepoUrl(url, github.Host)
+	}
+	for _, host := range config.GitHub.HostNames() {
+		if strings.Contains(url, host) {
+			return github.ParseGithubRepoUrl(url, host)
+		}
 	}
 	if strings.Contains(url, gitee.Host) {
 		return gitee.ParseGiteeRepoUrl(url)
@@ -24,10 +39,136 @@ func ParseRepoUrl(url string) (*common.RepoUrl, error) {
 	return nil, fmt.Errorf("unsupported url %s", url)
 }
 
-func ResolveArchive(url common.RepoUrl, config *config.ConfigProperties) (*common.ArchiveInfo, error) {
+func ResolveArchive(ctx context.Context, url common.RepoUrl,
+	config *config.ConfigProperties) (*common.ArchiveInfo, error) {
 	if url.Platform == github.Platform {
-		svc := github.NewGitHubService(config.GitHub.Token)
-		return common.ResolveArchiveWithRetry(url, svc, 9999)
+		svc, err := newGitHubService(config, url.Host, newResolveOptions(url, config))
+		if err != nil {
+			return nil, err
+		}
+		return common.ResolveArchiveWithRetry(ctx, url, svc, 9999)
 	}
 	return nil, fmt.Errorf("unsupported platform: %s", url.Platform)
 }
+
+// ResolveArchives 批量解析归档, 配置了 GitHub Token 时通过 GraphQL 一次查询多个仓库,
+// 未能解析的位置为 nil, 调用方应回退到 ResolveArchive
+func ResolveArchives(ctx context.Context, urls []common.RepoUrl,
+	config *config.ConfigProperties) []*common.ArchiveInfo {
+	archives := make([]*common.ArchiveInfo, len(urls))
+
+	groups := map[string][]int{}
+	for i, url := range urls {
+		// 含有 / 的引用需要通过 REST 接口逐个尝试前缀
+		if url.Platform != github.Platform || url.PullRequest > 0 || url.RefPath != "" ||
+			!url.Until.IsZero() || github.IsShortCommit(url.Commit) {
+			continue
+		}
+		// 需要附件或自定义 Tag 规则的仓库只能使用 REST 接口解析
+		opts := newResolveOptions(url, config)
+		if opts.Assets || opts.PreferHighest || opts.TagSeries != "" || len(opts.TagIgnore) > 0 {
+			continue
+		}
+		groups[url.Host] = append(groups[url.Host], i)
+	}
+
+	for host, indexes := range groups {
+		hostCfg := config.GitHub.FindHost(host)
+		if hostCfg == nil || len(hostCfg.AllTokens()) <= 0 || len(indexes) <= 1 {
+			continue
+		}
+
+		batch := []common.RepoUrl{}
+		for _, i := range indexes {
+			batch = append(batch, urls[i])
+		}
+
+		endpoint := ""
+		if hostCfg.IsEnterprise() {
+			endpoint = github.EnterpriseGraphQLEndpoint(hostCfg.Api)
+		}
+		resolver := github.NewGraphQLResolver(newGitHubHttpClient(config, hostCfg, false), endpoint)
+		resolved, err := resolver.ResolveArchives(ctx, batch)
+		if err != nil {
+			logrus.Warnf("GraphQL batch resolving failed: %s", err.Error())
+			continue
+		}
+		for i, arc := range resolved {
+			archives[indexes[i]] = arc
+		}
+	}
+	return archives
+}
+
+func newResolveOptions(url common.RepoUrl, config *config.ConfigProperties) common.ResolveOptions {
+	repoCfg := config.FindRepo(url.Host, url.Owner, url.Repo)
+	return common.ResolveOptions{
+		Assets:        repoCfg.Assets.Enabled,
+		TagIgnore:     repoCfg.Tags.Ignore,
+		TagSeries:     repoCfg.Tags.Series,
+		PreferHighest: repoCfg.Tags.Prefer == "highest",
+
+		BranchFallback: config.GitHub.BranchFallback,
+	}
+}
+
+func ResolveSubmoduleCommit(ctx context.Context, url common.RepoUrl, config *config.ConfigProperties,
+	commit, path string) (string, error) {
+	if url.Platform == github.Platform {
+		svc, err := newGitHubService(config, url.Host, common.ResolveOptions{})
+		if err != nil {
+			return "", err
+		}
+		return svc.ResolveSubmoduleCommit(ctx, url, commit, path)
+	}
+	return "", fmt.Errorf("unsupported platform: %s", url.Platform)
+}
+
+// DownloadPatch 下载 Pull Request 的 patch, 使用与解析归档相同的 Token
+func DownloadPatch(ctx context.Context, url common.RepoUrl, config *config.ConfigProperties, w io.Writer) error {
+	if url.Platform == github.Platform && url.PullRequest > 0 {
+		svc, err := newGitHubService(config, url.Host, common.ResolveOptions{})
+		if err != nil {
+			return err
+		}
+		return svc.DownloadPatch(ctx, url, w)
+	}
+	return fmt.Errorf("no pull request patch for %s/%s", url.Owner, url.Repo)
+}
+
+func newGitHubService(config *config.ConfigProperties, host string,
+	opts common.ResolveOptions) (*github.GitHubService, error) {
+	hostCfg := config.GitHub.FindHost(host)
+	if hostCfg == nil {
+		return nil, fmt.Errorf("unknown GitHub host: %s", host)
+	}
+	httpClient := newGitHubHttpClient(config, hostCfg, !config.Cache.Disabled)
+	if hostCfg.IsEnterprise() {
+		return github.NewEnterpriseGitHubService(httpClient, hostCfg.Api, hostCfg.Upload, opts)
+	}
+	return github.NewGitHubService(httpClient, opts), nil
+}
+
+func newGitHubHttpClient(config *config.ConfigProperties, hostCfg *config.GitHubHostProperties,
+	cache bool) *http.Client {
+	var transport http.RoundTripper = GitHubTokenPool(config, hostCfg.Host)
+	if cache {
+		dir := filepath.Join(config.Paths.Data, "cache", "http")
+		transport = httpcache.NewTransport(dir, config.Cache.TTL, transport)
+	}
+	return &http.Client{Transport: transport}
+}
+
+// GitHubTokenPool 返回主机共用的 Token 池, 第一次使用时按配置创建
+func GitHubTokenPool(config *config.ConfigProperties, host string) *github.TokenPool {
+	host = strings.ToLower(host)
+	if pool, found := tokenPools.Load(host); found {
+		return pool.(*github.TokenPool)
+	}
+	var tokens []string
+	if hostCfg := config.GitHub.FindHost(host); hostCfg != nil {
+		tokens = hostCfg.AllTokens()
+	}
+	pool, _ := tokenPools.LoadOrStore(host, github.NewTokenPool(tokens, nil))
+	return pool.(*github.TokenPool)
+}
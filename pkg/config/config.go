@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -20,13 +22,197 @@ type PathsProperties struct {
 	Temp string `yaml:"temp"`
 }
 
+type GitHubHostProperties struct {
+	Host   string   `yaml:"host"`
+	Api    string   `yaml:"api"`
+	Upload string   `yaml:"upload"`
+	Token  string   `yaml:"token"`
+	Tokens []string `yaml:"tokens"`
+}
+
 type GitHubProperties struct {
-	Token string `yaml:"token"`
+	Token          string                 `yaml:"token"`
+	Tokens         []string               `yaml:"tokens"`
+	BranchFallback bool                   `yaml:"branch-fallback"`
+	Hosts          []GitHubHostProperties `yaml:"hosts"`
+}
+
+// AllTokens 返回 token 和 tokens 中配置的所有 Token
+func (me *GitHubProperties) AllTokens() []string {
+	return joinTokens(me.Token, me.Tokens)
+}
+
+// FindHost 查找 GitHub 或 GitHub Enterprise Server 主机的配置, 未配置时返回 nil
+func (me *GitHubProperties) FindHost(host string) *GitHubHostProperties {
+	for i := range me.Hosts {
+		if strings.EqualFold(me.Hosts[i].Host, host) {
+			return &me.Hosts[i]
+		}
+	}
+	if host == "" || strings.EqualFold(host, "github.com") {
+		return &GitHubHostProperties{
+			Host:   "github.com",
+			Token:  me.Token,
+			Tokens: me.Tokens,
+		}
+	}
+	return nil
+}
+
+// HostNames 返回所有 GitHub 主机名, 自定义的主机在前
+func (me *GitHubProperties) HostNames() []string {
+	hosts := []string{}
+	for _, item := range me.Hosts {
+		hosts = append(hosts, item.Host)
+	}
+	return append(hosts, "github.com")
+}
+
+func (me *GitHubHostProperties) AllTokens() []string {
+	return joinTokens(me.Token, me.Tokens)
+}
+
+func (me *GitHubHostProperties) IsEnterprise() bool {
+	return me.Api != ""
+}
+
+func joinTokens(token string, tokens []string) []string {
+	result := []string{}
+	if token != "" {
+		result = append(result, token)
+	}
+	for _, item := range tokens {
+		if item != "" && item != token {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+type MirrorProperties struct {
+	Match   string `yaml:"match"`
+	Replace string `yaml:"replace"`
+}
+
+type CacheProperties struct {
+	Disabled bool          `yaml:"disabled"`
+	TTL      time.Duration `yaml:"ttl"`
+}
+
+type AssetsProperties struct {
+	Enabled bool     `yaml:"enabled"`
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+type LfsProperties struct {
+	Enabled  bool   `yaml:"enabled"`
+	Endpoint string `yaml:"endpoint"`
+	MaxSize  int64  `yaml:"max-size"`
+}
+
+type TagsProperties struct {
+	Ignore []string `yaml:"ignore"`
+	Prefer string   `yaml:"prefer"`
+	Series string   `yaml:"series"`
+}
+
+type RepoProperties struct {
+	Name       string           `yaml:"name"`
+	Assets     AssetsProperties `yaml:"assets"`
+	Submodules bool             `yaml:"submodules"`
+	Lfs        LfsProperties    `yaml:"lfs"`
+	Tags       TagsProperties   `yaml:"tags"`
+}
+
+const (
+	StoreModeXz    = "xz"
+	StoreModeCas   = "cas"
+	StoreModeDelta = "delta"
+
+	DefaultKeyframeInterval = 10
+
+	DefaultServeCacheSize = 10 << 30
+)
+
+// StoreProperties 归档的保存方式, cas 模式下文件内容按哈希去重保存在 Objects 目录中,
+// delta 模式下保存与上一个快照的差异, 每 Keyframe 个快照保存一次完整归档
+type StoreProperties struct {
+	Mode     string `yaml:"mode"`
+	Objects  string `yaml:"objects"`
+	Keyframe int    `yaml:"keyframe"`
+}
+
+// ServeProperties 是 gitar serve 的配置, 配置了 Token 时才开放 /api/ 接口,
+// CacheSize 是解压缓存的字节数上限, 超过时删除最久没有访问的归档
+type ServeProperties struct {
+	Listen    string   `yaml:"listen"`
+	Token     string   `yaml:"token"`
+	Tokens    []string `yaml:"tokens"`
+	CacheSize int64    `yaml:"cache-size"`
+}
+
+// AllTokens 返回所有可以访问 /api/ 的 Token
+func (me *ServeProperties) AllTokens() []string {
+	return joinTokens(me.Token, me.Tokens)
+}
+
+// MaxCacheSize 返回解压缓存的上限, 默认为 DefaultServeCacheSize
+func (me *ServeProperties) MaxCacheSize() int64 {
+	if me.CacheSize <= 0 {
+		return DefaultServeCacheSize
+	}
+	return me.CacheSize
 }
 
 type ConfigProperties struct {
-	Paths  PathsProperties  `yaml:"paths"`
-	GitHub GitHubProperties `yaml:"github"`
+	Paths   PathsProperties    `yaml:"paths"`
+	GitHub  GitHubProperties   `yaml:"github"`
+	Cache   CacheProperties    `yaml:"cache"`
+	Store   StoreProperties    `yaml:"store"`
+	Serve   ServeProperties    `yaml:"serve"`
+	Mirrors []MirrorProperties `yaml:"mirrors"`
+	Repos   []RepoProperties   `yaml:"repos"`
+}
+
+// StoreMode 返回归档的保存方式, 默认为 xz
+func (me *ConfigProperties) StoreMode() string {
+	if me.Store.Mode == "" {
+		return StoreModeXz
+	}
+	return me.Store.Mode
+}
+
+// ObjectsDir 返回 cas 模式的对象目录, 默认在 data 目录下
+func (me *ConfigProperties) ObjectsDir() string {
+	if me.Store.Objects == "" {
+		return filepath.Join(me.Paths.Data, "objects")
+	}
+	return me.Store.Objects
+}
+
+// KeyframeInterval 返回 delta 模式下完整归档的间隔, 默认为 DefaultKeyframeInterval
+func (me *ConfigProperties) KeyframeInterval() int {
+	if me.Store.Keyframe <= 0 {
+		return DefaultKeyframeInterval
+	}
+	return me.Store.Keyframe
+}
+
+// FindRepo 按主机和 owner/repo 查找仓库配置, 未配置时返回空配置.
+// 配置名 {owner}/{repo} 只对应 github.com 上的仓库, 其它主机的仓库需要写成 {host}/{owner}/{repo}
+func (me *ConfigProperties) FindRepo(host, owner, repo string) RepoProperties {
+	name := fmt.Sprintf("%s/%s", owner, repo)
+	qualified := fmt.Sprintf("%s/%s", host, name)
+	for _, item := range me.Repos {
+		if strings.EqualFold(item.Name, qualified) {
+			return item
+		}
+		if strings.EqualFold(host, "github.com") && strings.EqualFold(item.Name, name) {
+			return item
+		}
+	}
+	return RepoProperties{Name: qualified}
 }
 
 func LoadConfig() (*ConfigProperties, error) {
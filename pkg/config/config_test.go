@@ -0,0 +1,30 @@
+package config
+
+import "testing"
+
+func TestFindRepo(t *testing.T) {
+	cfg := &ConfigProperties{Repos: []RepoProperties{
+		{Name: "cli/cli", Submodules: true},
+		{Name: "github.example.com/cli/cli", Lfs: LfsProperties{Enabled: true}},
+		{Name: "gitee.com/owner/repo", Submodules: true},
+	}}
+
+	repoCfg := cfg.FindRepo("github.com", "CLI", "cli")
+	if !repoCfg.Submodules || repoCfg.Lfs.Enabled {
+		t.Errorf("github.com/cli/cli: got %+v", repoCfg)
+	}
+	repoCfg = cfg.FindRepo("github.example.com", "cli", "cli")
+	if repoCfg.Submodules || !repoCfg.Lfs.Enabled {
+		t.Errorf("github.example.com/cli/cli: got %+v", repoCfg)
+	}
+	repoCfg = cfg.FindRepo("github.other.com", "cli", "cli")
+	if repoCfg.Submodules || repoCfg.Lfs.Enabled || repoCfg.Name != "github.other.com/cli/cli" {
+		t.Errorf("github.other.com/cli/cli should not be configured, got %+v", repoCfg)
+	}
+	if !cfg.FindRepo("gitee.com", "owner", "repo").Submodules {
+		t.Error("gitee.com/owner/repo should be configured")
+	}
+	if cfg.FindRepo("github.com", "owner", "repo").Submodules {
+		t.Error("github.com/owner/repo should not be configured")
+	}
+}
@@ -1,5 +1,55 @@
 package data
 
+import (
+	"time"
+)
+
+type RepoMeta struct {
+	Description   string `db:"description"`
+	Topics        string `db:"topics"`
+	License       string `db:"license"`
+	Archived      bool   `db:"archived"`
+	DefaultBranch string `db:"default_branch"`
+}
+
+type PullRequest struct {
+	Platform   string `db:"platform"`
+	Owner      string `db:"owner"`
+	Repo       string `db:"repo"`
+	Number     int    `db:"number"`
+	Commit     string `db:"commit"`
+	Title      string `db:"title"`
+	Author     string `db:"author"`
+	State      string `db:"state"`
+	SourceRepo string `db:"source_repo"`
+}
+
+// Snapshot 是保存下来的一个归档, Sha256 和 Size 是解压后 tar 的.
+// delta 格式的快照记录基准快照的名称和到完整归档的链长度
+type Snapshot struct {
+	Id       int64     `db:"id" json:"id"`
+	Platform string    `db:"platform" json:"platform"`
+	Owner    string    `db:"owner" json:"owner"`
+	Repo     string    `db:"repo" json:"repo"`
+	Name     string    `db:"name" json:"name"`
+	Commit   string    `db:"commit" json:"commit"`
+	Format   string    `db:"format" json:"format"`
+	Path     string    `db:"path" json:"-"`
+	Sha256   string    `db:"sha256" json:"sha256"`
+	Size     int64     `db:"size" json:"size"`
+	Base     string    `db:"base" json:"base,omitempty"`
+	Depth    int       `db:"depth" json:"depth"`
+	Created  time.Time `db:"created" json:"created"`
+}
+
+// SnapshotRepo 是保存过快照的仓库
+type SnapshotRepo struct {
+	Platform  string `db:"platform" json:"platform"`
+	Owner     string `db:"owner" json:"owner"`
+	Repo      string `db:"repo" json:"repo"`
+	Snapshots int    `db:"snapshots" json:"snapshots"`
+}
+
 type DataStore interface {
 	Open() error
 	Close() error
@@ -9,10 +59,31 @@ type DataStore interface {
 
 	GithubRepoExists(owner, repo string) (bool, error)
 	SaveGithubRepo(owner, repo string) error
+	SaveGithubRepoMeta(owner, repo string, meta RepoMeta) error
 
 	IsCommitDownloaded(id string) (bool, error)
 	SetCommitDownloaded(id string) error
 
+	SetCommitError(id string, message string) error
+
 	IsCommitMailed(id string) (bool, error)
 	SetCommitMailed(id string) error
+
+	IsAssetDownloaded(commit, name string) (bool, error)
+	SaveAsset(commit, name string, size int, digest string) error
+
+	SaveSubmodule(commit, path, platform, owner, repo, subCommit string) error
+
+	SavePullRequest(pr PullRequest) error
+
+	SaveSnapshot(snapshot Snapshot) error
+	// FindSnapshot 按归档名或 Commit 前缀查找, 找不到时返回 nil
+	FindSnapshot(key string) (*Snapshot, error)
+	// GetSnapshot 按仓库和归档名查找, 找不到时返回 nil
+	GetSnapshot(platform, owner, repo, name string) (*Snapshot, error)
+	// LatestSnapshot 返回仓库最近保存的快照, 没有时返回 nil
+	LatestSnapshot(platform, owner, repo string) (*Snapshot, error)
+	// ListSnapshots 返回所有平台上 owner/repo 的快照, 按保存时间从新到旧排列
+	ListSnapshots(owner, repo string) ([]Snapshot, error)
+	ListSnapshotRepos() ([]SnapshotRepo, error)
 }
@@ -60,13 +60,80 @@ func (me *Sqlite3DataStore) initDatabase() error {
 		PRIMARY KEY([owner], [repo])
 	);
 
+	CREATE TABLE IF NOT EXISTS [github_repo_meta] (
+		[owner]          TEXT NOT NULL,
+		[repo]           TEXT NOT NULL,
+		[description]    TEXT NOT NULL,
+		[topics]         TEXT NOT NULL,
+		[license]        TEXT NOT NULL,
+		[archived]       INTEGER NOT NULL,
+		[default_branch] TEXT NOT NULL,
+		[updated]        DATETIME NOT NULL,
+		PRIMARY KEY([owner], [repo])
+	);
+
 	CREATE TABLE IF NOT EXISTS [commit_downloaded] (
 		[id] TEXT NOT NULL PRIMARY KEY
 	);
 
+	CREATE TABLE IF NOT EXISTS [commit_error] (
+		[id]      TEXT NOT NULL PRIMARY KEY,
+		[error]   TEXT NOT NULL,
+		[updated] DATETIME NOT NULL
+	);
+
 	CREATE TABLE IF NOT EXISTS [commit_mailed] (
 		[id] TEXT NOT NULL PRIMARY KEY
 	);
+
+	CREATE TABLE IF NOT EXISTS [release_asset] (
+		[commit] TEXT NOT NULL,
+		[name]   TEXT NOT NULL,
+		[size]   INTEGER NOT NULL,
+		[digest] TEXT NOT NULL,
+		PRIMARY KEY([commit], [name])
+	);
+
+	CREATE TABLE IF NOT EXISTS [pull_request] (
+		[platform]    TEXT NOT NULL,
+		[owner]       TEXT NOT NULL,
+		[repo]        TEXT NOT NULL,
+		[number]      INTEGER NOT NULL,
+		[commit]      TEXT NOT NULL,
+		[title]       TEXT NOT NULL,
+		[author]      TEXT NOT NULL,
+		[state]       TEXT NOT NULL,
+		[source_repo] TEXT NOT NULL,
+		[updated]     DATETIME NOT NULL,
+		PRIMARY KEY([platform], [owner], [repo], [number], [commit])
+	);
+
+	CREATE TABLE IF NOT EXISTS [submodule] (
+		[commit]     TEXT NOT NULL,
+		[path]       TEXT NOT NULL,
+		[platform]   TEXT NOT NULL,
+		[owner]      TEXT NOT NULL,
+		[repo]       TEXT NOT NULL,
+		[sub_commit] TEXT NOT NULL,
+		PRIMARY KEY([commit], [path])
+	);
+
+	CREATE TABLE IF NOT EXISTS [snapshot] (
+		[id]       INTEGER PRIMARY KEY AUTOINCREMENT,
+		[platform] TEXT NOT NULL,
+		[owner]    TEXT NOT NULL,
+		[repo]     TEXT NOT NULL,
+		[name]     TEXT NOT NULL,
+		[commit]   TEXT NOT NULL,
+		[format]   TEXT NOT NULL,
+		[path]     TEXT NOT NULL,
+		[sha256]   TEXT NOT NULL,
+		[size]     INTEGER NOT NULL,
+		[base]     TEXT NOT NULL DEFAULT '',
+		[depth]    INTEGER NOT NULL DEFAULT 0,
+		[created]  DATETIME NOT NULL,
+		UNIQUE([platform], [owner], [repo], [name])
+	);
 	`
 	_, err := me.db.Exec(cmd)
 	if err != nil {
@@ -136,6 +203,14 @@ func (me *Sqlite3DataStore) SaveGithubRepo(owner, repo string) error {
 	return err
 }
 
+func (me *Sqlite3DataStore) SaveGithubRepoMeta(owner, repo string, meta RepoMeta) error {
+	cmd := `INSERT OR REPLACE INTO [github_repo_meta]
+		([owner], [repo], [description], [topics], [license], [archived], [default_branch], [updated])
+		VALUES(?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP);`
+	_, err := me.db.Exec(cmd, owner, repo, meta.Description, meta.Topics, meta.License, meta.Archived, meta.DefaultBranch)
+	return err
+}
+
 func (me *Sqlite3DataStore) IsCommitDownloaded(id string) (bool, error) {
 	return me.queryExistsByKey("commit_downloaded", "id", id)
 }
@@ -146,6 +221,12 @@ func (me *Sqlite3DataStore) SetCommitDownloaded(id string) error {
 	return err
 }
 
+func (me *Sqlite3DataStore) SetCommitError(id string, message string) error {
+	cmd := "INSERT OR REPLACE INTO [commit_error] ([id], [error], [updated]) VALUES(?, ?, CURRENT_TIMESTAMP);"
+	_, err := me.db.Exec(cmd, id, message)
+	return err
+}
+
 func (me *Sqlite3DataStore) IsCommitMailed(id string) (bool, error) {
 	return me.queryExistsByKey("commit_mailed", "id", id)
 }
@@ -155,3 +236,101 @@ func (me *Sqlite3DataStore) SetCommitMailed(id string) error {
 	_, err := me.db.Exec(cmd, id)
 	return err
 }
+
+func (me *Sqlite3DataStore) IsAssetDownloaded(commit, name string) (bool, error) {
+	cmd := "SELECT count(*) FROM [release_asset] WHERE [commit] = ? AND [name] = ?;"
+	return me.queryExists(cmd, commit, name)
+}
+
+func (me *Sqlite3DataStore) SaveAsset(commit, name string, size int, digest string) error {
+	cmd := "INSERT OR REPLACE INTO [release_asset] ([commit], [name], [size], [digest]) VALUES(?, ?, ?, ?);"
+	_, err := me.db.Exec(cmd, commit, name, size, digest)
+	return err
+}
+
+func (me *Sqlite3DataStore) SaveSubmodule(commit, path, platform, owner, repo, subCommit string) error {
+	cmd := "INSERT OR REPLACE INTO [submodule] ([commit], [path], [platform], [owner], [repo], [sub_commit]) VALUES(?, ?, ?, ?, ?, ?);"
+	_, err := me.db.Exec(cmd, commit, path, platform, owner, repo, subCommit)
+	return err
+}
+
+func (me *Sqlite3DataStore) SavePullRequest(pr PullRequest) error {
+	cmd := `INSERT OR REPLACE INTO [pull_request]
+		([platform], [owner], [repo], [number], [commit], [title], [author], [state], [source_repo], [updated])
+		VALUES(:platform, :owner, :repo, :number, :commit, :title, :author, :state, :source_repo, CURRENT_TIMESTAMP);`
+	_, err := me.db.NamedExec(cmd, pr)
+	return err
+}
+
+func (me *Sqlite3DataStore) SaveSnapshot(snapshot Snapshot) error {
+	cmd := `INSERT OR REPLACE INTO [snapshot]
+		([platform], [owner], [repo], [name], [commit], [format], [path], [sha256], [size], [base], [depth],
+		[created])
+		VALUES(:platform, :owner, :repo, :name, :commit, :format, :path, :sha256, :size, :base, :depth,
+		CURRENT_TIMESTAMP);`
+	_, err := me.db.NamedExec(cmd, snapshot)
+	return err
+}
+
+func (me *Sqlite3DataStore) FindSnapshot(key string) (*Snapshot, error) {
+	cmd := `SELECT * FROM [snapshot] WHERE [name] = ? OR ([commit] LIKE ? AND length(?) >= 7)
+		ORDER BY [name] = ? DESC, [id] DESC LIMIT 1;`
+	snapshot := new(Snapshot)
+	err := me.db.Get(snapshot, cmd, key, key+"%", key, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func (me *Sqlite3DataStore) GetSnapshot(platform, owner, repo, name string) (*Snapshot, error) {
+	cmd := `SELECT * FROM [snapshot] WHERE [platform] = ? AND [owner] = ? AND [repo] = ? AND [name] = ?;`
+	snapshot := new(Snapshot)
+	err := me.db.Get(snapshot, cmd, platform, owner, repo, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func (me *Sqlite3DataStore) LatestSnapshot(platform, owner, repo string) (*Snapshot, error) {
+	cmd := `SELECT * FROM [snapshot] WHERE [platform] = ? AND [owner] = ? AND [repo] = ?
+		ORDER BY [id] DESC LIMIT 1;`
+	snapshot := new(Snapshot)
+	err := me.db.Get(snapshot, cmd, platform, owner, repo)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func (me *Sqlite3DataStore) ListSnapshots(owner, repo string) ([]Snapshot, error) {
+	cmd := `SELECT * FROM [snapshot] WHERE [owner] = ? COLLATE NOCASE AND [repo] = ? COLLATE NOCASE
+		ORDER BY [id] DESC;`
+	var snapshots []Snapshot
+	err := me.db.Select(&snapshots, cmd, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+func (me *Sqlite3DataStore) ListSnapshotRepos() ([]SnapshotRepo, error) {
+	cmd := `SELECT [platform], [owner], [repo], COUNT(*) AS [snapshots] FROM [snapshot]
+		GROUP BY [platform], [owner], [repo] ORDER BY [platform], [owner], [repo];`
+	var repos []SnapshotRepo
+	err := me.db.Select(&repos, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return repos, nil
+}
@@ -0,0 +1,312 @@
+package delta
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 格式: magic, 基准文件名, 基准和目标的 SHA-256, 目标大小, 之后是 copy/add 指令, 以 end 结束
+
+const (
+	// Suffix 是保存差异文件时使用的后缀, 差异文件使用 xz 压缩
+	Suffix = ".delta.xz"
+
+	blockSize = 64
+
+	opCopy = 'C'
+	opAdd  = 'A'
+	opEnd  = 'E'
+)
+
+var (
+	magic = []byte("GTDELTA\x01")
+
+	ErrInvalidDelta     = errors.New("invalid delta")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+type Header struct {
+	Base       string
+	BaseSha256 string
+	Sha256     string
+	Size       int64
+}
+
+// Encode 计算从 base 到 target 的差异并写入 w, 使用 rsync 式的滚动哈希查找相同的块
+func Encode(header Header, base, target []byte, w io.Writer) error {
+	writer := bufio.NewWriter(w)
+	header.BaseSha256 = sha256Hex(base)
+	header.Sha256 = sha256Hex(target)
+	header.Size = int64(len(target))
+	err := writeHeader(writer, header)
+	if err != nil {
+		return err
+	}
+
+	index := indexBlocks(base)
+	literal := 0
+	i := 0
+	var hash rollingHash
+	if len(target) >= blockSize {
+		hash.init(target[:blockSize])
+	}
+	for i+blockSize <= len(target) {
+		offset, found := index[hash.sum()]
+		if found && bytes.Equal(base[offset:offset+blockSize], target[i:i+blockSize]) {
+			start, end := i, i+blockSize
+			baseStart := offset
+			for end < len(target) && baseStart+(end-start) < len(base) && base[baseStart+(end-start)] == target[end] {
+				end++
+			}
+			for start > literal && baseStart > 0 && base[baseStart-1] == target[start-1] {
+				start--
+				baseStart--
+			}
+
+			if start > literal {
+				err = writeAdd(writer, target[literal:start])
+				if err != nil {
+					return err
+				}
+			}
+			err = writeCopy(writer, int64(baseStart), int64(end-start))
+			if err != nil {
+				return err
+			}
+			literal = end
+			i = end
+			if i+blockSize <= len(target) {
+				hash.init(target[i : i+blockSize])
+			}
+			continue
+		}
+
+		if i+blockSize < len(target) {
+			hash.roll(target[i], target[i+blockSize])
+		}
+		i++
+	}
+
+	if literal < len(target) {
+		err = writeAdd(writer, target[literal:])
+		if err != nil {
+			return err
+		}
+	}
+	err = writer.WriteByte(opEnd)
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// indexBlocks 为 base 中按块对齐的位置建立索引, 相同哈希只保留第一个
+func indexBlocks(base []byte) map[uint32]int {
+	index := make(map[uint32]int, len(base)/blockSize+1)
+	var hash rollingHash
+	for offset := 0; offset+blockSize <= len(base); offset += blockSize {
+		hash.init(base[offset : offset+blockSize])
+		sum := hash.sum()
+		if _, found := index[sum]; !found {
+			index[sum] = offset
+		}
+	}
+	return index
+}
+
+// ReadHeader 读取差异文件的头部, r 之后停在第一条指令处
+func ReadHeader(r *bufio.Reader) (*Header, error) {
+	buf := make([]byte, len(magic))
+	_, err := io.ReadFull(r, buf)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(buf, magic) {
+		return nil, ErrInvalidDelta
+	}
+
+	header := new(Header)
+	base, err := readBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	header.Base = string(base)
+	baseSha, err := readBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	header.BaseSha256 = hex.EncodeToString(baseSha)
+	sha, err := readBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	header.Sha256 = hex.EncodeToString(sha)
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	header.Size = int64(size)
+	return header, nil
+}
+
+// Apply 在 base 上应用差异并写入 w, 校验结果的大小和 SHA-256
+func Apply(header *Header, base io.ReaderAt, r *bufio.Reader, w io.Writer) error {
+	hash := sha256.New()
+	writer := io.MultiWriter(w, hash)
+	var size int64
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case opCopy:
+			offset, err := binary.ReadUvarint(r)
+			if err != nil {
+				return err
+			}
+			length, err := binary.ReadUvarint(r)
+			if err != nil {
+				return err
+			}
+			n, err := io.Copy(writer, io.NewSectionReader(base, int64(offset), int64(length)))
+			if err != nil {
+				return err
+			}
+			if n != int64(length) {
+				return fmt.Errorf("%w: copy out of range", ErrInvalidDelta)
+			}
+			size += n
+
+		case opAdd:
+			length, err := binary.ReadUvarint(r)
+			if err != nil {
+				return err
+			}
+			n, err := io.CopyN(writer, r, int64(length))
+			if err != nil {
+				return err
+			}
+			size += n
+
+		case opEnd:
+			actual := hex.EncodeToString(hash.Sum(nil))
+			if size != header.Size || actual != header.Sha256 {
+				return fmt.Errorf("%w: expected %s, got %s", ErrChecksumMismatch, header.Sha256, actual)
+			}
+			return nil
+
+		default:
+			return fmt.Errorf("%w: unknown op %q", ErrInvalidDelta, op)
+		}
+	}
+}
+
+func writeHeader(w *bufio.Writer, header Header) error {
+	baseSha, err := hex.DecodeString(header.BaseSha256)
+	if err != nil {
+		return err
+	}
+	sha, err := hex.DecodeString(header.Sha256)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(magic)
+	if err != nil {
+		return err
+	}
+	for _, item := range [][]byte{[]byte(header.Base), baseSha, sha} {
+		err = writeBytes(w, item)
+		if err != nil {
+			return err
+		}
+	}
+	return writeUvarint(w, uint64(header.Size))
+}
+
+func writeCopy(w *bufio.Writer, offset, length int64) error {
+	err := w.WriteByte(opCopy)
+	if err != nil {
+		return err
+	}
+	err = writeUvarint(w, uint64(offset))
+	if err != nil {
+		return err
+	}
+	return writeUvarint(w, uint64(length))
+}
+
+func writeAdd(w *bufio.Writer, data []byte) error {
+	err := w.WriteByte(opAdd)
+	if err != nil {
+		return err
+	}
+	err = writeUvarint(w, uint64(len(data)))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func writeBytes(w *bufio.Writer, data []byte) error {
+	err := writeUvarint(w, uint64(len(data)))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func readBytes(r *bufio.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > 4096 {
+		return nil, ErrInvalidDelta
+	}
+	data := make([]byte, length)
+	_, err = io.ReadFull(r, data)
+	return data, err
+}
+
+func writeUvarint(w *bufio.Writer, value uint64) error {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, value)
+	_, err := w.Write(buf[:n])
+	return err
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// rollingHash 是 rsync 使用的弱校验和, 可以在窗口移动一个字节时增量更新
+type rollingHash struct {
+	a, b uint32
+}
+
+func (me *rollingHash) init(block []byte) {
+	me.a, me.b = 0, 0
+	for i, c := range block {
+		me.a += uint32(c)
+		me.b += uint32(len(block)-i) * uint32(c)
+	}
+}
+
+func (me *rollingHash) roll(out, in byte) {
+	me.a = me.a - uint32(out) + uint32(in)
+	me.b = me.b - blockSize*uint32(out) + me.a
+}
+
+func (me *rollingHash) sum() uint32 {
+	return me.a&0xffff | me.b<<16
+}
@@ -0,0 +1,151 @@
+package delta
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func randomBytes(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func encode(t *testing.T, base, target []byte) []byte {
+	var buf bytes.Buffer
+	err := Encode(Header{Base: "base.tar.xz"}, base, target, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func apply(base, encoded []byte) ([]byte, error) {
+	reader := bufio.NewReader(bytes.NewReader(encoded))
+	header, err := ReadHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	var output bytes.Buffer
+	err = Apply(header, bytes.NewReader(base), reader, &output)
+	if err != nil {
+		return nil, err
+	}
+	return output.Bytes(), nil
+}
+
+func TestRoundTrip(t *testing.T) {
+	base := randomBytes(1, 64*1024)
+	cases := []struct {
+		name   string
+		base   []byte
+		target []byte
+		// maxSize 限制差异的大小, 确认相同的块被复制而不是重新写入, 0 表示不检查
+		maxSize int
+	}{
+		{"insertion", base, concat(base[:10000], randomBytes(2, 300), base[10000:]), 1024},
+		{"deletion", base, concat(base[:10000], base[20000:]), 512},
+		{"shifted blocks", base, concat(base[40000:], []byte("x"), base[:40000]), 512},
+		{"unaligned shift", base, concat([]byte("abc"), base), 512},
+		{"empty base", nil, randomBytes(3, 1000), 0},
+		{"empty target", base, nil, 0},
+		{"both empty", nil, nil, 0},
+		{"target smaller than block", base, base[100:130], 0},
+		{"base smaller than block", base[:30], base[:100], 0},
+		{"base equals target", base, base, 512},
+		{"unrelated", randomBytes(4, 5000), randomBytes(5, 5000), 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			encoded := encode(t, c.base, c.target)
+			output, err := apply(c.base, encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(output, c.target) {
+				t.Fatalf("got %d bytes, expected %d", len(output), len(c.target))
+			}
+			if c.maxSize > 0 && len(encoded) > c.maxSize {
+				t.Errorf("delta is %d bytes, expected at most %d", len(encoded), c.maxSize)
+			}
+		})
+	}
+}
+
+func TestHeader(t *testing.T) {
+	base := randomBytes(1, 1000)
+	target := concat(base, []byte("tail"))
+	reader := bufio.NewReader(bytes.NewReader(encode(t, base, target)))
+	header, err := ReadHeader(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Header{Base: "base.tar.xz", BaseSha256: sha256Hex(base), Sha256: sha256Hex(target), Size: 1004}
+	if *header != expected {
+		t.Errorf("got %+v, expected %+v", *header, expected)
+	}
+}
+
+func TestApplyInvalidDelta(t *testing.T) {
+	base := randomBytes(1, 8*1024)
+	target := concat(base[:4000], randomBytes(2, 100), base[4000:])
+	encoded := encode(t, base, target)
+
+	reader := bufio.NewReader(bytes.NewReader(encoded))
+	_, err := ReadHeader(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headerSize := len(encoded) - reader.Buffered()
+
+	// 跳过 copy 指令找到第一条 add 指令, 修改其数据中的一个字节
+	addAt := headerSize
+	for encoded[addAt] == opCopy {
+		_, offsetSize := binary.Uvarint(encoded[addAt+1:])
+		_, lengthSize := binary.Uvarint(encoded[addAt+1+offsetSize:])
+		addAt += 1 + offsetSize + lengthSize
+	}
+	if encoded[addAt] != opAdd {
+		t.Fatalf("unexpected op %q", encoded[addAt])
+	}
+	corrupt := bytes.Clone(encoded)
+	corrupt[addAt+4] ^= 0xff
+
+	unknownOp := bytes.Clone(encoded)
+	unknownOp[headerSize] = 'X'
+
+	cases := []struct {
+		name    string
+		base    []byte
+		encoded []byte
+		err     error
+	}{
+		{"bad magic", base, append([]byte("NOTDELTA"), encoded[8:]...), ErrInvalidDelta},
+		{"unknown op", base, unknownOp, ErrInvalidDelta},
+		{"corrupt data", base, corrupt, ErrChecksumMismatch},
+		{"wrong base", randomBytes(9, len(base)), encoded, ErrChecksumMismatch},
+		{"short base", base[:1000], encoded, ErrInvalidDelta},
+		{"truncated header", base, encoded[:headerSize-3], nil},
+		{"truncated ops", base, encoded[:len(encoded)-1], nil},
+		{"truncated add", base, encoded[:addAt+10], nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := apply(c.base, c.encoded)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if c.err != nil && !errors.Is(err, c.err) {
+				t.Errorf("got %v, expected %v", err, c.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Op 是对比结果中的一行
+type Op struct {
+	Kind byte
+	Line string
+}
+
+const (
+	Equal  = ' '
+	Delete = '-'
+	Insert = '+'
+
+	// 搜索的编辑距离超过该值时放弃最短结果, 整段视为替换, 避免差异很大的文件耗时过长
+	maxEditCost = 4096
+)
+
+// Lines 用 Myers 算法对比两组行, 返回最短的编辑序列, 同一处修改先删除后插入
+func Lines(a, b []string) []Op {
+	ids := make(map[string]int)
+	toIds := func(lines []string) []int {
+		result := make([]int, len(lines))
+		for i, line := range lines {
+			id, found := ids[line]
+			if !found {
+				id = len(ids)
+				ids[line] = id
+			}
+			result[i] = id
+		}
+		return result
+	}
+
+	d := &differ{
+		a:       toIds(a),
+		b:       toIds(b),
+		deleted: make([]bool, len(a)),
+		added:   make([]bool, len(b)),
+	}
+	d.compare(0, len(a), 0, len(b))
+
+	ops := make([]Op, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && d.deleted[i]:
+			ops = append(ops, Op{Kind: Delete, Line: a[i]})
+			i++
+		case j < len(b) && d.added[j]:
+			ops = append(ops, Op{Kind: Insert, Line: b[j]})
+			j++
+		default:
+			ops = append(ops, Op{Kind: Equal, Line: a[i]})
+			i++
+			j++
+		}
+	}
+	return ops
+}
+
+type differ struct {
+	a, b    []int
+	deleted []bool
+	added   []bool
+}
+
+// compare 去掉相同的头尾后从中间的 snake 拆分, 递归对比两边, 只需要线性的空间
+func (me *differ) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && me.a[aLo] == me.b[bLo] {
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && me.a[aHi-1] == me.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+	if aLo == aHi || bLo == bHi {
+		for i := aLo; i < aHi; i++ {
+			me.deleted[i] = true
+		}
+		for j := bLo; j < bHi; j++ {
+			me.added[j] = true
+		}
+		return
+	}
+
+	x, y, found := me.bisect(aLo, aHi, bLo, bHi)
+	if !found {
+		for i := aLo; i < aHi; i++ {
+			me.deleted[i] = true
+		}
+		for j := bLo; j < bHi; j++ {
+			me.added[j] = true
+		}
+		return
+	}
+	me.compare(aLo, x, bLo, y)
+	me.compare(x, aHi, y, bHi)
+}
+
+// bisect 同时从两端搜索, 返回正反两条路径重叠的位置
+func (me *differ) bisect(aLo, aHi, bLo, bHi int) (int, int, bool) {
+	a, b := me.a[aLo:aHi], me.b[bLo:bHi]
+	n, m := len(a), len(b)
+	maxD := (n + m + 1) / 2
+	offset := maxD
+	length := 2*maxD + 2
+	forward := make([]int, length)
+	backward := make([]int, length)
+	for i := range forward {
+		forward[i] = -1
+		backward[i] = -1
+	}
+	forward[offset+1] = 0
+	backward[offset+1] = 0
+
+	delta := n - m
+	front := delta%2 != 0
+	k1Start, k1End, k2Start, k2End := 0, 0, 0, 0
+	for d := 0; d < min(maxD, maxEditCost); d++ {
+		for k1 := -d + k1Start; k1 <= d-k1End; k1 += 2 {
+			index := offset + k1
+			var x1 int
+			if k1 == -d || (k1 != d && forward[index-1] < forward[index+1]) {
+				x1 = forward[index+1]
+			} else {
+				x1 = forward[index-1] + 1
+			}
+			y1 := x1 - k1
+			for x1 < n && y1 < m && a[x1] == b[y1] {
+				x1++
+				y1++
+			}
+			forward[index] = x1
+			if x1 > n {
+				k1End += 2
+			} else if y1 > m {
+				k1Start += 2
+			} else if front {
+				index2 := offset + delta - k1
+				if index2 >= 0 && index2 < length && backward[index2] != -1 && x1 >= n-backward[index2] {
+					return aLo + x1, bLo + y1, true
+				}
+			}
+		}
+
+		for k2 := -d + k2Start; k2 <= d-k2End; k2 += 2 {
+			index := offset + k2
+			var x2 int
+			if k2 == -d || (k2 != d && backward[index-1] < backward[index+1]) {
+				x2 = backward[index+1]
+			} else {
+				x2 = backward[index-1] + 1
+			}
+			y2 := x2 - k2
+			for x2 < n && y2 < m && a[n-x2-1] == b[m-y2-1] {
+				x2++
+				y2++
+			}
+			backward[index] = x2
+			if x2 > n {
+				k2End += 2
+			} else if y2 > m {
+				k2Start += 2
+			} else if !front {
+				index1 := offset + delta - k2
+				if index1 >= 0 && index1 < length && forward[index1] != -1 {
+					x1 := forward[index1]
+					y1 := offset + x1 - index1
+					if x1 >= n-x2 {
+						return aLo + x1, bLo + y1, true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// SplitLines 按行拆分, 保留每行的换行符, 以便区分文件末尾是否有换行
+func SplitLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// IsText 判断内容是否为文本, 含有 NUL 字符的视为二进制
+func IsText(content []byte) bool {
+	if len(content) > 8000 {
+		content = content[:8000]
+	}
+	return bytes.IndexByte(content, 0) < 0
+}
+
+// Unified 生成 unified 格式的差异, 内容相同时返回空字符串
+func Unified(aName, bName string, a, b []byte, context int) string {
+	ops := Lines(SplitLines(a), SplitLines(b))
+
+	var out strings.Builder
+	// 每个 op 对应的行号, 从 1 开始
+	aLines := make([]int, len(ops)+1)
+	bLines := make([]int, len(ops)+1)
+	aLine, bLine := 1, 1
+	for i, op := range ops {
+		aLines[i], bLines[i] = aLine, bLine
+		if op.Kind != Insert {
+			aLine++
+		}
+		if op.Kind != Delete {
+			bLine++
+		}
+	}
+	aLines[len(ops)], bLines[len(ops)] = aLine, bLine
+
+	for start := 0; start < len(ops); {
+		// 找到下一处修改, 向前后扩展 context 行, 间隔不超过 2*context 的修改合并为一个 hunk
+		first := start
+		for first < len(ops) && ops[first].Kind == Equal {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		last := first
+		for i := first; i < len(ops); i++ {
+			if ops[i].Kind != Equal {
+				last = i
+				continue
+			}
+			if i-last > 2*context {
+				break
+			}
+		}
+		hunkStart := max(first-context, start)
+		hunkEnd := min(last+context+1, len(ops))
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", aName, bName)
+		}
+		aCount, bCount := 0, 0
+		for _, op := range ops[hunkStart:hunkEnd] {
+			if op.Kind != Insert {
+				aCount++
+			}
+			if op.Kind != Delete {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n",
+			hunkRange(aLines[hunkStart], aCount), hunkRange(bLines[hunkStart], bCount))
+		for _, op := range ops[hunkStart:hunkEnd] {
+			out.WriteByte(op.Kind)
+			out.WriteString(op.Line)
+			if !strings.HasSuffix(op.Line, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		start = hunkEnd
+	}
+	return out.String()
+}
+
+// hunkRange 格式化 hunk 的行范围, 没有行时起始位置为前一行
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
@@ -1,7 +1,80 @@
 package fslock
 
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type Mode int
+
+const (
+	// Exclusive 同一时间只能有一个持有者
+	Exclusive Mode = iota
+	// Shared 可以同时有多个持有者, 与 Exclusive 互斥
+	Shared
+)
+
+// Lock 的 Lock 和 TryLock 获取排他锁
 type Lock interface {
 	Lock() error
 	TryLock() error
+	// LockContext 阻塞直到获得锁或 ctx 结束, ctx 结束时返回 *LockedError
+	LockContext(ctx context.Context, mode Mode) error
 	Unlock() error
 }
+
+// Owner 记录在锁文件中的持有者, 只有排他锁会记录
+type Owner struct {
+	Pid  int
+	Host string
+}
+
+func (o *Owner) String() string {
+	return fmt.Sprintf("pid %d on %s", o.Pid, o.Host)
+}
+
+func parseOwner(content string) *Owner {
+	fields := strings.Fields(content)
+	if len(fields) != 2 {
+		return nil
+	}
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil
+	}
+	return &Owner{Pid: pid, Host: fields[1]}
+}
+
+func formatOwner(owner *Owner) string {
+	return fmt.Sprintf("%d %s\n", owner.Pid, owner.Host)
+}
+
+// LockedError 表示锁被其它进程持有
+type LockedError struct {
+	Filename string
+	Owner    *Owner
+	// Stale 为 true 表示记录的持有者进程已经不存在, 锁可能被其子进程继承
+	Stale bool
+	Err   error
+}
+
+func (e *LockedError) Error() string {
+	msg := "locked: " + e.Filename
+	if e.Owner != nil {
+		msg += fmt.Sprintf(" (held by %s", e.Owner)
+		if e.Stale {
+			msg += ", process is gone"
+		}
+		msg += ")"
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *LockedError) Unwrap() error {
+	return e.Err
+}
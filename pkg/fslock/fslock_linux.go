@@ -1,54 +1,174 @@
 package fslock
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"os"
 	"syscall"
+	"time"
+)
+
+const (
+	pollInterval = 100 * time.Millisecond
 )
 
 type fsLock struct {
 	filename string
 	fd       int
+	locked   bool
+	mode     Mode
 }
 
 func New(filename string) Lock {
-	return &fsLock{filename: filename}
+	return &fsLock{filename: filename, fd: -1}
 }
 
 func (l *fsLock) Lock() error {
-	if err := l.open(); err != nil {
-		return err
-	}
-	return syscall.Flock(l.fd, syscall.LOCK_EX)
+	return l.acquire(Exclusive, true)
 }
 
 func (l *fsLock) TryLock() error {
-	if err := l.open(); err != nil {
-		return err
+	return l.acquire(Exclusive, false)
+}
+
+func (l *fsLock) LockContext(ctx context.Context, mode Mode) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		err := l.acquire(mode, false)
+		var lockedErr *LockedError
+		if !errors.As(err, &lockedErr) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			lockedErr.Err = ctx.Err()
+			return lockedErr
+		case <-ticker.C:
+		}
+	}
+}
+
+// acquire 获得锁后检查锁文件是否仍是打开的那个, 解锁的进程可能在此期间删除了它
+func (l *fsLock) acquire(mode Mode, block bool) error {
+	if l.locked {
+		return errors.New("already locked: " + l.filename)
+	}
+
+	how := syscall.LOCK_EX
+	if mode == Shared {
+		how = syscall.LOCK_SH
+	}
+	if !block {
+		how |= syscall.LOCK_NB
+	}
+
+	for {
+		fd, err := syscall.Open(l.filename, syscall.O_CREAT|syscall.O_RDWR|syscall.O_CLOEXEC, 0600)
+		if err != nil {
+			return err
+		}
+
+		err = flock(fd, how)
+		if err == syscall.EWOULDBLOCK {
+			_ = syscall.Close(fd)
+			return l.lockedError()
+		}
+		if err != nil {
+			_ = syscall.Close(fd)
+			return err
+		}
+
+		same, err := sameFile(fd, l.filename)
+		if err != nil {
+			_ = syscall.Close(fd)
+			return err
+		}
+		if !same {
+			_ = syscall.Close(fd)
+			continue
+		}
+
+		l.fd = fd
+		l.locked = true
+		l.mode = mode
+		l.writeOwner()
+		return nil
 	}
-	err := syscall.Flock(l.fd, syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+func flock(fd int, how int) error {
+	for {
+		err := syscall.Flock(fd, how)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+func sameFile(fd int, filename string) (bool, error) {
+	var opened, current syscall.Stat_t
+	err := syscall.Fstat(fd, &opened)
 	if err != nil {
-		_ = syscall.Close(l.fd)
+		return false, err
+	}
+	err = syscall.Stat(filename, &current)
+	if err == syscall.ENOENT {
+		return false, nil
 	}
-	if err == syscall.EWOULDBLOCK {
-		return fmt.Errorf("locked: %s", l.filename)
+	if err != nil {
+		return false, err
 	}
-	return err
+	return opened.Dev == current.Dev && opened.Ino == current.Ino, nil
 }
 
-func (l *fsLock) open() error {
-	fd, err := syscall.Open(l.filename, syscall.O_CREAT|syscall.O_RDONLY, 0600)
+// writeOwner 记录持有者, 共享锁有多个持有者, 只清空上一个排他锁的记录, 失败不影响锁本身
+func (l *fsLock) writeOwner() {
+	if syscall.Ftruncate(l.fd, 0) != nil || l.mode == Shared {
+		return
+	}
+	host, _ := os.Hostname()
+	content := []byte(formatOwner(&Owner{Pid: os.Getpid(), Host: host}))
+	_, _ = syscall.Pwrite(l.fd, content, 0)
+}
+
+func (l *fsLock) lockedError() *LockedError {
+	lockedErr := &LockedError{Filename: l.filename}
+	content, err := os.ReadFile(l.filename)
 	if err != nil {
-		return err
+		return lockedErr
+	}
+	lockedErr.Owner = parseOwner(string(content))
+	if lockedErr.Owner != nil {
+		host, _ := os.Hostname()
+		lockedErr.Stale = lockedErr.Owner.Host == host && !processAlive(lockedErr.Owner.Pid)
 	}
-	l.fd = fd
-	return nil
+	return lockedErr
 }
 
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// Unlock 在仍持有排他锁时删除文件, 其它等待者获得锁后会发现文件已被替换并重新打开.
+// 共享锁的其它持有者可能还在使用文件, 只释放不删除
 func (l *fsLock) Unlock() error {
+	if !l.locked {
+		return nil
+	}
+	var removeErr error
+	if l.mode == Exclusive {
+		removeErr = os.Remove(l.filename)
+		if os.IsNotExist(removeErr) {
+			removeErr = nil
+		}
+	}
 	err := syscall.Close(l.fd)
+	l.fd = -1
+	l.locked = false
 	if err != nil {
 		return err
 	}
-	return os.Remove(l.filename)
+	return removeErr
 }
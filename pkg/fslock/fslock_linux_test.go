@@ -0,0 +1,355 @@
+package fslock
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	helperActionEnv = "GITAR_FSLOCK_HELPER"
+	helperFileEnv   = "GITAR_FSLOCK_FILE"
+)
+
+// TestMain 在设置了 GITAR_FSLOCK_HELPER 时作为子进程运行, 用于跨进程的测试
+func TestMain(m *testing.M) {
+	if action := os.Getenv(helperActionEnv); action != "" {
+		os.Exit(runHelper(action, os.Getenv(helperFileEnv)))
+	}
+	os.Exit(m.Run())
+}
+
+func runHelper(action, filename string) int {
+	lock := New(filename)
+	switch action {
+	case "try":
+		// 输出持有者的 pid, 获得锁时输出 ok
+		err := lock.TryLock()
+		var lockedErr *LockedError
+		if errors.As(err, &lockedErr) && lockedErr.Owner != nil {
+			fmt.Printf("locked %d\n", lockedErr.Owner.Pid)
+			return 0
+		}
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		fmt.Println("ok")
+		if lock.Unlock() != nil {
+			return 1
+		}
+		return 0
+
+	case "hold":
+		// 持有锁直到标准输入关闭
+		if lock.Lock() != nil {
+			return 1
+		}
+		fmt.Println("held")
+		_, _ = io.Copy(io.Discard, os.Stdin)
+		if lock.Unlock() != nil {
+			return 1
+		}
+		return 0
+
+	case "share":
+		// 持有共享锁直到标准输入关闭
+		if lock.LockContext(context.Background(), Shared) != nil {
+			return 1
+		}
+		fmt.Println("held")
+		_, _ = io.Copy(io.Discard, os.Stdin)
+		if lock.Unlock() != nil {
+			return 1
+		}
+		return 0
+
+	case "exit":
+		// 不解锁直接退出, 锁文件留在原处
+		if lock.Lock() != nil {
+			return 1
+		}
+		return 0
+
+	case "count":
+		// 在锁内对文件旁的计数器加一, 锁不互斥时会丢失更新
+		counter := filename + ".count"
+		for i := 0; i < 50; i++ {
+			if lock.Lock() != nil {
+				return 1
+			}
+			content, _ := os.ReadFile(counter)
+			n, _ := strconv.Atoi(strings.TrimSpace(string(content)))
+			if os.WriteFile(counter, []byte(strconv.Itoa(n+1)), 0600) != nil {
+				return 1
+			}
+			if lock.Unlock() != nil {
+				return 1
+			}
+		}
+		return 0
+	}
+	return 2
+}
+
+func helperCommand(action, filename string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(), helperActionEnv+"="+action, helperFileEnv+"="+filename)
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+func runHelperOutput(t *testing.T, action, filename string) string {
+	output, err := helperCommand(action, filename).Output()
+	if err != nil {
+		t.Fatalf("helper %s: %v: %s", action, err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// startHolder 启动持有锁的子进程, 返回的函数关闭其标准输入使其解锁退出
+func startHolder(t *testing.T, action, filename string) (*exec.Cmd, func()) {
+	cmd := helperCommand(action, filename)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cmd.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = stdin.Close()
+		_ = cmd.Wait()
+	})
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil || line != "held\n" {
+		t.Fatalf("helper %s did not lock: %q %v", action, line, err)
+	}
+	return cmd, func() {
+		_ = stdin.Close()
+	}
+}
+
+func assertNoLockFile(t *testing.T, filename string) {
+	_, err := os.Stat(filename)
+	if !os.IsNotExist(err) {
+		t.Errorf("lock file should be removed after unlock, stat: %v", err)
+	}
+}
+
+func TestLockIsExclusiveAcrossProcesses(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.lock")
+	lock := New(filename)
+	err := lock.Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := runHelperOutput(t, "try", filename)
+	if output != fmt.Sprintf("locked %d", os.Getpid()) {
+		t.Errorf("child should see the lock held by %d, got %q", os.Getpid(), output)
+	}
+
+	err = lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNoLockFile(t, filename)
+
+	output = runHelperOutput(t, "try", filename)
+	if output != "ok" {
+		t.Errorf("child should get the lock after unlock, got %q", output)
+	}
+	assertNoLockFile(t, filename)
+}
+
+func TestLockContextWaitsForOtherProcess(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.lock")
+	cmd := helperCommand("hold", filename)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cmd.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = stdin.Close()
+		_ = cmd.Wait()
+	}()
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil || line != "held\n" {
+		t.Fatalf("helper did not lock: %q %v", line, err)
+	}
+
+	lock := New(filename)
+	err = lock.TryLock()
+	var lockedErr *LockedError
+	if !errors.As(err, &lockedErr) {
+		t.Fatalf("expected LockedError, got %v", err)
+	}
+	if lockedErr.Owner == nil || lockedErr.Owner.Pid != cmd.Process.Pid || lockedErr.Stale {
+		t.Errorf("unexpected owner: %+v", lockedErr)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	err = lock.LockContext(ctx, Exclusive)
+	cancel()
+	if !errors.As(err, &lockedErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+
+	time.AfterFunc(300*time.Millisecond, func() {
+		_ = stdin.Close()
+	})
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = lock.LockContext(ctx, Exclusive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cmd.Wait()
+	if err != nil {
+		t.Fatalf("helper failed: %v", err)
+	}
+	err = lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNoLockFile(t, filename)
+}
+
+func TestLockAfterOwnerExited(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.lock")
+	runHelperOutput(t, "exit", filename)
+
+	// 进程退出时锁已经释放, 残留的锁文件不影响加锁
+	lock := New(filename)
+	err := lock.TryLock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := parseOwner(string(content))
+	if owner == nil || owner.Pid != os.Getpid() {
+		t.Errorf("lock file should record the new owner, got %q", content)
+	}
+	err = lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNoLockFile(t, filename)
+}
+
+func TestLockNoLostUpdates(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.lock")
+	const processes = 4
+	var cmds []*exec.Cmd
+	for i := 0; i < processes; i++ {
+		cmd := helperCommand("count", filename)
+		err := cmd.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmds = append(cmds, cmd)
+	}
+	for _, cmd := range cmds {
+		err := cmd.Wait()
+		if err != nil {
+			t.Fatalf("helper failed: %v", err)
+		}
+	}
+
+	content, err := os.ReadFile(filename + ".count")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != strconv.Itoa(processes*50) {
+		t.Errorf("counter is %s, expected %d", content, processes*50)
+	}
+	assertNoLockFile(t, filename)
+}
+
+func TestSharedLocksCoexistAndBlockExclusive(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.lock")
+	first, releaseFirst := startHolder(t, "share", filename)
+	second, releaseSecond := startHolder(t, "share", filename)
+
+	// 共享锁之间不互斥, 本进程也能再获得一个
+	shared := New(filename)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	err := shared.LockContext(ctx, Shared)
+	cancel()
+	if err != nil {
+		t.Fatalf("shared lock should coexist: %v", err)
+	}
+	err = shared.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(filename)
+	if err != nil {
+		t.Fatalf("shared unlock must keep the lock file for other holders: %v", err)
+	}
+
+	exclusive := New(filename)
+	var lockedErr *LockedError
+	if err := exclusive.TryLock(); !errors.As(err, &lockedErr) {
+		t.Fatalf("expected LockedError, got %v", err)
+	}
+
+	acquired := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		acquired <- exclusive.LockContext(ctx, Exclusive)
+	}()
+
+	releaseFirst()
+	err = first.Wait()
+	if err != nil {
+		t.Fatalf("helper failed: %v", err)
+	}
+	select {
+	case err := <-acquired:
+		t.Fatalf("exclusive lock acquired while a shared holder remains: %v", err)
+	case <-time.After(3 * pollInterval):
+	}
+
+	releaseSecond()
+	err = second.Wait()
+	if err != nil {
+		t.Fatalf("helper failed: %v", err)
+	}
+	err = <-acquired
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = exclusive.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNoLockFile(t, filename)
+}
@@ -1,7 +1,25 @@
 package fslock
 
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	pollInterval = 100 * time.Millisecond
+
+	// 锁定文件内容之外的区域, Windows 的锁是强制的, 锁定内容会使其它进程无法读取持有者
+	lockOffsetHigh = 1
+)
+
 type fsLock struct {
 	filename string
+	file     *os.File
+	mode     Mode
 }
 
 func New(filename string) Lock {
@@ -9,13 +27,113 @@ func New(filename string) Lock {
 }
 
 func (l *fsLock) Lock() error {
-	return nil
+	return l.acquire(Exclusive, true)
 }
 
 func (l *fsLock) TryLock() error {
+	return l.acquire(Exclusive, false)
+}
+
+func (l *fsLock) LockContext(ctx context.Context, mode Mode) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		err := l.acquire(mode, false)
+		var lockedErr *LockedError
+		if !errors.As(err, &lockedErr) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			lockedErr.Err = ctx.Err()
+			return lockedErr
+		case <-ticker.C:
+		}
+	}
+}
+
+// acquire 打开的文件不允许删除, 锁文件会一直保留, 不存在解锁时删除文件的竞争
+func (l *fsLock) acquire(mode Mode, block bool) error {
+	if l.file != nil {
+		return errors.New("already locked: " + l.filename)
+	}
+
+	file, err := os.OpenFile(l.filename, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+
+	var flags uint32
+	if mode == Exclusive {
+		flags |= windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+	if !block {
+		flags |= windows.LOCKFILE_FAIL_IMMEDIATELY
+	}
+	overlapped := &windows.Overlapped{OffsetHigh: lockOffsetHigh}
+	err = windows.LockFileEx(windows.Handle(file.Fd()), flags, 0, 1, 0, overlapped)
+	if err == windows.ERROR_LOCK_VIOLATION {
+		_ = file.Close()
+		return l.lockedError()
+	}
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	l.file = file
+	l.mode = mode
+	l.writeOwner()
 	return nil
 }
 
+// writeOwner 记录持有者, 共享锁有多个持有者, 只清空上一个排他锁的记录, 失败不影响锁本身
+func (l *fsLock) writeOwner() {
+	if l.file.Truncate(0) != nil || l.mode == Shared {
+		return
+	}
+	host, _ := os.Hostname()
+	_, _ = l.file.WriteAt([]byte(formatOwner(&Owner{Pid: os.Getpid(), Host: host})), 0)
+}
+
+func (l *fsLock) lockedError() *LockedError {
+	lockedErr := &LockedError{Filename: l.filename}
+	content, err := os.ReadFile(l.filename)
+	if err != nil {
+		return lockedErr
+	}
+	lockedErr.Owner = parseOwner(string(content))
+	if lockedErr.Owner != nil {
+		host, _ := os.Hostname()
+		lockedErr.Stale = lockedErr.Owner.Host == host && !processAlive(lockedErr.Owner.Pid)
+	}
+	return lockedErr
+}
+
+func processAlive(pid int) bool {
+	const stillActive = 259
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return err == windows.ERROR_ACCESS_DENIED
+	}
+	defer func(handle windows.Handle) {
+		_ = windows.CloseHandle(handle)
+	}(handle)
+	var code uint32
+	err = windows.GetExitCodeProcess(handle, &code)
+	return err != nil || code == stillActive
+}
+
 func (l *fsLock) Unlock() error {
-	return nil
+	if l.file == nil {
+		return nil
+	}
+	overlapped := &windows.Overlapped{OffsetHigh: lockOffsetHigh}
+	err := windows.UnlockFileEx(windows.Handle(l.file.Fd()), 0, 1, 0, overlapped)
+	closeErr := l.file.Close()
+	l.file = nil
+	if err != nil {
+		return err
+	}
+	return closeErr
 }
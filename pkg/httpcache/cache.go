@@ -0,0 +1,180 @@
+package httpcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	HeaderCache = "X-Gitar-Cache"
+)
+
+type entry struct {
+	Url     string      `json:"url"`
+	Status  int         `json:"status"`
+	Header  http.Header `json:"header"`
+	Body    []byte      `json:"body"`
+	Updated time.Time   `json:"updated"`
+}
+
+// Transport 是基于磁盘的 HTTP 响应缓存, 在 TTL 内直接返回缓存,
+// 过期后使用 If-None-Match / If-Modified-Since 重新验证
+type Transport struct {
+	dir  string
+	ttl  time.Duration
+	next http.RoundTripper
+}
+
+func NewTransport(dir string, ttl time.Duration, next http.RoundTripper) *Transport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Transport{
+		dir:  dir,
+		ttl:  ttl,
+		next: next,
+	}
+}
+
+func (me *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return me.next.RoundTrip(req)
+	}
+
+	key := me.key(req)
+	cached, err := me.load(key)
+	if err != nil {
+		logrus.Debugf("HTTP cache: %s", err.Error())
+	}
+
+	if cached != nil && time.Since(cached.Updated) < me.ttl {
+		logrus.Debugf("HTTP cache hit: %s", req.URL)
+		return cached.response(req, "HIT"), nil
+	}
+
+	if cached != nil {
+		req = req.Clone(req.Context())
+		if etag := cached.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if modified := cached.Header.Get("Last-Modified"); modified != "" {
+			req.Header.Set("If-Modified-Since", modified)
+		}
+	}
+
+	resp, err := me.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		logrus.Debugf("HTTP cache revalidated: %s", req.URL)
+
+		for name, values := range resp.Header {
+			cached.Header[name] = values
+		}
+		cached.Updated = time.Now()
+		me.save(key, cached)
+		return cached.response(req, "REVALIDATED"), nil
+	}
+
+	if resp.StatusCode != http.StatusOK ||
+		resp.Header.Get("ETag") == "" && resp.Header.Get("Last-Modified") == "" && me.ttl <= 0 {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	me.save(key, &entry{
+		Url:     req.URL.String(),
+		Status:  resp.StatusCode,
+		Header:  resp.Header,
+		Body:    body,
+		Updated: time.Now(),
+	})
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// key 由地址, Accept 和 Authorization 决定, 不同凭据看到的响应可能不同, 不能共用缓存
+func (me *Transport) key(req *http.Request) string {
+	hash := sha256.New()
+	hash.Write([]byte(req.URL.String()))
+	hash.Write([]byte{0})
+	hash.Write([]byte(req.Header.Get("Accept")))
+	hash.Write([]byte{0})
+	hash.Write([]byte(req.Header.Get("Authorization")))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (me *Transport) path(key string) string {
+	return filepath.Join(me.dir, key[:2], key+".json")
+}
+
+func (me *Transport) load(key string) (*entry, error) {
+	data, err := os.ReadFile(me.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	cached := new(entry)
+	err = json.Unmarshal(data, cached)
+	if err != nil {
+		return nil, err
+	}
+	return cached, nil
+}
+
+func (me *Transport) save(key string, cached *entry) {
+	data, err := json.Marshal(cached)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(me.path(key)), os.ModePerm)
+	}
+	if err == nil {
+		err = os.WriteFile(me.path(key), data, 0644)
+	}
+	if err != nil {
+		logrus.Warnf("HTTP cache: %s", err.Error())
+	}
+}
+
+func (me *entry) response(req *http.Request, status string) *http.Response {
+	header := http.Header{}
+	for name, values := range me.Header {
+		// 缓存中的限流信息已过时, 不能让客户端据此拒绝请求
+		if strings.HasPrefix(strings.ToLower(name), "x-ratelimit-") && status == "HIT" {
+			continue
+		}
+		header[name] = values
+	}
+	header.Set(HeaderCache, status)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", me.Status, http.StatusText(me.Status)),
+		StatusCode:    me.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(me.Body)),
+		ContentLength: int64(len(me.Body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,146 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testServer 返回带 ETag 的响应, 请求带有相同的 If-None-Match 时返回 304
+type testServer struct {
+	*httptest.Server
+	requests []*http.Request
+	etag     string
+	body     string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	server := &testServer{etag: `"v1"`, body: "first"}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.requests = append(server.requests, r)
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(100-len(server.requests)))
+		if r.Header.Get("If-None-Match") == server.etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", server.etag)
+		_, _ = w.Write([]byte(server.body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (me *testServer) get(t *testing.T, transport *Transport, method, authorization string) (*http.Response, string) {
+	req, err := http.NewRequest(method, me.URL+"/repos/owner/repo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(resp.Body)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func TestFreshHitSendsNoRequest(t *testing.T) {
+	server := newTestServer(t)
+	transport := NewTransport(t.TempDir(), time.Hour, nil)
+
+	server.get(t, transport, http.MethodGet, "")
+	resp, body := server.get(t, transport, http.MethodGet, "")
+	if len(server.requests) != 1 {
+		t.Errorf("expected 1 request, got %d", len(server.requests))
+	}
+	if body != "first" || resp.Header.Get(HeaderCache) != "HIT" {
+		t.Errorf("got %q (%s)", body, resp.Header.Get(HeaderCache))
+	}
+	// 缓存中的限流信息已经过时, 不能返回给客户端
+	if resp.Header.Get("X-RateLimit-Remaining") != "" {
+		t.Errorf("rate limit header replayed from cache: %s", resp.Header.Get("X-RateLimit-Remaining"))
+	}
+}
+
+func TestExpiredEntryIsRevalidated(t *testing.T) {
+	server := newTestServer(t)
+	transport := NewTransport(t.TempDir(), 0, nil)
+
+	server.get(t, transport, http.MethodGet, "")
+	resp, body := server.get(t, transport, http.MethodGet, "")
+	if len(server.requests) != 2 || server.requests[1].Header.Get("If-None-Match") != `"v1"` {
+		t.Fatalf("expected a conditional request, got %d requests", len(server.requests))
+	}
+	if body != "first" || resp.Header.Get(HeaderCache) != "REVALIDATED" {
+		t.Errorf("got %q (%s)", body, resp.Header.Get(HeaderCache))
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected cached status 200, got %d", resp.StatusCode)
+	}
+	// 304 响应中的限流信息是新的
+	if resp.Header.Get("X-RateLimit-Remaining") != "98" {
+		t.Errorf("expected fresh rate limit header, got %q", resp.Header.Get("X-RateLimit-Remaining"))
+	}
+}
+
+func TestChangedResponseReplacesEntry(t *testing.T) {
+	server := newTestServer(t)
+	transport := NewTransport(t.TempDir(), 0, nil)
+
+	server.get(t, transport, http.MethodGet, "")
+	server.etag, server.body = `"v2"`, "second"
+	_, body := server.get(t, transport, http.MethodGet, "")
+	if body != "second" {
+		t.Errorf("expected new body, got %q", body)
+	}
+	resp, body := server.get(t, transport, http.MethodGet, "")
+	if body != "second" || resp.Header.Get(HeaderCache) != "REVALIDATED" {
+		t.Errorf("got %q (%s)", body, resp.Header.Get(HeaderCache))
+	}
+	if server.requests[2].Header.Get("If-None-Match") != `"v2"` {
+		t.Errorf("expected revalidation with the new ETag, got %q", server.requests[2].Header.Get("If-None-Match"))
+	}
+}
+
+func TestUncachedRequests(t *testing.T) {
+	cases := []struct {
+		name   string
+		method string
+		auth   [2]string
+	}{
+		{"post", http.MethodPost, [2]string{"", ""}},
+		{"different authorization", http.MethodGet, [2]string{"Bearer token-1", "Bearer token-2"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := newTestServer(t)
+			transport := NewTransport(t.TempDir(), time.Hour, nil)
+			for _, authorization := range c.auth {
+				resp, _ := server.get(t, transport, c.method, authorization)
+				if resp.Header.Get(HeaderCache) != "" {
+					t.Errorf("unexpected cached response: %s", resp.Header.Get(HeaderCache))
+				}
+			}
+			if len(server.requests) != 2 {
+				t.Errorf("expected 2 requests, got %d", len(server.requests))
+			}
+			for _, req := range server.requests {
+				if strings.Contains(req.Header.Get("If-None-Match"), "v1") {
+					t.Errorf("unexpected conditional request")
+				}
+			}
+		})
+	}
+}
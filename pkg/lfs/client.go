@@ -0,0 +1,156 @@
+package lfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"gitar/pkg/utils"
+)
+
+const (
+	MediaType = "application/vnd.git-lfs+json"
+)
+
+type Client struct {
+	endpoint string
+	client   *http.Client
+	token    string
+}
+
+type batchRequest struct {
+	Operation string        `json:"operation"`
+	Transfers []string      `json:"transfers"`
+	Objects   []batchObject `json:"objects"`
+}
+
+type batchObject struct {
+	Oid     string                 `json:"oid"`
+	Size    int64                  `json:"size"`
+	Actions map[string]batchAction `json:"actions,omitempty"`
+	Error   *batchError            `json:"error,omitempty"`
+}
+
+type batchAction struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header,omitempty"`
+}
+
+type batchError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type batchResponse struct {
+	Transfer string        `json:"transfer"`
+	Objects  []batchObject `json:"objects"`
+}
+
+// NewClient 创建 LFS 客户端, endpoint 形如 https://github.com/{owner}/{repo}.git/info/lfs,
+// token 不为空时用于 Batch API 的认证, 私有仓库需要
+func NewClient(httpClient *http.Client, endpoint, token string) *Client {
+	return &Client{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		client:   httpClient,
+		token:    token,
+	}
+}
+
+// Download 通过 Batch API 下载对象并写入 w, 同时校验 SHA-256 和大小
+func (me *Client) Download(ctx context.Context, pointer *Pointer, w io.Writer) error {
+	action, err := me.resolveDownload(ctx, pointer)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, action.Href, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", utils.HttpUserAgent)
+	for key, value := range action.Header {
+		req.Header.Set(key, value)
+	}
+	resp, err := me.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("lfs download %s: http %d", pointer.Oid, resp.StatusCode)
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(w, hash), resp.Body)
+	if err != nil {
+		return err
+	}
+	if size != pointer.Size {
+		return fmt.Errorf("lfs object %s size mismatch: expected %d, got %d", pointer.Oid, pointer.Size, size)
+	}
+	oid := hex.EncodeToString(hash.Sum(nil))
+	if oid != pointer.Oid {
+		return fmt.Errorf("lfs object %s digest mismatch: got %s", pointer.Oid, oid)
+	}
+	return nil
+}
+
+func (me *Client) resolveDownload(ctx context.Context, pointer *Pointer) (*batchAction, error) {
+	body, err := json.Marshal(&batchRequest{
+		Operation: "download",
+		Transfers: []string{"basic"},
+		Objects:   []batchObject{{Oid: pointer.Oid, Size: pointer.Size}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, time.Second*30)
+	defer cancel()
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, me.endpoint+"/objects/batch", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", MediaType)
+	req.Header.Set("Content-Type", MediaType)
+	req.Header.Set("User-Agent", utils.HttpUserAgent)
+	if me.token != "" {
+		// 下载地址通常在其它主机上并带有自己的认证头, Token 只发送给 Batch API
+		req.SetBasicAuth("x-access-token", me.token)
+	}
+	resp, err := me.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("lfs batch: http %d", resp.StatusCode)
+	}
+
+	batch := new(batchResponse)
+	err = json.NewDecoder(resp.Body).Decode(batch)
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range batch.Objects {
+		if object.Oid != pointer.Oid {
+			continue
+		}
+		if object.Error != nil {
+			return nil, fmt.Errorf("lfs object %s: %d %s", object.Oid, object.Error.Code, object.Error.Message)
+		}
+		action, ok := object.Actions["download"]
+		if !ok {
+			return nil, fmt.Errorf("lfs object %s: no download action", object.Oid)
+		}
+		return &action, nil
+	}
+	return nil, fmt.Errorf("lfs object %s: not in batch response", pointer.Oid)
+}
@@ -0,0 +1,144 @@
+package lfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeLfsServer 模拟 Batch API 和对象存储, 对象存储只接受 Batch API 返回的认证头
+func fakeLfsServer(t *testing.T, objects map[string][]byte, token string) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/repo.git/info/lfs/objects/batch":
+			if token != "" {
+				_, password, ok := r.BasicAuth()
+				if !ok || password != token {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+			}
+			if r.Header.Get("Content-Type") != MediaType {
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				return
+			}
+			request := new(batchRequest)
+			if json.NewDecoder(r.Body).Decode(request) != nil || request.Operation != "download" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			response := batchResponse{Transfer: "basic"}
+			for _, object := range request.Objects {
+				if _, found := objects[object.Oid]; !found {
+					object.Error = &batchError{Code: 404, Message: "Object does not exist"}
+				} else {
+					object.Actions = map[string]batchAction{"download": {
+						Href:   server.URL + "/storage/" + object.Oid,
+						Header: map[string]string{"X-Storage-Auth": "signed"},
+					}}
+				}
+				response.Objects = append(response.Objects, object)
+			}
+			w.Header().Set("Content-Type", MediaType)
+			_ = json.NewEncoder(w).Encode(response)
+
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/storage/"):
+			if r.Header.Get("X-Storage-Auth") != "signed" || r.Header.Get("Authorization") != "" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			content, found := objects[strings.TrimPrefix(r.URL.Path, "/storage/")]
+			if !found {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(content)
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newPointer(content []byte) *Pointer {
+	hash := sha256.Sum256(content)
+	return &Pointer{Oid: hex.EncodeToString(hash[:]), Size: int64(len(content))}
+}
+
+func TestClientDownload(t *testing.T) {
+	content := []byte("large binary content")
+	pointer := newPointer(content)
+	server := fakeLfsServer(t, map[string][]byte{pointer.Oid: content}, "secret")
+
+	client := NewClient(&http.Client{}, server.URL+"/repo.git/info/lfs/", "secret")
+	buffer := new(bytes.Buffer)
+	err := client.Download(context.Background(), pointer, buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buffer.Bytes(), content) {
+		t.Errorf("got %q, expected %q", buffer.Bytes(), content)
+	}
+}
+
+func TestClientDownloadErrors(t *testing.T) {
+	content := []byte("large binary content")
+	pointer := newPointer(content)
+	missing := newPointer([]byte("missing"))
+	// 存储中的内容与指针不一致
+	corrupted := newPointer([]byte("expected content"))
+	server := fakeLfsServer(t, map[string][]byte{
+		pointer.Oid:   content,
+		corrupted.Oid: []byte("tampered content"),
+	}, "secret")
+
+	cases := []struct {
+		name    string
+		token   string
+		pointer *Pointer
+		err     string
+	}{
+		{"no token", "", pointer, "http 401"},
+		{"missing object", "secret", missing, "Object does not exist"},
+		{"size mismatch", "secret", &Pointer{Oid: pointer.Oid, Size: pointer.Size + 1}, "size mismatch"},
+		{"digest mismatch", "secret", corrupted, "digest mismatch"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := NewClient(&http.Client{}, server.URL+"/repo.git/info/lfs", c.token)
+			err := client.Download(context.Background(), c.pointer, new(bytes.Buffer))
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("expected error containing %q, got %v", c.err, err)
+			}
+		})
+	}
+}
+
+func TestParsePointer(t *testing.T) {
+	oid := strings.Repeat("a", 64)
+	cases := []struct {
+		content  string
+		expected *Pointer
+	}{
+		{"version https://git-lfs.github.com/spec/v1\noid sha256:" + oid + "\nsize 12345\n", &Pointer{Oid: oid, Size: 12345}},
+		{"version https://git-lfs.github.com/spec/v1\noid sha256:" + oid + "\n", nil},
+		{"version https://git-lfs.github.com/spec/v1\noid sha1:" + oid + "\nsize 1\n", nil},
+		{"oid sha256:" + oid + "\nsize 1\n", nil},
+		{"plain text", nil},
+	}
+	for _, c := range cases {
+		pointer := ParsePointer([]byte(c.content))
+		if (pointer == nil) != (c.expected == nil) || pointer != nil && *pointer != *c.expected {
+			t.Errorf("ParsePointer(%q) = %+v, expected %+v", c.content, pointer, c.expected)
+		}
+	}
+}
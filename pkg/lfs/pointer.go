@@ -0,0 +1,52 @@
+package lfs
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+const (
+	PointerVersion = "https://git-lfs.github.com/spec/v1"
+	MaxPointerSize = 1024
+)
+
+type Pointer struct {
+	Oid  string
+	Size int64
+}
+
+// ParsePointer 解析 LFS 指针文件, 内容不是指针时返回 nil
+func ParsePointer(content []byte) *Pointer {
+	if len(content) > MaxPointerSize || !bytes.HasPrefix(content, []byte("version "+PointerVersion)) {
+		return nil
+	}
+
+	pointer := &Pointer{Size: -1}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), " ")
+		if !found {
+			return nil
+		}
+		switch key {
+		case "oid":
+			oid, found := strings.CutPrefix(value, "sha256:")
+			if !found || len(oid) != 64 {
+				return nil
+			}
+			pointer.Oid = oid
+		case "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || size < 0 {
+				return nil
+			}
+			pointer.Size = size
+		}
+	}
+	if pointer.Oid == "" || pointer.Size < 0 {
+		return nil
+	}
+	return pointer
+}
@@ -0,0 +1,481 @@
+package search
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	// 超过该数量的倒排项时先写入一段, 限制建立大仓库索引时的内存
+	maxPendingPostings = 1 << 23
+)
+
+// Snapshot 是已建立索引的快照
+type Snapshot struct {
+	Id       int64     `db:"id" json:"-"`
+	Platform string    `db:"platform" json:"platform"`
+	Owner    string    `db:"owner" json:"owner"`
+	Repo     string    `db:"repo" json:"repo"`
+	Name     string    `db:"name" json:"name"`
+	Commit   string    `db:"commit" json:"commit"`
+	Sha256   string    `db:"sha256" json:"sha256"`
+	Files    int       `db:"files" json:"files"`
+	Created  time.Time `db:"created" json:"created"`
+}
+
+// Index 是保存在 sqlite 中的三元组倒排索引. 相同内容的文件只保存一次,
+// 每次写入的倒排表作为一段追加, Compact 时合并
+type Index struct {
+	dsn string
+	db  *sqlx.DB
+}
+
+func NewIndex(path string) *Index {
+	// 建立索引时 serve 可能同时在查询
+	return &Index{dsn: path + "?_journal_mode=WAL&_busy_timeout=10000"}
+}
+
+func (me *Index) Open() error {
+	db, err := sqlx.Open("sqlite3", me.dsn)
+	if err != nil {
+		return err
+	}
+	err = db.Ping()
+	if err != nil {
+		_ = db.Close()
+		return err
+	}
+	me.db = db
+	return me.initDatabase()
+}
+
+func (me *Index) Close() error {
+	if me.db == nil {
+		return errors.New("db is not open")
+	}
+	return me.db.Close()
+}
+
+func (me *Index) initDatabase() error {
+	cmd := `
+	CREATE TABLE IF NOT EXISTS [snapshot] (
+		[id]       INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		[platform] TEXT NOT NULL,
+		[owner]    TEXT NOT NULL,
+		[repo]     TEXT NOT NULL,
+		[name]     TEXT NOT NULL,
+		[commit]   TEXT NOT NULL,
+		[sha256]   TEXT NOT NULL,
+		[files]    INTEGER NOT NULL,
+		[created]  DATETIME NOT NULL,
+		UNIQUE([platform], [owner], [repo], [name])
+	);
+
+	CREATE TABLE IF NOT EXISTS [file] (
+		[snapshot] INTEGER NOT NULL,
+		[path]     TEXT NOT NULL,
+		[blob]     INTEGER NOT NULL,
+		PRIMARY KEY([snapshot], [path])
+	);
+
+	CREATE INDEX IF NOT EXISTS [file_blob] ON [file]([blob]);
+
+	CREATE TABLE IF NOT EXISTS [blob] (
+		[id]      INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		[sha256]  TEXT NOT NULL UNIQUE,
+		[size]    INTEGER NOT NULL,
+		[content] BLOB NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS [posting] (
+		[gram]  INTEGER NOT NULL,
+		[blobs] BLOB NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS [posting_gram] ON [posting]([gram]);
+	`
+	_, err := me.db.Exec(cmd)
+	return err
+}
+
+// Snapshots 返回所有已建立索引的快照, 按保存时间从旧到新排列
+func (me *Index) Snapshots() ([]Snapshot, error) {
+	var snapshots []Snapshot
+	err := me.db.Select(&snapshots, "SELECT * FROM [snapshot] ORDER BY [created], [id]")
+	return snapshots, err
+}
+
+// HasSnapshot 判断快照是否已建立索引, 归档内容变化后需要重建
+func (me *Index) HasSnapshot(platform, owner, repo, name, digest string) (bool, error) {
+	var count int
+	err := me.db.Get(&count, `SELECT COUNT(*) FROM [snapshot]
+		WHERE [platform] = ? AND [owner] = ? AND [repo] = ? AND [name] = ? AND [sha256] = ?`,
+		platform, owner, repo, name, digest)
+	return count > 0, err
+}
+
+// Prune 删除 keep 返回 false 的快照和不再被引用的文件内容, 返回删除的快照数量.
+// 倒排表中残留的编号在查询时会被忽略, Compact 时清除
+func (me *Index) Prune(keep func(snapshot Snapshot) bool) (int, error) {
+	snapshots, err := me.Snapshots()
+	if err != nil {
+		return 0, err
+	}
+	tx, err := me.db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer func(tx *sqlx.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	removed := 0
+	for _, snapshot := range snapshots {
+		if keep(snapshot) {
+			continue
+		}
+		err = deleteSnapshot(tx, snapshot.Id)
+		if err != nil {
+			return 0, err
+		}
+		removed++
+	}
+	if removed > 0 {
+		_, err = tx.Exec("DELETE FROM [blob] WHERE [id] NOT IN (SELECT [blob] FROM [file])")
+		if err != nil {
+			return 0, err
+		}
+	}
+	return removed, tx.Commit()
+}
+
+func deleteSnapshot(tx *sqlx.Tx, id int64) error {
+	_, err := tx.Exec("DELETE FROM [file] WHERE [snapshot] = ?", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM [snapshot] WHERE [id] = ?", id)
+	return err
+}
+
+// Compact 每个三元组合并为一行, 去掉已删除的文件内容. 段数不超过 minSegments 倍的三元组数时不处理
+func (me *Index) Compact(minSegments int) (bool, error) {
+	var rows, grams int
+	err := me.db.Get(&rows, "SELECT COUNT(*) FROM [posting]")
+	if err != nil {
+		return false, err
+	}
+	err = me.db.Get(&grams, "SELECT COUNT(DISTINCT [gram]) FROM [posting]")
+	if err != nil {
+		return false, err
+	}
+	if rows <= grams*minSegments {
+		return false, nil
+	}
+
+	var ids []int64
+	err = me.db.Select(&ids, "SELECT [id] FROM [blob]")
+	if err != nil {
+		return false, err
+	}
+	live := make(map[uint32]bool, len(ids))
+	for _, id := range ids {
+		live[uint32(id)] = true
+	}
+
+	tx, err := me.db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer func(tx *sqlx.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+	_, err = tx.Exec("CREATE TEMP TABLE [posting_compact] ([gram] INTEGER NOT NULL, [blobs] BLOB NOT NULL)")
+	if err != nil {
+		return false, err
+	}
+
+	cursor, err := tx.Query("SELECT [gram], [blobs] FROM [posting] ORDER BY [gram], rowid")
+	if err != nil {
+		return false, err
+	}
+	defer func(cursor *sql.Rows) {
+		_ = cursor.Close()
+	}(cursor)
+	var merged []uint32
+	current := int64(-1)
+	flush := func() error {
+		var blobs []uint32
+		for _, id := range sortPosting(merged) {
+			if live[id] {
+				blobs = append(blobs, id)
+			}
+		}
+		merged = merged[:0]
+		if len(blobs) == 0 {
+			return nil
+		}
+		_, err := tx.Exec("INSERT INTO temp.[posting_compact] ([gram], [blobs]) VALUES (?, ?)",
+			current, encodePosting(blobs))
+		return err
+	}
+	for cursor.Next() {
+		var gram int64
+		var blobs []byte
+		err = cursor.Scan(&gram, &blobs)
+		if err != nil {
+			return false, err
+		}
+		if gram != current {
+			err = flush()
+			if err != nil {
+				return false, err
+			}
+			current = gram
+		}
+		merged, err = decodePosting(merged, blobs)
+		if err != nil {
+			return false, err
+		}
+	}
+	err = cursor.Err()
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return false, err
+	}
+	_ = cursor.Close()
+
+	for _, cmd := range []string{
+		"DELETE FROM [posting]",
+		"INSERT INTO [posting] ([gram], [blobs]) SELECT [gram], [blobs] FROM temp.[posting_compact] ORDER BY [gram]",
+		"DROP TABLE temp.[posting_compact]",
+	} {
+		_, err = tx.Exec(cmd)
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+// Writer 在一个事务中为一个快照建立索引, Commit 之前查询不到
+type Writer struct {
+	tx       *sqlx.Tx
+	snapshot int64
+	files    int
+	postings map[uint32][]uint32
+	pending  int
+}
+
+// Begin 开始为快照建立索引, 同名快照的旧索引会被替换
+func (me *Index) Begin(snapshot Snapshot) (*Writer, error) {
+	tx, err := me.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	var id int64
+	err = tx.Get(&id, `SELECT [id] FROM [snapshot]
+		WHERE [platform] = ? AND [owner] = ? AND [repo] = ? AND [name] = ?`,
+		snapshot.Platform, snapshot.Owner, snapshot.Repo, snapshot.Name)
+	if err == nil {
+		err = deleteSnapshot(tx, id)
+	} else if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	result, err := tx.Exec(`INSERT INTO [snapshot] ([platform], [owner], [repo], [name], [commit], [sha256],
+		[files], [created]) VALUES (?, ?, ?, ?, ?, ?, 0, ?)`,
+		snapshot.Platform, snapshot.Owner, snapshot.Repo, snapshot.Name, snapshot.Commit, snapshot.Sha256,
+		snapshot.Created)
+	if err == nil {
+		id, err = result.LastInsertId()
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	return &Writer{
+		tx:       tx,
+		snapshot: id,
+		postings: make(map[uint32][]uint32),
+	}, nil
+}
+
+// Add 添加一个文本文件, 内容相同的文件只保存一次
+func (me *Writer) Add(path string, content []byte) error {
+	hash := sha256.Sum256(content)
+	digest := hex.EncodeToString(hash[:])
+	var id int64
+	err := me.tx.Get(&id, "SELECT [id] FROM [blob] WHERE [sha256] = ?", digest)
+	if errors.Is(err, sql.ErrNoRows) {
+		id, err = me.addBlob(digest, content)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = me.tx.Exec("INSERT OR REPLACE INTO [file] ([snapshot], [path], [blob]) VALUES (?, ?, ?)",
+		me.snapshot, path, id)
+	if err != nil {
+		return err
+	}
+	me.files++
+	return nil
+}
+
+func (me *Writer) addBlob(digest string, content []byte) (int64, error) {
+	var buffer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buffer)
+	_, err := gzipWriter.Write(content)
+	if err == nil {
+		err = gzipWriter.Close()
+	}
+	if err != nil {
+		return 0, err
+	}
+	result, err := me.tx.Exec("INSERT INTO [blob] ([sha256], [size], [content]) VALUES (?, ?, ?)",
+		digest, len(content), buffer.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	grams := make(map[uint32]bool)
+	for i := 0; i+3 <= len(content); i++ {
+		gram, ok := makeGram(content[i], content[i+1], content[i+2])
+		if ok {
+			grams[gram] = true
+		}
+	}
+	for gram := range grams {
+		me.postings[gram] = append(me.postings[gram], uint32(id))
+	}
+	me.pending += len(grams)
+	if me.pending >= maxPendingPostings {
+		return id, me.flush()
+	}
+	return id, nil
+}
+
+func (me *Writer) flush() error {
+	statement, err := me.tx.Prepare("INSERT INTO [posting] ([gram], [blobs]) VALUES (?, ?)")
+	if err != nil {
+		return err
+	}
+	defer func(statement *sql.Stmt) {
+		_ = statement.Close()
+	}(statement)
+	for gram, blobs := range me.postings {
+		_, err = statement.Exec(int64(gram), encodePosting(blobs))
+		if err != nil {
+			return err
+		}
+	}
+	me.postings = make(map[uint32][]uint32)
+	me.pending = 0
+	return nil
+}
+
+// Commit 写入剩余的倒排表并提交, 返回索引的文件数量
+func (me *Writer) Commit() (int, error) {
+	err := me.flush()
+	if err == nil {
+		_, err = me.tx.Exec("UPDATE [snapshot] SET [files] = ? WHERE [id] = ?", me.files, me.snapshot)
+	}
+	if err != nil {
+		_ = me.tx.Rollback()
+		return 0, err
+	}
+	return me.files, me.tx.Commit()
+}
+
+func (me *Writer) Rollback() error {
+	return me.tx.Rollback()
+}
+
+// makeGram 把三个字节合成一个三元组, ASCII 字母统一为小写, 跨行的不建立索引
+func makeGram(a, b, c byte) (uint32, bool) {
+	if a == '\n' || b == '\n' || c == '\n' {
+		return 0, false
+	}
+	return uint32(foldByte(a))<<16 | uint32(foldByte(b))<<8 | uint32(foldByte(c)), true
+}
+
+func foldByte(b byte) byte {
+	if 'A' <= b && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
+
+// encodePosting 把升序的编号编码为差值的 varint 序列
+func encodePosting(blobs []uint32) []byte {
+	buffer := make([]byte, 0, len(blobs)*2)
+	previous := uint32(0)
+	for _, id := range blobs {
+		buffer = binary.AppendUvarint(buffer, uint64(id-previous))
+		previous = id
+	}
+	return buffer
+}
+
+func decodePosting(blobs []uint32, data []byte) ([]uint32, error) {
+	previous := uint64(0)
+	for len(data) > 0 {
+		delta, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid posting list")
+		}
+		previous += delta
+		blobs = append(blobs, uint32(previous))
+		data = data[n:]
+	}
+	return blobs, nil
+}
+
+// sortPosting 排序并去重, 同时运行的多个 Writer 写入的段之间编号可能交错
+func sortPosting(blobs []uint32) []uint32 {
+	if !sort.SliceIsSorted(blobs, func(i, j int) bool { return blobs[i] < blobs[j] }) {
+		sort.Slice(blobs, func(i, j int) bool { return blobs[i] < blobs[j] })
+	}
+	result := blobs[:0]
+	for i, id := range blobs {
+		if i == 0 || id != blobs[i-1] {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+func readBlob(db *sqlx.DB, id uint32) ([]byte, error) {
+	var content []byte
+	err := db.Get(&content, "SELECT [content] FROM [blob] WHERE [id] = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	gzipReader, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(gzipReader)
+}
@@ -0,0 +1,71 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPostingRoundTrip(t *testing.T) {
+	cases := [][]uint32{
+		nil,
+		{1},
+		{1, 2, 3},
+		{5, 130, 131, 20000, 1 << 31},
+	}
+	for _, blobs := range cases {
+		decoded, err := decodePosting(nil, encodePosting(blobs))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(blobs) == 0 && len(decoded) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(decoded, blobs) {
+			t.Errorf("got %v, expected %v", decoded, blobs)
+		}
+	}
+
+	// 多个段依次追加到同一个切片中
+	merged, err := decodePosting([]uint32{1, 4}, encodePosting([]uint32{2, 9}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(merged, []uint32{1, 4, 2, 9}) {
+		t.Errorf("got %v", merged)
+	}
+
+	_, err = decodePosting(nil, []byte{0x80})
+	if err == nil {
+		t.Error("expected error for truncated varint")
+	}
+}
+
+func TestSortPosting(t *testing.T) {
+	cases := []struct {
+		blobs    []uint32
+		expected []uint32
+	}{
+		{[]uint32{}, []uint32{}},
+		{[]uint32{1, 2, 3}, []uint32{1, 2, 3}},
+		{[]uint32{1, 1, 2, 2, 2, 3}, []uint32{1, 2, 3}},
+		{[]uint32{1, 4, 7, 2, 4, 9}, []uint32{1, 2, 4, 7, 9}},
+	}
+	for _, c := range cases {
+		got := sortPosting(append([]uint32{}, c.blobs...))
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("sortPosting(%v) = %v, expected %v", c.blobs, got, c.expected)
+		}
+	}
+}
+
+func TestMakeGram(t *testing.T) {
+	upper, ok := makeGram('A', 'b', 'C')
+	lower, _ := makeGram('a', 'B', 'c')
+	if !ok || upper != lower {
+		t.Errorf("grams should fold ASCII case: %x %x", upper, lower)
+	}
+	_, ok = makeGram('a', '\n', 'b')
+	if ok {
+		t.Error("grams across lines should be skipped")
+	}
+}
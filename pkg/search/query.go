@@ -0,0 +1,115 @@
+package search
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"unicode/utf8"
+)
+
+const (
+	queryAll = iota
+	queryAnd
+	queryOr
+)
+
+// query 是匹配正则表达式的文件必须满足的三元组条件, queryAll 表示无法缩小范围
+type query struct {
+	op    int
+	grams []uint32
+	subs  []*query
+}
+
+var allQuery = &query{op: queryAll}
+
+// compilePattern 编译按行匹配的正则表达式, 同时分析出候选文件必须包含的三元组
+func compilePattern(pattern string, ignoreCase, fixed bool) (*regexp.Regexp, *query, error) {
+	if fixed {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, nil, err
+	}
+	tree, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, nil, err
+	}
+	return re, analyze(tree), nil
+}
+
+func analyze(re *syntax.Regexp) *query {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return literalQuery(re)
+	case syntax.OpCapture, syntax.OpPlus:
+		return analyze(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min >= 1 {
+			return analyze(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		and := &query{op: queryAnd}
+		for _, sub := range re.Sub {
+			if q := analyze(sub); q.op != queryAll {
+				and.subs = append(and.subs, q)
+			}
+		}
+		if len(and.subs) > 0 {
+			return and
+		}
+	case syntax.OpAlternate:
+		or := &query{op: queryOr}
+		for _, sub := range re.Sub {
+			q := analyze(sub)
+			if q.op == queryAll {
+				return allQuery
+			}
+			or.subs = append(or.subs, q)
+		}
+		return or
+	}
+	return allQuery
+}
+
+// literalQuery 返回字面量中所有的三元组, 索引只对 ASCII 字母忽略大小写,
+// 忽略大小写时含有其它字符的字面量不参与过滤. Go 的 (?i) 还会让 k 匹配 U+212A, s 匹配 U+017F,
+// 含有 k 或 s 的三元组在内容中可能不存在, 也不参与过滤
+func literalQuery(re *syntax.Regexp) *query {
+	text := []byte(string(re.Rune))
+	foldCase := re.Flags&syntax.FoldCase != 0
+	if foldCase {
+		for _, b := range text {
+			if b >= utf8.RuneSelf {
+				return allQuery
+			}
+		}
+	}
+	grams := make(map[uint32]bool)
+	q := &query{op: queryAnd}
+	for i := 0; i+3 <= len(text); i++ {
+		if foldCase && (hasUnicodeFold(text[i]) || hasUnicodeFold(text[i+1]) || hasUnicodeFold(text[i+2])) {
+			continue
+		}
+		gram, ok := makeGram(text[i], text[i+1], text[i+2])
+		if ok && !grams[gram] {
+			grams[gram] = true
+			q.grams = append(q.grams, gram)
+		}
+	}
+	if len(q.grams) == 0 {
+		return allQuery
+	}
+	return q
+}
+
+// hasUnicodeFold 判断 ASCII 字母忽略大小写时是否还能匹配非 ASCII 字符
+func hasUnicodeFold(b byte) bool {
+	switch foldByte(b) {
+	case 'k', 's':
+		return true
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package search
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"strings"
+	"testing"
+)
+
+// describe 把查询条件转为便于比较的字符串, 三元组之间是且的关系
+func describe(q *query) string {
+	switch q.op {
+	case queryAnd:
+		var parts []string
+		for _, gram := range q.grams {
+			parts = append(parts, string([]byte{byte(gram >> 16), byte(gram >> 8), byte(gram)}))
+		}
+		for _, sub := range q.subs {
+			parts = append(parts, describe(sub))
+		}
+		return strings.Join(parts, " & ")
+	case queryOr:
+		var parts []string
+		for _, sub := range q.subs {
+			parts = append(parts, describe(sub))
+		}
+		return "(" + strings.Join(parts, " | ") + ")"
+	}
+	return "*"
+}
+
+func TestAnalyze(t *testing.T) {
+	cases := []struct {
+		pattern    string
+		ignoreCase bool
+		fixed      bool
+		expected   string
+	}{
+		{"hello", false, false, "hel & ell & llo"},
+		{"ab", false, false, "*"},
+		{"abcabc", false, false, "abc & bca & cab"},
+		{"foo.*bar", false, false, "foo & bar"},
+		{"foo|bar", false, false, "(foo | bar)"},
+		{"foo|b", false, false, "*"},
+		{"(abc)+", false, false, "abc"},
+		{"(abc){2,3}", false, false, "abc"},
+		{"(abc)*def", false, false, "def"},
+		{"x?abc", false, false, "abc"},
+		{"[a-z]+", false, false, "*"},
+		{"a.b+c", true, true, "a.b & .b+ & b+c"},
+		{"a.b+c", false, false, "*"},
+		{"kubelet", false, false, "kub & ube & bel & ele & let"},
+		{"Hello", true, false, "hel & ell & llo"},
+		// (?i) 时 k 和 s 也能匹配 U+212A 和 U+017F, 含有它们的三元组不参与过滤
+		{"kubelet", true, false, "ube & bel & ele & let"},
+		{"SetStatus", true, false, "tat & atu"},
+		{"sks", true, false, "*"},
+		{"héllo", true, false, "*"},
+		{"héllo", false, false, "h\xc3\xa9 & \xc3\xa9l & \xa9ll & llo"},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%s/%v/%v", c.pattern, c.ignoreCase, c.fixed), func(t *testing.T) {
+			_, q, err := compilePattern(c.pattern, c.ignoreCase, c.fixed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := describe(q); got != c.expected {
+				t.Errorf("got %q, expected %q", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestLiteralQuerySkipsNewline(t *testing.T) {
+	q := literalQuery(&syntax.Regexp{Op: syntax.OpLiteral, Rune: []rune("ab\ncd")})
+	if got := describe(q); got != "*" {
+		t.Errorf("got %q, expected *", got)
+	}
+}
@@ -0,0 +1,289 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// 每次按快照编号查询文件时的参数数量, 低于 sqlite 的参数上限
+	maxQueryParams = 500
+)
+
+type Options struct {
+	Pattern    string
+	IgnoreCase bool
+	Fixed      bool
+	// Snapshots 是查询范围内的快照编号, 为空时查询所有快照
+	Snapshots []int64
+	// Filter 过滤文件路径, 为空时不过滤
+	Filter func(path string) bool
+	// Limit 限制返回的行数, 为 0 时不限制
+	Limit int
+}
+
+// Match 是一行匹配的内容, Line 从 1 开始
+type Match struct {
+	Snapshot *Snapshot `json:"snapshot"`
+	Path     string    `json:"path"`
+	Line     int       `json:"line"`
+	Text     string    `json:"text"`
+}
+
+type indexedFile struct {
+	Snapshot int64  `db:"snapshot"`
+	Path     string `db:"path"`
+	Blob     uint32 `db:"blob"`
+}
+
+type lineMatch struct {
+	line int
+	text string
+}
+
+// Search 先用三元组筛选候选文件, 再逐行匹配. 结果按快照从旧到新, 路径和行号排列,
+// 超过 Limit 时第二个返回值为 true
+func (me *Index) Search(ctx context.Context, opts Options) ([]Match, bool, error) {
+	re, q, err := compilePattern(opts.Pattern, opts.IgnoreCase, opts.Fixed)
+	if err != nil {
+		return nil, false, err
+	}
+
+	snapshots, err := me.Snapshots()
+	if err != nil {
+		return nil, false, err
+	}
+	scope := make(map[int64]*Snapshot, len(snapshots))
+	order := make(map[int64]int, len(snapshots))
+	for i := range snapshots {
+		order[snapshots[i].Id] = i
+		scope[snapshots[i].Id] = &snapshots[i]
+	}
+	if opts.Snapshots != nil {
+		selected := make(map[int64]*Snapshot, len(opts.Snapshots))
+		for _, id := range opts.Snapshots {
+			if snapshot, found := scope[id]; found {
+				selected[id] = snapshot
+			}
+		}
+		scope = selected
+	}
+	if len(scope) == 0 {
+		return nil, false, nil
+	}
+
+	candidates, all, err := me.eval(q, make(map[uint32][]uint32))
+	if err != nil {
+		return nil, false, err
+	}
+	if !all && len(candidates) == 0 {
+		return nil, false, nil
+	}
+	candidateSet := make(map[uint32]bool, len(candidates))
+	for _, id := range candidates {
+		candidateSet[id] = true
+	}
+
+	files, err := me.listFiles(scope)
+	if err != nil {
+		return nil, false, err
+	}
+	byBlob := make(map[uint32][]indexedFile)
+	var blobs []uint32
+	for _, file := range files {
+		if (!all && !candidateSet[file.Blob]) || (opts.Filter != nil && !opts.Filter(file.Path)) {
+			continue
+		}
+		if _, found := byBlob[file.Blob]; !found {
+			blobs = append(blobs, file.Blob)
+		}
+		byBlob[file.Blob] = append(byBlob[file.Blob], file)
+	}
+
+	var matches []Match
+	for _, blob := range blobs {
+		if ctx.Err() != nil {
+			return nil, false, ctx.Err()
+		}
+		content, err := readBlob(me.db, blob)
+		if err != nil {
+			return nil, false, err
+		}
+		lines := matchLines(re, content)
+		for _, file := range byBlob[blob] {
+			for _, line := range lines {
+				matches = append(matches, Match{
+					Snapshot: scope[file.Snapshot],
+					Path:     file.Path,
+					Line:     line.line,
+					Text:     line.text,
+				})
+			}
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.Snapshot.Id != b.Snapshot.Id {
+			return order[a.Snapshot.Id] < order[b.Snapshot.Id]
+		}
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Line < b.Line
+	})
+	if opts.Limit > 0 && len(matches) > opts.Limit {
+		return matches[:opts.Limit], true, nil
+	}
+	return matches, false, nil
+}
+
+// eval 返回满足条件的文件内容编号, 第二个返回值为 true 表示无法缩小范围
+func (me *Index) eval(q *query, cache map[uint32][]uint32) ([]uint32, bool, error) {
+	switch q.op {
+	case queryAnd:
+		var result []uint32
+		first := true
+		intersect := func(blobs []uint32) {
+			if first {
+				result, first = blobs, false
+				return
+			}
+			result = intersectPosting(result, blobs)
+		}
+		for _, gram := range q.grams {
+			blobs, err := me.posting(gram, cache)
+			if err != nil {
+				return nil, false, err
+			}
+			intersect(blobs)
+		}
+		for _, sub := range q.subs {
+			blobs, all, err := me.eval(sub, cache)
+			if err != nil {
+				return nil, false, err
+			}
+			if !all {
+				intersect(blobs)
+			}
+		}
+		return result, first, nil
+	case queryOr:
+		var result []uint32
+		for _, sub := range q.subs {
+			blobs, all, err := me.eval(sub, cache)
+			if err != nil || all {
+				return nil, all, err
+			}
+			result = unionPosting(result, blobs)
+		}
+		return result, false, nil
+	}
+	return nil, true, nil
+}
+
+func (me *Index) posting(gram uint32, cache map[uint32][]uint32) ([]uint32, error) {
+	if blobs, found := cache[gram]; found {
+		return blobs, nil
+	}
+	var segments [][]byte
+	err := me.db.Select(&segments, "SELECT [blobs] FROM [posting] WHERE [gram] = ? ORDER BY rowid", int64(gram))
+	if err != nil {
+		return nil, err
+	}
+	var blobs []uint32
+	for _, segment := range segments {
+		blobs, err = decodePosting(blobs, segment)
+		if err != nil {
+			return nil, err
+		}
+	}
+	blobs = sortPosting(blobs)
+	cache[gram] = blobs
+	return blobs, nil
+}
+
+func (me *Index) listFiles(scope map[int64]*Snapshot) ([]indexedFile, error) {
+	ids := make([]int64, 0, len(scope))
+	for id := range scope {
+		ids = append(ids, id)
+	}
+	var files []indexedFile
+	for start := 0; start < len(ids); start += maxQueryParams {
+		end := min(start+maxQueryParams, len(ids))
+		cmd, args, err := sqlx.In("SELECT [snapshot], [path], [blob] FROM [file] WHERE [snapshot] IN (?)",
+			ids[start:end])
+		if err != nil {
+			return nil, err
+		}
+		var batch []indexedFile
+		err = me.db.Select(&batch, cmd, args...)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, batch...)
+	}
+	return files, nil
+}
+
+// matchLines 逐行匹配, 返回的内容去掉了行尾的换行符
+func matchLines(re *regexp.Regexp, content []byte) []lineMatch {
+	var lines []lineMatch
+	number := 0
+	for len(content) > 0 {
+		number++
+		line := content
+		if i := bytes.IndexByte(content, '\n'); i >= 0 {
+			line, content = content[:i], content[i+1:]
+		} else {
+			content = nil
+		}
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if re.Match(line) {
+			lines = append(lines, lineMatch{line: number, text: string(line)})
+		}
+	}
+	return lines
+}
+
+func intersectPosting(a, b []uint32) []uint32 {
+	var result []uint32
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+func unionPosting(a, b []uint32) []uint32 {
+	result := make([]uint32, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			result = append(result, a[i])
+			i++
+		case a[i] > b[j]:
+			result = append(result, b[j])
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	result = append(result, a[i:]...)
+	return append(result, b[j:]...)
+}
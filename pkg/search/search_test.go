@@ -0,0 +1,156 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestIndex(t *testing.T) *Index {
+	index := NewIndex(filepath.Join(t.TempDir(), "search.sqlite"))
+	err := index.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = index.Close()
+	})
+	return index
+}
+
+func addSnapshot(t *testing.T, index *Index, name string, created time.Time, files map[string]string) {
+	writer, err := index.Begin(Snapshot{
+		Platform: "github", Owner: "owner", Repo: "repo", Name: name, Commit: name, Sha256: name, Created: created,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range files {
+		err = writer.Add(path, []byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	count, err := writer.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(files) {
+		t.Fatalf("indexed %d files, expected %d", count, len(files))
+	}
+}
+
+func formatMatches(matches []Match) string {
+	var lines []string
+	for _, match := range matches {
+		lines = append(lines, fmt.Sprintf("%s:%s:%d:%s", match.Snapshot.Name, match.Path, match.Line, match.Text))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestSearch(t *testing.T) {
+	index := newTestIndex(t)
+	now := time.Now()
+	readme := "# Kubelet\n\nThe node agent.\n"
+	addSnapshot(t, index, "repo-v1", now.Add(-time.Hour), map[string]string{
+		"README.md": readme,
+		"main.go":   "package main\n\nfunc NewKubeletCommand() {\n}\n",
+	})
+	addSnapshot(t, index, "repo-v2", now, map[string]string{
+		"README.md":   readme,
+		"main.go":     "package main\n\nfunc NewKubeletCommand(ctx context.Context) {\n}\n\nfunc run() {}\n",
+		"unicode.txt": "\u212Aelvin sign\n",
+	})
+
+	snapshots, err := index.Snapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Name != "repo-v1" {
+		t.Fatalf("unexpected snapshots: %+v", snapshots)
+	}
+
+	cases := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{"literal in both snapshots", Options{Pattern: "NewKubeletCommand"},
+			"repo-v1:main.go:3:func NewKubeletCommand() {\nrepo-v2:main.go:3:func NewKubeletCommand(ctx context.Context) {"},
+		{"regexp", Options{Pattern: `func \w+\(\)`},
+			"repo-v1:main.go:3:func NewKubeletCommand() {\nrepo-v2:main.go:6:func run() {}"},
+		{"fixed", Options{Pattern: "Command(ctx", Fixed: true},
+			"repo-v2:main.go:3:func NewKubeletCommand(ctx context.Context) {"},
+		{"ignore case", Options{Pattern: "# kubelet", IgnoreCase: true},
+			"repo-v1:README.md:1:# Kubelet\nrepo-v2:README.md:1:# Kubelet"},
+		{"kelvin sign", Options{Pattern: "kelvin", IgnoreCase: true},
+			"repo-v2:unicode.txt:1:\u212Aelvin sign"},
+		{"alternation", Options{Pattern: "node agent|context"},
+			"repo-v1:README.md:3:The node agent.\nrepo-v2:README.md:3:The node agent.\n" +
+				"repo-v2:main.go:3:func NewKubeletCommand(ctx context.Context) {"},
+		{"snapshot scope", Options{Pattern: "NewKubeletCommand", Snapshots: []int64{snapshots[1].Id}},
+			"repo-v2:main.go:3:func NewKubeletCommand(ctx context.Context) {"},
+		{"path filter", Options{Pattern: "ubelet", Filter: func(path string) bool { return path == "README.md" }},
+			"repo-v1:README.md:1:# Kubelet\nrepo-v2:README.md:1:# Kubelet"},
+		{"no match", Options{Pattern: "not in any file"}, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			matches, truncated, err := index.Search(context.Background(), c.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if truncated {
+				t.Error("unexpected truncation")
+			}
+			if got := formatMatches(matches); got != c.expected {
+				t.Errorf("got:\n%s\nexpected:\n%s", got, c.expected)
+			}
+		})
+	}
+
+	matches, truncated, err := index.Search(context.Background(), Options{Pattern: "Kubelet", Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 2 || !truncated {
+		t.Errorf("expected 2 matches and truncation, got %d %v", len(matches), truncated)
+	}
+}
+
+func TestPruneAndCompact(t *testing.T) {
+	index := newTestIndex(t)
+	now := time.Now()
+	for i := 1; i <= 3; i++ {
+		addSnapshot(t, index, fmt.Sprintf("repo-v%d", i), now.Add(time.Duration(i)*time.Minute), map[string]string{
+			"version.go": fmt.Sprintf("const Version = \"v%d\"\n", i),
+		})
+	}
+
+	removed, err := index.Prune(func(snapshot Snapshot) bool {
+		return snapshot.Name != "repo-v2"
+	})
+	if err != nil || removed != 1 {
+		t.Fatalf("removed %d, err %v", removed, err)
+	}
+	compacted, err := index.Compact(1)
+	if err != nil || !compacted {
+		t.Fatalf("compacted %v, err %v", compacted, err)
+	}
+	compacted, err = index.Compact(1)
+	if err != nil || compacted {
+		t.Fatalf("second compact should do nothing, got %v %v", compacted, err)
+	}
+
+	matches, _, err := index.Search(context.Background(), Options{Pattern: `Version = "v\d"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "repo-v1:version.go:1:const Version = \"v1\"\nrepo-v3:version.go:1:const Version = \"v3\""
+	if got := formatMatches(matches); got != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", got, expected)
+	}
+}
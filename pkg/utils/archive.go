@@ -1,14 +1,22 @@
 package utils
 
 import (
+	"archive/tar"
+	"bytes"
 	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 )
 
-func Gzip2Xz(gzipPath, xzPath string) error {
+// Gzip2Xz 将 tar.gz 转为 tar.xz, 转码的同时检查 tar 内容是否符合预期
+func Gzip2Xz(ctx context.Context, gzipPath, xzPath string, expect ArchiveExpectation) error {
 	gzipFile, err := os.Open(gzipPath)
 	if err != nil {
 		return err
@@ -45,13 +53,285 @@ func Gzip2Xz(gzipPath, xzPath string) error {
 		}
 	}(xzFile)
 
-	xzCmd := exec.Command("xz", "-c", "-")
-	xzCmd.Stdin = gzipReader
+	pipeReader, pipeWriter := io.Pipe()
+	verified := make(chan error, 1)
+	go func() {
+		err := verifyTar(pipeReader, expect)
+		_ = pipeReader.CloseWithError(err)
+		verified <- err
+	}()
+
+	xzCmd := exec.CommandContext(ctx, "xz", "-c", "-")
+	xzCmd.Stdin = io.TeeReader(gzipReader, pipeWriter)
+	xzCmd.Stdout = xzFile
+
+	err = xzCmd.Start()
+	if err != nil {
+		_ = pipeWriter.Close()
+		return err
+	}
+	err = xzCmd.Wait()
+	_ = pipeWriter.Close()
+	verifyErr := <-verified
+	if verifyErr != nil && errors.Is(verifyErr, ErrArchiveMismatch) {
+		return verifyErr
+	}
+	if verifyErr != nil && err == nil {
+		return fmt.Errorf("%w: %s", ErrArchiveMismatch, verifyErr.Error())
+	}
+	return err
+}
+
+// ReadTarXzEntry 读取 tar.xz 中去掉顶层目录后路径为 name 的文件, 不存在时返回 nil
+func ReadTarXzEntry(xzPath, name string) ([]byte, error) {
+	reader, err := OpenTarXz(xzPath)
+	if err != nil {
+		return nil, err
+	}
+	content, err := ReadTarEntry(reader, name)
+	closeErr := reader.Close()
+	if err != nil {
+		return nil, err
+	}
+	if content == nil && closeErr != nil {
+		return nil, closeErr
+	}
+	return content, nil
+}
+
+type decompressReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+// Close 提前结束读取时解压程序会因管道关闭退出, 只有读完时才关心它的退出状态
+func (me *decompressReader) Close() error {
+	_, _ = io.Copy(io.Discard, me.ReadCloser)
+	return me.cmd.Wait()
+}
+
+// OpenTarXz 通过 xz 解压, 返回 tar 流
+func OpenTarXz(xzPath string) (io.ReadCloser, error) {
+	return openDecompress(exec.Command("xz", "-d", "-c", xzPath))
+}
+
+// OpenTarZst 通过 zstd 解压, 返回 tar 流
+func OpenTarZst(zstPath string) (io.ReadCloser, error) {
+	return openDecompress(exec.Command("zstd", "-d", "-c", "-q", zstPath))
+}
+
+func openDecompress(cmd *exec.Cmd) (io.ReadCloser, error) {
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	return &decompressReader{ReadCloser: stdout, cmd: cmd}, nil
+}
+
+// CompressXz 将 r 的内容压缩写入 xzPath
+func CompressXz(ctx context.Context, r io.Reader, xzPath string) error {
+	xzFile, err := os.Create(xzPath)
+	if err != nil {
+		return err
+	}
+	defer func(xzFile *os.File) {
+		err := xzFile.Close()
+		if err != nil {
+			logrus.Error(err)
+		}
+	}(xzFile)
+
+	xzCmd := exec.CommandContext(ctx, "xz", "-c", "-")
+	xzCmd.Stdin = r
+	xzCmd.Stdout = xzFile
+	return xzCmd.Run()
+}
+
+// ReadTarEntry 读取 tar 流中去掉顶层目录后路径为 name 的文件, 不存在时返回 nil
+func ReadTarEntry(r io.Reader, name string) ([]byte, error) {
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		_, entryName, found := strings.Cut(header.Name, "/")
+		if found && entryName == name {
+			return io.ReadAll(tarReader)
+		}
+	}
+}
+
+// TarRewriteFunc 用于在转码时替换较小的文件内容, 不需要替换时返回 nil
+type TarRewriteFunc func(header *tar.Header, content []byte) (io.ReadCloser, int64, error)
+
+const (
+	MaxRewriteSize = 1024
+)
+
+// Gzip2XzRewrite 解析 tar 流并重新打包为 xz, 小于 MaxRewriteSize 的文件交给 rewrite 处理
+func Gzip2XzRewrite(ctx context.Context, gzipPath, xzPath string, expect ArchiveExpectation,
+	rewrite TarRewriteFunc) error {
+	gzipFile, err := os.Open(gzipPath)
+	if err != nil {
+		return err
+	}
+
+	defer func(gzipFile *os.File) {
+		err := gzipFile.Close()
+		if err != nil {
+			logrus.Error(err)
+		}
+	}(gzipFile)
+
+	gzipReader, err := gzip.NewReader(gzipFile)
+	if err != nil {
+		return err
+	}
+
+	defer func(gzipReader *gzip.Reader) {
+		err := gzipReader.Close()
+		if err != nil {
+			logrus.Error(err)
+		}
+	}(gzipReader)
+
+	xzFile, err := os.Create(xzPath)
+	if err != nil {
+		return err
+	}
+
+	defer func(xzFile *os.File) {
+		err := xzFile.Close()
+		if err != nil {
+			logrus.Error(err)
+		}
+	}(xzFile)
+
+	xzCmd := exec.CommandContext(ctx, "xz", "-c", "-")
+	xzStdin, err := xzCmd.StdinPipe()
+	if err != nil {
+		return err
+	}
 	xzCmd.Stdout = xzFile
 
 	err = xzCmd.Start()
 	if err != nil {
 		return err
 	}
-	return xzCmd.Wait()
+
+	err = rewriteTar(gzipReader, xzStdin, newArchiveVerifier(expect), rewrite)
+	closeErr := xzStdin.Close()
+	waitErr := xzCmd.Wait()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return waitErr
+}
+
+func rewriteTar(r io.Reader, w io.Writer, verifier *archiveVerifier, rewrite TarRewriteFunc) error {
+	tarReader := tar.NewReader(r)
+	tarWriter := tar.NewWriter(w)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		err = verifier.check(header)
+		if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg || header.Size > MaxRewriteSize {
+			err = copyTarEntry(tarWriter, header, tarReader)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		content, err := io.ReadAll(tarReader)
+		if err != nil {
+			return err
+		}
+		replaced, size, err := rewrite(header, content)
+		if err != nil {
+			return err
+		}
+		if replaced == nil {
+			err = copyTarEntry(tarWriter, header, bytes.NewReader(content))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		header.Size = size
+		err = copyTarEntry(tarWriter, header, replaced)
+		closeErr := replaced.Close()
+		if err != nil {
+			return err
+		}
+		if closeErr != nil {
+			return closeErr
+		}
+	}
+	err := verifier.finish()
+	if err != nil {
+		return err
+	}
+	return tarWriter.Close()
+}
+
+func copyTarEntry(tarWriter *tar.Writer, header *tar.Header, r io.Reader) error {
+	err := tarWriter.WriteHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tarWriter, r)
+	return err
+}
+
+// ReadTarGzCommit 读取 git archive 生成的 tar.gz 中 pax 全局头的 comment 字段, 即 Commit ID
+func ReadTarGzCommit(gzipPath string) (string, error) {
+	gzipFile, err := os.Open(gzipPath)
+	if err != nil {
+		return "", err
+	}
+	defer func(gzipFile *os.File) {
+		_ = gzipFile.Close()
+	}(gzipFile)
+
+	gzipReader, err := gzip.NewReader(gzipFile)
+	if err != nil {
+		return "", err
+	}
+	defer func(gzipReader *gzip.Reader) {
+		_ = gzipReader.Close()
+	}(gzipReader)
+
+	header, err := tar.NewReader(gzipReader).Next()
+	if err != nil {
+		return "", err
+	}
+	if header.Typeflag != tar.TypeXGlobalHeader {
+		return "", nil
+	}
+	return header.PAXRecords["comment"], nil
 }
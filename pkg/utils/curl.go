@@ -1,11 +1,12 @@
 package utils
 
 import (
+	"context"
 	"os"
 	"os/exec"
 )
 
-func CurlDownload(url string, dir string, file string, maxTries int) error {
+func CurlDownload(ctx context.Context, url string, dir string, file string, maxTries int) error {
 	if maxTries < 0 {
 		maxTries = 99999999
 	} else if maxTries < 1 {
@@ -21,17 +22,34 @@ func CurlDownload(url string, dir string, file string, maxTries int) error {
 
 	var err error
 	for i := 0; i < maxTries; i++ {
-		err = execCurl(dir, args, true)
+		err = execCurl(ctx, dir, args, true)
 		if err == nil {
 			return nil
 		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 	}
 
 	return err
 }
 
-func execCurl(dir string, args []string, redirect bool) error {
-	cmd := exec.Command("curl", args...)
+// CurlResume 从已有的部分文件继续下载, 只尝试一次, 服务端不支持断点续传时返回错误
+func CurlResume(ctx context.Context, url string, dir string, file string) error {
+	args := []string{
+		"--location",
+		"--fail",
+		"--continue-at",
+		"-",
+		"--output",
+		file,
+		url,
+	}
+	return execCurl(ctx, dir, args, true)
+}
+
+func execCurl(ctx context.Context, dir string, args []string, redirect bool) error {
+	cmd := exec.CommandContext(ctx, "curl", args...)
 	cmd.Dir = dir
 	if redirect {
 		cmd.Stdin = os.Stdin
@@ -1,10 +1,20 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"io"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"syscall"
+)
+
+const (
+	// PartialSuffix 是 MoveFile 跨文件系统复制时临时文件的后缀
+	PartialSuffix = ".partial"
 )
 
 func FileExists(filename string) (bool, error) {
@@ -36,18 +46,96 @@ func CopyFile(srcPath, dstPath string) error {
 	return err
 }
 
+// MoveFile 原子地将文件移动到 dstPath, 崩溃时 dstPath 要么不存在要么是完整的文件.
+// 同一文件系统内同步后直接重命名, 跨文件系统时先复制到目标目录中的临时文件再重命名
 func MoveFile(srcPath, dstPath string) error {
 	if runtime.GOOS == "windows" {
 		return os.Rename(srcPath, dstPath)
 	}
 
-	err := CopyFile(srcPath, dstPath)
+	err := syncFile(srcPath)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(srcPath, dstPath)
+	if err == nil {
+		return syncDir(filepath.Dir(dstPath))
+	}
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	tempPath, err := copyToSibling(srcPath, dstPath)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tempPath, dstPath)
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+	err = syncDir(filepath.Dir(dstPath))
 	if err != nil {
 		return err
 	}
 	return os.RemoveAll(srcPath)
 }
 
+// copyToSibling 将文件复制到 dstPath 同目录下的临时文件并同步到磁盘
+func copyToSibling(srcPath, dstPath string) (string, error) {
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		return "", err
+	}
+	defer func(srcFile *os.File) {
+		_ = srcFile.Close()
+	}(srcFile)
+
+	tempFile, err := os.CreateTemp(filepath.Dir(dstPath), "."+filepath.Base(dstPath)+".*"+PartialSuffix)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(tempFile, srcFile)
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	closeErr := tempFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tempFile.Name())
+		return "", err
+	}
+	return tempFile.Name(), nil
+}
+
+func syncFile(filename string) error {
+	file, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
 func GetFileSize(filename string) (int, error) {
 	info, err := os.Stat(filename)
 	if err != nil {
@@ -58,3 +146,47 @@ func GetFileSize(filename string) (int, error) {
 	}
 	return int(info.Size()), nil
 }
+
+func FileSha256(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ReaderSha256 读取 r 的全部内容, 返回 SHA-256 和长度
+func ReaderSha256(r io.Reader) (string, int64, error) {
+	hash := sha256.New()
+	size, err := io.Copy(hash, r)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// ReadLines 读取文件中的非空行, 忽略 # 开头的注释
+func ReadLines(filename string) ([]string, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	lines := []string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
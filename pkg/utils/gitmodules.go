@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+type GitSubmodule struct {
+	Name   string
+	Path   string
+	Url    string
+	Branch string
+}
+
+// ParseGitModules 解析 .gitmodules 文件内容
+func ParseGitModules(content []byte) ([]GitSubmodule, error) {
+	sectionRe := regexp.MustCompile(`^\[submodule\s+"(.+)"\]$`)
+	modules := []GitSubmodule{}
+	var current *GitSubmodule
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		match := sectionRe.FindStringSubmatch(line)
+		if match != nil {
+			if current != nil {
+				modules = append(modules, *current)
+			}
+			current = &GitSubmodule{Name: match[1]}
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if current != nil {
+				modules = append(modules, *current)
+			}
+			current = nil
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		switch key {
+		case "path":
+			current.Path = value
+		case "url":
+			current.Url = value
+		case "branch":
+			current.Branch = value
+		}
+	}
+	if current != nil {
+		modules = append(modules, *current)
+	}
+	return modules, scanner.Err()
+}
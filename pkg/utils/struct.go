@@ -24,3 +24,10 @@ func NewStringSet(values []string) *StringSet {
 	}
 	return ss
 }
+
+func (s *StringSet) Add(value string) {
+	if s.values == nil {
+		s.values = map[string]any{}
+	}
+	s.values[value] = true
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	ErrArchiveMismatch = errors.New("archive mismatch")
+)
+
+// ArchiveExpectation 描述下载的归档应满足的条件, 字段为空时不检查
+type ArchiveExpectation struct {
+	// git archive 在 pax 全局头的 comment 中写入的 Commit ID
+	Commit string
+	// 顶层目录名的前缀, GitHub 为 {repo}-
+	DirPrefix string
+}
+
+type archiveVerifier struct {
+	expect  ArchiveExpectation
+	entries int
+	topDir  string
+	commit  string
+}
+
+func newArchiveVerifier(expect ArchiveExpectation) *archiveVerifier {
+	return &archiveVerifier{expect: expect}
+}
+
+func (me *archiveVerifier) check(header *tar.Header) error {
+	me.entries++
+	if header.Typeflag == tar.TypeXGlobalHeader {
+		if me.entries == 1 {
+			me.commit = header.PAXRecords["comment"]
+			if me.expect.Commit != "" && me.commit != me.expect.Commit {
+				return fmt.Errorf("%w: expected commit %s, got %q", ErrArchiveMismatch, me.expect.Commit, me.commit)
+			}
+		}
+		return nil
+	}
+
+	topDir, _, _ := strings.Cut(header.Name, "/")
+	if me.topDir == "" {
+		me.topDir = topDir
+		prefix := me.expect.DirPrefix
+		if prefix != "" && !strings.HasPrefix(strings.ToLower(topDir), strings.ToLower(prefix)) {
+			return fmt.Errorf("%w: unexpected top-level directory %q", ErrArchiveMismatch, topDir)
+		}
+	} else if topDir != me.topDir {
+		return fmt.Errorf("%w: multiple top-level directories %q and %q", ErrArchiveMismatch, me.topDir, topDir)
+	}
+	return nil
+}
+
+func (me *archiveVerifier) finish() error {
+	if me.expect.Commit != "" && me.commit == "" {
+		return fmt.Errorf("%w: no commit in pax global header", ErrArchiveMismatch)
+	}
+	if me.topDir == "" {
+		return fmt.Errorf("%w: empty archive", ErrArchiveMismatch)
+	}
+	return nil
+}
+
+// verifyTar 读取整个 tar 流并检查
+func verifyTar(r io.Reader, expect ArchiveExpectation) error {
+	verifier := newArchiveVerifier(expect)
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		err = verifier.check(header)
+		if err != nil {
+			return err
+		}
+	}
+	// tar 结束标记后的填充数据也需要读完, 否则写入端会阻塞
+	_, err := io.Copy(io.Discard, r)
+	if err != nil {
+		return err
+	}
+	return verifier.finish()
+}
@@ -0,0 +1,145 @@
+package version
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Version 从 Tag 名称中解析出的版本号, 支持 semver, v 前缀, repo-1.2.3 及 calver 等形式
+type Version struct {
+	Raw        string
+	Prefix     string
+	Numbers    []int
+	Prerelease string
+	Build      string
+	Calver     bool
+}
+
+const versionPattern = `[vV]?(\d+(?:\.\d+)*)(?:[-_.]?((?:alpha|beta|rc|pre|preview|dev|snapshot|[a-z])[0-9A-Za-z.\-]*|-[0-9A-Za-z.\-]+))?(?:\+([0-9A-Za-z.\-]+))?$`
+
+var (
+	// 前缀以分隔符结尾, 如 repo-1.2.3, api/v1.2.0
+	separatedRe = regexp.MustCompile(`^(|.*?[^0-9A-Za-z.])` + versionPattern)
+	// 前缀只有字母, 如 go1.21.0
+	lettersRe = regexp.MustCompile(`^([A-Za-z]*)` + versionPattern)
+)
+
+// Parse 解析版本号, 无法识别时返回 nil
+func Parse(tag string) *Version {
+	match := separatedRe.FindStringSubmatch(tag)
+	if match == nil {
+		match = lettersRe.FindStringSubmatch(tag)
+	}
+	if match == nil {
+		return nil
+	}
+	prefix := match[1]
+
+	parts := strings.Split(match[2], ".")
+	numbers := make([]int, 0, len(parts))
+	for _, part := range parts {
+		num, err := strconv.Atoi(part)
+		if err != nil {
+			return nil
+		}
+		numbers = append(numbers, num)
+	}
+
+	v := &Version{
+		Raw:        tag,
+		Prefix:     prefix,
+		Numbers:    numbers,
+		Prerelease: strings.TrimPrefix(match[3], "-"),
+		Build:      match[4],
+	}
+	// 2023.06.01 或 20230601 视为日期版本
+	if numbers[0] >= 1970 && numbers[0] <= 9999 || numbers[0] >= 19700101 && numbers[0] <= 99991231 {
+		v.Calver = true
+	}
+	return v
+}
+
+func (me *Version) IsPrerelease() bool {
+	return me.Prerelease != ""
+}
+
+func (me *Version) String() string {
+	return me.Raw
+}
+
+// Compare 比较两个版本号, 规则与 semver 的优先级一致, 忽略 Build 部分.
+// 前缀不同的版本不属于同一系列, 只按前缀排序使结果稳定; 日期版本排在普通版本之后
+func Compare(a, b *Version) int {
+	if a.Prefix != b.Prefix {
+		return strings.Compare(a.Prefix, b.Prefix)
+	}
+	if a.Calver != b.Calver {
+		if a.Calver {
+			return 1
+		}
+		return -1
+	}
+
+	n := len(a.Numbers)
+	if len(b.Numbers) > n {
+		n = len(b.Numbers)
+	}
+	for i := 0; i < n; i++ {
+		x, y := 0, 0
+		if i < len(a.Numbers) {
+			x = a.Numbers[i]
+		}
+		if i < len(b.Numbers) {
+			y = b.Numbers[i]
+		}
+		if x != y {
+			return compareInt(x, y)
+		}
+	}
+
+	if a.Prerelease == b.Prerelease {
+		return 0
+	}
+	if a.Prerelease == "" {
+		return 1
+	}
+	if b.Prerelease == "" {
+		return -1
+	}
+	return comparePrerelease(a.Prerelease, b.Prerelease)
+}
+
+func comparePrerelease(a, b string) int {
+	x := strings.Split(a, ".")
+	y := strings.Split(b, ".")
+	for i := 0; i < len(x) && i < len(y); i++ {
+		xn, xErr := strconv.Atoi(x[i])
+		yn, yErr := strconv.Atoi(y[i])
+		switch {
+		case xErr == nil && yErr == nil:
+			if xn != yn {
+				return compareInt(xn, yn)
+			}
+		case xErr == nil:
+			return -1
+		case yErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return compareInt(len(x), len(y))
+}
+
+func compareInt(x, y int) int {
+	if x < y {
+		return -1
+	}
+	if x > y {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,74 @@
+package version
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		tag        string
+		prefix     string
+		numbers    []int
+		prerelease string
+		calver     bool
+	}{
+		{"v1.2.3", "", []int{1, 2, 3}, "", false},
+		{"1.2", "", []int{1, 2}, "", false},
+		{"v1.0.0-rc.1", "", []int{1, 0, 0}, "rc.1", false},
+		{"v2.0.0beta2", "", []int{2, 0, 0}, "beta2", false},
+		{"repo-1.2.3", "repo-", []int{1, 2, 3}, "", false},
+		{"api/v1.2.0", "api/", []int{1, 2, 0}, "", false},
+		{"go1.21.0", "go", []int{1, 21, 0}, "", false},
+		{"2023.06.01", "", []int{2023, 6, 1}, "", true},
+		{"release-20230601", "release-", []int{20230601}, "", true},
+	}
+	for _, c := range cases {
+		v := Parse(c.tag)
+		if v == nil {
+			t.Errorf("Parse(%s) = nil", c.tag)
+			continue
+		}
+		if v.Prefix != c.prefix || !reflect.DeepEqual(v.Numbers, c.numbers) || v.Prerelease != c.prerelease ||
+			v.Calver != c.calver {
+			t.Errorf("Parse(%s) = %+v", c.tag, v)
+		}
+	}
+
+	for _, tag := range []string{"latest", "nightly", ""} {
+		if v := Parse(tag); v != nil {
+			t.Errorf("Parse(%q) = %+v, expected nil", tag, v)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"v1.2.3", "v1.2.3", 0},
+		{"v1.2.3", "1.2.3", 0},
+		{"v1.2", "v1.2.0", 0},
+		{"v1.10.0", "v1.9.0", 1},
+		{"v1.0.0-rc.1", "v1.0.0", -1},
+		{"v1.0.0-alpha", "v1.0.0-beta", -1},
+		{"v1.0.0-rc.2", "v1.0.0-rc.10", -1},
+		{"v1.0.0+build.1", "v1.0.0+build.2", 0},
+		{"2023.06.01", "2023.10.01", -1},
+		// 日期版本排在普通版本之后
+		{"v99.0.0", "2023.01.01", -1},
+		// 前缀不同时只按前缀排序
+		{"api/v9.0.0", "v1.0.0", 1},
+		{"sdk-1.0.0", "server-0.1.0", -1},
+	}
+	for _, c := range cases {
+		got := Compare(Parse(c.a), Parse(c.b))
+		if got != c.expected {
+			t.Errorf("Compare(%s, %s) = %d, expected %d", c.a, c.b, got, c.expected)
+		}
+		if reverse := Compare(Parse(c.b), Parse(c.a)); reverse != -c.expected {
+			t.Errorf("Compare(%s, %s) = %d, expected %d", c.b, c.a, reverse, -c.expected)
+		}
+	}
+}